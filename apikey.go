@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const apiKeyUsage = "usage: givedirectly [flags] apikey [create [-patron id] [-role role] name | list | revoke id]"
+
+// runAPIKey runs the apikey subcommand against the configured postgres database
+func runAPIKey(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	sqlStore, err := openSQLStore(ctx, "apikey")
+	if err != nil {
+		return err
+	}
+	defer sqlStore.Close()
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		patronID := flags.Int("patron", 0, "the patron the key acts for")
+		roleName := flags.String("role", string(auth.RolePatron), "the role of the key, one of patron, librarian or admin")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New(apiKeyUsage)
+		}
+
+		role, err := auth.ParseKeyRole(*roleName, *patronID)
+		if err != nil {
+			return err
+		}
+
+		key, err := auth.GenerateAPIKey()
+		if err != nil {
+			return err
+		}
+
+		created, err := sqlStore.CreateAPIKey(ctx, &types.APIKey{
+			Name:     flags.Arg(0),
+			PatronID: *patronID,
+			Role:     string(role),
+			Hash:     auth.HashAPIKey(key),
+		})
+		if err != nil {
+			return err
+		}
+
+		// The key is only stored hashed so this is the only time it can be shown
+		fmt.Printf("created API key %d, store it now as it can not be shown again:\n%s\n", created.ID, key)
+	case "list":
+		keys, err := sqlStore.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tROLE\tPATRON\tCREATED AT")
+		for _, key := range keys {
+			patron := "-"
+			if key.PatronID != 0 {
+				patron = strconv.Itoa(key.PatronID)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Role, patron, key.CreatedAt.Format(time.RFC3339))
+		}
+
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+
+		keyID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.Errorf("invalid API key id: '%s'", args[1])
+		}
+
+		if err := sqlStore.DeleteAPIKey(ctx, keyID); err != nil {
+			return err
+		}
+
+		fmt.Printf("revoked API key %d\n", keyID)
+	default:
+		return errors.New(apiKeyUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func (s *Server) handlePostAPIKey(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	var key *types.APIKey
+	if err := json.NewDecoder(req.Body).Decode(&key); err != nil || key == nil {
+		writeError(w, req, badRequest("request body must be a JSON API key"))
+		return
+	}
+
+	var invalid []types.FieldError
+
+	if strings.TrimSpace(key.Name) == "" {
+		invalid = append(invalid, types.FieldError{Field: "name", Message: "must supply a name"})
+	}
+
+	role, err := auth.ParseKeyRole(key.Role, key.PatronID)
+	if err != nil {
+		invalid = append(invalid, types.FieldError{Field: "role", Message: err.Error()})
+	}
+
+	if len(invalid) > 0 {
+		writeError(w, req, invalidFields(invalid...))
+		return
+	}
+
+	secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		writeError(w, req, internalError(err))
+		return
+	}
+
+	created, err := s.store.CreateAPIKey(ctx, &types.APIKey{
+		Name:     key.Name,
+		PatronID: key.PatronID,
+		Role:     string(role),
+		Hash:     auth.HashAPIKey(secret),
+	})
+	if err != nil {
+		writeError(w, req, storeError(err, "API key"))
+		return
+	}
+
+	// The key is only stored hashed so this is the only time it can be returned
+	created.Key = secret
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		logger.Errorf("handlePostAPIKey: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleListAPIKey(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	keys, err := s.store.ListAPIKeys(ctx)
+	if err != nil {
+		writeError(w, req, storeError(err, "API key"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		logger.Errorf("handleListAPIKey: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleDeleteAPIKey(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	keyID, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, req, badRequest("invalid API key id"))
+		return
+	}
+
+	if err := s.store.DeleteAPIKey(ctx, keyID); err != nil {
+		writeError(w, req, storeError(err, "API key"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestHandlePostAPIKey(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		var hash string
+		mockLibraryStore.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ interface{}, key *types.APIKey) (*types.APIKey, error) {
+				assert.Equal(t, "reader-app", key.Name)
+				assert.Equal(t, 1, key.PatronID)
+				assert.Equal(t, "patron", key.Role)
+				hash = key.Hash
+
+				created := *key
+				created.ID = 2
+				return &created, nil
+			}).Times(1)
+
+		body := []byte(`{"name": "reader-app", "patronId": 1, "role": "patron"}`)
+		resp := doWithToken(t, "POST", testServer.URL+"/apikey", body, testToken(t, auth.RoleAdmin, 0))
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Should be created status code.")
+
+		defer resp.Body.Close()
+		var key types.APIKey
+		err := json.NewDecoder(resp.Body).Decode(&key)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, key.ID)
+		assert.NotEmpty(t, key.Key, "Should return the key once.")
+		assert.Equal(t, auth.HashAPIKey(key.Key), hash, "Should only store the hash of the key.")
+	})
+
+	t.Run("Invalid Roles", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		for _, body := range []string{
+			`{"name": "test", "role": "superuser"}`,
+			`{"name": "test", "role": "patron"}`,
+			`{"name": "test"}`,
+		} {
+			resp := doWithToken(t, "POST", testServer.URL+"/apikey", []byte(body), testToken(t, auth.RoleAdmin, 0))
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should reject '%s'.", body)
+		}
+	})
+
+	t.Run("Unknown Patron", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any()).
+			Return(nil, datastore.ErrUnknownPatron).Times(1)
+
+		body := []byte(`{"name": "test", "patronId": 123, "role": "patron"}`)
+		resp := doWithToken(t, "POST", testServer.URL+"/apikey", body, testToken(t, auth.RoleAdmin, 0))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "Should be unprocessable entity status code.")
+	})
+}
+
+func TestHandleListAPIKey(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+	s := Server{
+		config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		store:  mockLibraryStore,
+	}
+
+	testServer := httptest.NewServer(s.newRouter())
+
+	mockLibraryStore.EXPECT().ListAPIKeys(gomock.Any()).
+		Return([]*types.APIKey{{ID: 1, Name: "test", Role: "admin", Hash: "secrethash"}}, nil).Times(1)
+
+	resp := doWithToken(t, "GET", testServer.URL+"/apikey", nil, testToken(t, auth.RoleAdmin, 0))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+	defer resp.Body.Close()
+	var keys []map[string]interface{}
+	err := json.NewDecoder(resp.Body).Decode(&keys)
+	require.NoError(t, err)
+
+	require.Len(t, keys, 1)
+	assert.NotContains(t, keys[0], "hash", "Should never return the key hash.")
+}
+
+func TestHandleDeleteAPIKey(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().DeleteAPIKey(gomock.Any(), 1).
+			Return(nil).Times(1)
+
+		resp := doWithToken(t, "DELETE", testServer.URL+"/apikey/1", nil, testToken(t, auth.RoleAdmin, 0))
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().DeleteAPIKey(gomock.Any(), 1).
+			Return(datastore.ErrNotFound).Times(1)
+
+		resp := doWithToken(t, "DELETE", testServer.URL+"/apikey/1", nil, testToken(t, auth.RoleAdmin, 0))
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should be not found status code.")
+	})
+}
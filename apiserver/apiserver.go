@@ -4,15 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"github.com/samkreter/givedirectly/datastore"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/badoux/checkmail"
 	"github.com/gorilla/mux"
 	"github.com/samkreter/go-core/httputil"
 	"github.com/samkreter/go-core/log"
+	"go.opencensus.io/trace"
 
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
 	"github.com/samkreter/givedirectly/types"
 )
 
@@ -20,51 +27,197 @@ import (
 
 type LibraryStore interface {
 	CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error)
-	GetRequest(ctx context.Context, requestID int)  (*types.Request, error)
-	ListRequest(ctx context.Context)  ([]*types.Request, error)
-	DeleteRequest(ctx context.Context, requestID int) error
+	GetRequest(ctx context.Context, requestID int) (*types.Request, error)
+	ListRequest(ctx context.Context, query *datastore.RequestQuery) (*datastore.RequestPage, error)
+	DeleteRequest(ctx context.Context, requestID int) (*types.Request, error)
+	UpdateRequestStatus(ctx context.Context, requestID int, status types.RequestStatus) (*types.Request, error)
+	GetQueuePosition(ctx context.Context, requestID int) (*types.QueuePosition, error)
+	RenewRequest(ctx context.Context, requestID int) (*types.Request, error)
+	ListOverdueRequests(ctx context.Context, asOf time.Time) ([]*types.Request, error)
+
+	CreateBook(ctx context.Context, book *types.Book) (*types.Book, error)
+	CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error)
+	GetBook(ctx context.Context, bookID int) (*types.Book, error)
+	ListBook(ctx context.Context, query *datastore.BookQuery) (*datastore.BookPage, error)
+	UpdateBook(ctx context.Context, book *types.Book) (*types.Book, error)
+	DeleteBook(ctx context.Context, bookID int) error
+	AddCopy(ctx context.Context, bookID int, c *types.Copy) (*types.Copy, error)
+	DeleteCopy(ctx context.Context, bookID, copyID int) error
+
+	CreatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error)
+	GetPatron(ctx context.Context, patronID int) (*types.Patron, error)
+	ListPatron(ctx context.Context) ([]*types.Patron, error)
+	UpdatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error)
+	ListPatronRequests(ctx context.Context, patronID int) ([]*types.Request, error)
+
+	CreateAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*types.APIKey, error)
+	DeleteAPIKey(ctx context.Context, keyID int) error
 }
 
 type Server struct {
-	config *Config
-	store LibraryStore
+	config  *Config
+	store   LibraryStore
+	metrics *serverMetrics
+	// shuttingDown is set to 1 once Run starts shutting down, making the server unready
+	shuttingDown int32
 }
 
 // ServerConfig configuration for the message API server
 type Config struct {
 	// ServerAddr address to expose the apiserver
-	ServerAddr           string
+	ServerAddr string
+	// AdminAddr address to expose the Prometheus metrics on, away from the API. The metrics are served
+	// at /metrics on the server address if unset.
+	AdminAddr string
 	// EnableReqCorrelation enable correlation IDs to be generated for all logs on a single request
 	EnableReqCorrelation bool
 	// EnableReqLogging enable logging details for each request
-	EnableReqLogging     bool
+	EnableReqLogging bool
+	// EnableTracing record a span for each request, continuing the trace of the caller given by its B3 headers
+	EnableTracing bool
+	// EnableAuth require every request to be authenticated with an API key or bearer token
+	EnableAuth bool
+	// TokenSecret the HMAC secret bearer tokens are signed with. Bearer tokens are disabled without it.
+	TokenSecret string
+	// TokenTTL how long issued bearer tokens are valid for, an hour if unset
+	TokenTTL time.Duration
+
+	// ReadHeaderTimeout how long to wait for the headers of a request, 10 seconds if unset
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout how long to wait for a whole request including its body, 30 seconds if unset
+	ReadTimeout time.Duration
+	// WriteTimeout how long a request can take from the end of its headers to the end of the response,
+	// 30 seconds if unset
+	WriteTimeout time.Duration
+	// IdleTimeout how long to keep idle keep-alive connections open, 2 minutes if unset
+	IdleTimeout time.Duration
+	// ShutdownTimeout how long to wait for in-flight requests to finish when the server is stopped,
+	// 30 seconds if unset
+	ShutdownTimeout time.Duration
+	// ShutdownDelay how long to keep serving requests, while reporting not ready, once the server is
+	// stopped. It gives load balancers time to stop sending new requests before connections are refused.
+	ShutdownDelay time.Duration
 }
 
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
 // NewServer creates a new apiserver and validates the configuration
 func NewServer(store LibraryStore, config *Config) (*Server, error) {
-	if err := validateConfig(config); err != nil {
+	if err := ValidateConfig(config); err != nil {
 		return nil, err
 	}
 
+	metrics := newServerMetrics(store)
+
 	return &Server{
-		store: store,
+		store:   &metricsStore{store: store, metrics: metrics},
 		config:  config,
+		metrics: metrics,
 	}, nil
 }
 
-// Run runs the apiserver exposing at the specified port
-func (s *Server) Run() error {
-	router := s.newRouter()
+// Run runs the apiserver exposing at the specified port until the context is done. In-flight requests
+// are then given the shutdown timeout to finish before their connections are closed.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.ServerAddr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.newRouter(),
+		ReadHeaderTimeout: durationOrDefault(s.config.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       durationOrDefault(s.config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      durationOrDefault(s.config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       durationOrDefault(s.config.IdleTimeout, defaultIdleTimeout),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	log.G(ctx).WithField("address: ", listener.Addr().String()).Info("Starting Request API Server:")
+
+	var adminServer *http.Server
+	if s.config.AdminAddr != "" {
+		adminListener, err := net.Listen("tcp", s.config.AdminAddr)
+		if err != nil {
+			server.Close()
+			return err
+		}
+
+		adminServer = &http.Server{
+			Handler:           s.newAdminRouter(),
+			ReadHeaderTimeout: durationOrDefault(s.config.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		}
+
+		go func() {
+			serveErr <- adminServer.Serve(adminListener)
+		}()
+
+		log.G(ctx).WithField("address: ", adminListener.Addr().String()).Info("Starting Admin Server:")
+	}
 
-	log.G(context.TODO()).WithField("address: ", s.config.ServerAddr).Info("Starting Request API Server:")
-	if err := http.ListenAndServe(s.config.ServerAddr, router); err != nil {
+	select {
+	case err := <-serveErr:
+		server.Close()
+		if adminServer != nil {
+			adminServer.Close()
+		}
 		return err
+	case <-ctx.Done():
+	}
+
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	if s.config.ShutdownDelay > 0 {
+		log.G(ctx).Infof("Request API Server is not ready, shutting down in %s", s.config.ShutdownDelay)
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	log.G(ctx).Info("Shutting down Request API Server, waiting for in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), durationOrDefault(s.config.ShutdownTimeout, defaultShutdownTimeout))
+	defer cancel()
+
+	if adminServer != nil {
+		// Metrics are scraped until the API has shut down, so the last requests are counted
+		defer adminServer.Close()
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("failed to finish in-flight requests before the shutdown timeout: %w", err)
 	}
 
 	return nil
 }
 
-func validateConfig(config *Config) error {
+// durationOrDefault returns the duration, or the default if it is unset
+func durationOrDefault(d, defaultDuration time.Duration) time.Duration {
+	if d == 0 {
+		return defaultDuration
+	}
+
+	return d
+}
+
+// Handler returns the handler serving the API, with the middleware the server is configured with
+func (s *Server) Handler() http.Handler {
+	return s.newRouter()
+}
+
+// ValidateConfig checks the server configuration. NewServer rejects configurations that fail it.
+func ValidateConfig(config *Config) error {
 	if config == nil {
 		return errors.New("missing server configuration")
 	}
@@ -73,26 +226,97 @@ func validateConfig(config *Config) error {
 		return errors.New("must supply API servering address")
 	}
 
+	if _, _, err := net.SplitHostPort(config.ServerAddr); err != nil {
+		return fmt.Errorf("invalid API serving address: %w", err)
+	}
+
+	if config.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(config.AdminAddr); err != nil {
+			return fmt.Errorf("invalid admin address: %w", err)
+		}
+
+		if config.AdminAddr == config.ServerAddr {
+			return errors.New("admin address must be different from the API serving address")
+		}
+	}
+
+	if config.TokenSecret != "" && len(config.TokenSecret) < minTokenSecretLength {
+		return fmt.Errorf("token secret must be at least %d characters", minTokenSecretLength)
+	}
+
+	if config.TokenTTL < 0 {
+		return errors.New("token TTL can not be negative")
+	}
+
+	for name, timeout := range map[string]time.Duration{
+		"read header":    config.ReadHeaderTimeout,
+		"read":           config.ReadTimeout,
+		"write":          config.WriteTimeout,
+		"idle":           config.IdleTimeout,
+		"shutdown":       config.ShutdownTimeout,
+		"shutdown delay": config.ShutdownDelay,
+	} {
+		if timeout < 0 {
+			return fmt.Errorf("%s timeout can not be negative", name)
+		}
+	}
+
 	return nil
 }
 
 func (s *Server) newRouter() http.Handler {
 	router := mux.NewRouter()
+	router.NotFoundHandler = s.metrics.instrument("", unmatchedRoute, http.HandlerFunc(handleNotFound))
+	router.MethodNotAllowedHandler = s.metrics.instrument("", unmatchedRoute, http.HandlerFunc(handleMethodNotAllowed))
+
+	for _, r := range s.routes() {
+		handler := s.authorize(r.access, r.handler)
+		if s.config.EnableAuth && !r.access.public {
+			// Authentication runs inside the logging/correlation middleware so rejected requests are logged too
+			handler = s.authMiddleware(handler)
+		}
 
-	router.HandleFunc("/request", s.handlePostRequest).Methods("POST")
-	router.HandleFunc("/request", s.handleListRequest).Methods("GET")
-	router.HandleFunc("/request/{id}", s.handleGetRequest).Methods("GET")
-	router.HandleFunc("/request/{id}", s.handleDeleteRequest).Methods("DELETE")
+		// Metrics are recorded outside authentication so rejected requests are counted too
+		handler = s.metrics.instrument(r.method, r.path, handler)
+		if s.config.EnableTracing {
+			handler = traceRoute(r.method, r.path, handler)
+		}
+
+		router.Handle(r.path, handler).Methods(r.method)
+	}
 
-	// add logging/correlation middleware
+	// add logging/correlation/tracing middleware
 	middlewareRouter := httputil.SetUpHandler(router, &httputil.HandlerConfig{
 		CorrelationEnabled: s.config.EnableReqCorrelation,
 		LoggingEnabled:     s.config.EnableReqLogging,
+		TracingEnabled:     s.config.EnableTracing,
 	})
 
 	return middlewareRouter
 }
 
+// traceRoute names the span of the request after its route template rather than its path, so requests
+// for different resources are grouped together
+func traceRoute(method, route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		span := trace.FromContext(req.Context())
+		span.SetName(method + " " + route)
+		span.AddAttributes(trace.StringAttribute("http.route", route))
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// newAdminRouter returns the handler of the admin address, which serves the metrics
+func (s *Server) newAdminRouter() http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+	router.HandleFunc("/metrics", s.handleGetMetrics).Methods("GET")
+
+	return router
+}
+
 func (s *Server) handlePostRequest(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
@@ -100,61 +324,91 @@ func (s *Server) handlePostRequest(w http.ResponseWriter, req *http.Request) {
 	logger := log.G(req.Context())
 
 	var request *types.Request
-	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil || request == nil {
+		writeError(w, req, badRequest("request body must be a JSON request"))
 		return
 	}
 
+	// Patrons can only make requests for themselves
+	if patronID, ok := ownPatronOnly(req, auth.PermManageRequests); ok {
+		if request.PatronID != 0 && request.PatronID != patronID {
+			writeForbidden(w, req, "patrons can only make requests for themselves")
+			return
+		}
+
+		request.PatronID = patronID
+		request.Email = ""
+	}
+
+	// Only imports can backdate requests
+	request.CreatedAt = time.Time{}
+
+	var invalid []types.FieldError
+
 	// Validate title
 	if len(request.Title) == 0 {
-		http.Error(w, "Must supply a title", http.StatusBadRequest)
-		return
+		invalid = append(invalid, types.FieldError{Field: "title", Message: "must supply a title"})
 	}
 
-	// Validate email
-	if err := checkmail.ValidateFormat(request.Email); err != nil {
-		http.Error(w, "Invalid email address", http.StatusBadRequest)
+	// Validate email, which is only needed when the patron is not given by ID
+	if request.PatronID == 0 {
+		if err := checkmail.ValidateFormat(strings.TrimSpace(request.Email)); err != nil {
+			invalid = append(invalid, types.FieldError{Field: "email", Message: "invalid email address"})
+		}
+	}
+
+	if len(invalid) > 0 {
+		writeError(w, req, invalidFields(invalid...))
 		return
 	}
 
 	book, err := s.store.CreateRequest(ctx, request)
 	if err != nil {
-		switch {
-		case err == datastore.ErrNotFound:
-			http.Error(w, "Requested book not found", http.StatusNotFound)
-			return
-		default:
-			logger.Errorf("failed to create request with error: %v", err)
-			http.Error(w, "Failed to create request", http.StatusInternalServerError)
-			return
-		}
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	s.metrics.requestCreated(request)
+
+	if request.ID != 0 {
+		w.Header().Set("Location", fmt.Sprintf("/request/%d", request.ID))
+	}
+
+	// The book was unavailable so the request was added to the book's waitlist
+	if request.Status == types.RequestStatusWaiting {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusOK)
 	}
 
-	if err := json.NewEncoder(w).Encode(book); err != nil{
-		w.WriteHeader(http.StatusServiceUnavailable)
+	if err := json.NewEncoder(w).Encode(book); err != nil {
 		logger.Errorf("handlePostRequest: %v", err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) handleListRequest(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	logger := log.G(ctx)
 
+	query, err := parseRequestQuery(req.URL.Query())
+	if err != nil {
+		writeError(w, req, newError(http.StatusBadRequest, types.ErrorCodeInvalidQuery, err.Error()))
+		return
+	}
 
-	requests, err := s.store.ListRequest(ctx)
+	page, err := s.store.ListRequest(ctx, query)
 	if err != nil {
-		logger.Errorf("failed to list requests with error: %v", err)
-		http.Error(w, "failed with internal server error", http.StatusInternalServerError)
+		writeError(w, req, storeError(err, "request"))
 		return
 	}
 
+	setNextLink(w, req, page.NextCursor)
+
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(requests)
+	err = json.NewEncoder(w).Encode(page.Requests)
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		logger.Errorf("handleGetMessage: %v", err)
+		logger.Errorf("handleListRequest: %v", err)
 		return
 	}
 }
@@ -162,71 +416,153 @@ func (s *Server) handleListRequest(w http.ResponseWriter, req *http.Request) {
 func (s *Server) handleGetRequest(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	logger := log.G(ctx)
-	vars := mux.Vars(req)
 
-	requestIDStr := vars["id"]
-	if requestIDStr == "" {
-		http.Error(w, "missing request id", http.StatusBadRequest)
+	requestID, ok := parseRequestID(w, req)
+	if !ok {
 		return
 	}
 
-	requestID, err := strconv.Atoi(requestIDStr)
+	request, err := s.store.GetRequest(ctx, requestID)
 	if err != nil {
-		http.Error(w, "invalid request id", http.StatusBadRequest)
+		writeError(w, req, storeError(err, "request"))
 		return
 	}
 
-	request, err := s.store.GetRequest(ctx, requestID)
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(request)
+	if err != nil {
+		logger.Errorf("handleGetRequest: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleDeleteRequest(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	requestID, ok := parseRequestID(w, req)
+	if !ok {
+		return
+	}
+
+	request, err := s.store.DeleteRequest(ctx, requestID)
 	if err != nil {
-		switch {
-		case err == datastore.ErrNotFound:
-			http.Error(w, "request not found", http.StatusNotFound)
+		writeError(w, req, storeError(err, "request"))
+		return
+	}
+
+	s.metrics.requestTransitioned(request.Status)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRequestTransition returns a handler that moves the request to the given status
+func (s *Server) handleRequestTransition(status types.RequestStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		logger := log.G(ctx)
+
+		requestID, ok := parseRequestID(w, req)
+		if !ok {
 			return
-		default:
-			logger.Errorf("failed to get request with error: %v", err)
-			http.Error(w, "failed with internal server error", http.StatusInternalServerError)
+		}
+
+		request, err := s.store.UpdateRequestStatus(ctx, requestID, status)
+		if err != nil {
+			writeError(w, req, storeError(err, "request"))
+			return
+		}
+
+		s.metrics.requestTransitioned(request.Status)
+
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(request); err != nil {
+			logger.Errorf("handleRequestTransition: %v", err)
 			return
 		}
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(request)
+func (s *Server) handleGetQueuePosition(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	requestID, ok := parseRequestID(w, req)
+	if !ok {
+		return
+	}
+
+	position, err := s.store.GetQueuePosition(ctx, requestID)
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		logger.Errorf("handleGetMessage: %v", err)
+		writeError(w, req, storeError(err, "request"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(position); err != nil {
+		logger.Errorf("handleGetQueuePosition: %v", err)
 		return
 	}
 }
 
-func (s *Server) handleDeleteRequest(w http.ResponseWriter, req *http.Request) {
+func (s *Server) handleRenewRequest(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	logger := log.G(ctx)
-	vars := mux.Vars(req)
 
-	requestIDStr := vars["id"]
-	if requestIDStr == "" {
-		http.Error(w, "missing request id", http.StatusBadRequest)
+	requestID, ok := parseRequestID(w, req)
+	if !ok {
 		return
 	}
 
-	requestID, err := strconv.Atoi(requestIDStr)
+	request, err := s.store.RenewRequest(ctx, requestID)
 	if err != nil {
-		http.Error(w, "invalid request id", http.StatusBadRequest)
+		writeError(w, req, storeError(err, "request"))
 		return
 	}
 
-	err = s.store.DeleteRequest(ctx, requestID)
-	if err != nil {
-		switch {
-		case err == datastore.ErrNotFound:
-			http.Error(w, "request not found", http.StatusNotFound)
-			return
-		default:
-			logger.Errorf("failed to delete request with error: %v", err)
-			http.Error(w, "failed with internal server error", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(request); err != nil {
+		logger.Errorf("handleRenewRequest: %v", err)
+		return
+	}
+}
+
+// handleListOverdueRequests lists the requests that are overdue now, or as of the RFC3339 time in the
+// asOf query parameter
+func (s *Server) handleListOverdueRequests(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	asOf := time.Now()
+	if asOfStr := req.URL.Query().Get("asOf"); asOfStr != "" {
+		var err error
+		asOf, err = time.Parse(time.RFC3339, asOfStr)
+		if err != nil {
+			writeError(w, req, newError(http.StatusBadRequest, types.ErrorCodeInvalidQuery, "asOf must be an RFC3339 time"))
 			return
 		}
 	}
 
+	requests, err := s.store.ListOverdueRequests(ctx, asOf)
+	if err != nil {
+		writeError(w, req, storeError(err, "request"))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		logger.Errorf("handleListOverdueRequests: %v", err)
+		return
+	}
+}
+
+// parseRequestID reads the request ID from the route variables. If it is invalid a bad request is
+// written to the response and false is returned.
+func parseRequestID(w http.ResponseWriter, req *http.Request) (int, bool) {
+	requestID, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, req, badRequest("invalid request id"))
+		return 0, false
+	}
+
+	return requestID, true
 }
@@ -2,34 +2,38 @@ package apiserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
-	"fmt"
 
 	"github.com/golang/mock/gomock"
 	"github.com/samkreter/givedirectly/types"
-	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
 
-	"github.com/samkreter/givedirectly/datastore"
 	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/datastore"
 )
 
 const (
 	testTitle = "testTitle"
 )
 
-func TestHandlePostRequest(t *testing.T){
+func TestHandlePostRequest(t *testing.T) {
 	t.Run("Success Case", func(t *testing.T) {
 		mockCtrl := gomock.NewController(t)
 		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -42,11 +46,11 @@ func TestHandlePostRequest(t *testing.T){
 		// mock the creatRequest
 		mockLibraryStore.EXPECT().CreateRequest(gomock.Any(), request).
 			Return(&types.Book{
-				ID: 1,
-				Title: testTitle,
-				Available: true,
+				ID:            1,
+				Title:         testTitle,
+				Available:     true,
 				TimeRequested: time.Now().Format(time.RFC3339),
-		}, nil).Times(1)
+			}, nil).Times(1)
 
 		b, err := json.Marshal(request)
 		require.NoError(t, err)
@@ -73,7 +77,7 @@ func TestHandlePostRequest(t *testing.T){
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -99,6 +103,47 @@ func TestHandlePostRequest(t *testing.T){
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should retrun not found for no book.")
 	})
 
+	t.Run("Book Unavailable", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		request := &types.Request{
+			Email: "test@gmail.com",
+			Title: testTitle,
+		}
+
+		// The store adds the request to the waitlist
+		mockLibraryStore.EXPECT().CreateRequest(gomock.Any(), request).
+			Do(func(_ context.Context, r *types.Request) {
+				r.ID = 5
+				r.Status = types.RequestStatusWaiting
+			}).
+			Return(&types.Book{
+				ID:        1,
+				Title:     testTitle,
+				Available: false,
+			}, nil).Times(1)
+
+		b, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/request", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode, "Should be accepted status code.")
+		assert.Equal(t, "/request/5", resp.Header.Get("Location"), "Should point to the waiting request.")
+	})
+
 	t.Run("Invalid Email", func(t *testing.T) {
 		s := Server{
 			config: &Config{},
@@ -107,7 +152,7 @@ func TestHandlePostRequest(t *testing.T){
 		testServer := httptest.NewServer(s.newRouter())
 
 		request := types.Request{
-			Email:"",
+			Email: "",
 			Title: "testTitle",
 		}
 
@@ -123,6 +168,85 @@ func TestHandlePostRequest(t *testing.T){
 		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be success status code.")
 	})
 
+	t.Run("Unknown Patron", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		// The email is not needed when the patron is given by ID
+		request := &types.Request{
+			PatronID: 123,
+			Title:    testTitle,
+		}
+
+		mockLibraryStore.EXPECT().CreateRequest(gomock.Any(), request).
+			Return(nil, datastore.ErrUnknownPatron).Times(1)
+
+		b, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/request", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "Should be unprocessable entity status code.")
+	})
+
+	t.Run("Refused By Policy", func(t *testing.T) {
+		tests := []struct {
+			violation *datastore.PolicyViolation
+			status    int
+		}{
+			{
+				violation: &datastore.PolicyViolation{Rule: "max-loans", Reason: "limit reached", Kind: datastore.ErrLimitExceeded},
+				status:    http.StatusConflict,
+			},
+			{
+				violation: &datastore.PolicyViolation{Rule: "blocked-patron", Reason: "patron is blocked", Kind: datastore.ErrRestricted},
+				status:    http.StatusUnprocessableEntity,
+			},
+		}
+
+		for _, test := range tests {
+			mockCtrl := gomock.NewController(t)
+			mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+			s := Server{
+				config: &Config{},
+				store:  mockLibraryStore,
+			}
+
+			testServer := httptest.NewServer(s.newRouter())
+
+			mockLibraryStore.EXPECT().CreateRequest(gomock.Any(), gomock.Any()).
+				Return(nil, test.violation).Times(1)
+
+			b, err := json.Marshal(types.Request{Email: "test@gmail.com", Title: testTitle})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("POST", testServer.URL+"/request", bytes.NewBuffer(b))
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.status, resp.StatusCode, "Should map the '%s' violation.", test.violation.Rule)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Contains(t, string(body), test.violation.Rule, "Should explain which rule failed.")
+		}
+	})
+
 	t.Run("Invalid Title", func(t *testing.T) {
 		s := Server{
 			config: &Config{},
@@ -148,15 +272,14 @@ func TestHandlePostRequest(t *testing.T){
 	})
 }
 
-
-func TestHandleGetRequest(t *testing.T){
+func TestHandleGetRequest(t *testing.T) {
 	t.Run("Success Case", func(t *testing.T) {
 		mockCtrl := gomock.NewController(t)
 		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -168,7 +291,7 @@ func TestHandleGetRequest(t *testing.T){
 			Return(&types.Request{
 				Email: "test@gmail.com",
 				Title: testTitle,
-				ID: testRequestID,
+				ID:    testRequestID,
 			}, nil).Times(1)
 
 		url := fmt.Sprintf("%s/%d", testServer.URL+"/request", testRequestID)
@@ -194,7 +317,7 @@ func TestHandleGetRequest(t *testing.T){
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -221,7 +344,6 @@ func TestHandleGetRequest(t *testing.T){
 
 		testServer := httptest.NewServer(s.newRouter())
 
-
 		url := fmt.Sprintf("%s/%s", testServer.URL+"/request", "invalidReqeustID")
 		req, err := http.NewRequest("GET", url, nil)
 		require.NoError(t, err)
@@ -246,7 +368,7 @@ func TestHandleListRequests(t *testing.T) {
 		testServer := httptest.NewServer(s.newRouter())
 
 		retRequests := []*types.Request{}
-		for i:=0;i<10;i++ {
+		for i := 0; i < 10; i++ {
 			retRequests = append(retRequests, &types.Request{
 				Title: fmt.Sprintf("test%d", i),
 				Email: "testemail",
@@ -254,8 +376,8 @@ func TestHandleListRequests(t *testing.T) {
 		}
 
 		// mock the creatRequest
-		mockLibraryStore.EXPECT().ListRequest(gomock.Any()).
-			Return(retRequests, nil).Times(1)
+		mockLibraryStore.EXPECT().ListRequest(gomock.Any(), &datastore.RequestQuery{}).
+			Return(&datastore.RequestPage{Requests: retRequests}, nil).Times(1)
 
 		req, err := http.NewRequest("GET", testServer.URL+"/request", nil)
 		require.NoError(t, err)
@@ -271,6 +393,67 @@ func TestHandleListRequests(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.Equal(t, len(requests), 10, "Should return correct num of requests.")
+		assert.Empty(t, resp.Header.Get("Link"), "Should not link to a next page.")
+	})
+
+	t.Run("Query And Next Link", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		createdAfter := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		mockLibraryStore.EXPECT().ListRequest(gomock.Any(), &datastore.RequestQuery{
+			Page:         datastore.Page{Limit: 2, Cursor: "abc", Sort: "-createdAt"},
+			Email:        "test@gmail.com",
+			Title:        "testbook",
+			Statuses:     []types.RequestStatus{types.RequestStatusWaiting, types.RequestStatusRequested, types.RequestStatusCheckedOut},
+			CreatedAfter: &createdAfter,
+		}).Return(&datastore.RequestPage{Requests: []*types.Request{}, NextCursor: "def"}, nil).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/request?limit=2&cursor=abc&sort=-createdAt&email=test@gmail.com"+
+			"&title=testbook&status=waiting,requested&status=checked_out&createdAfter=2021-01-01T00:00:00Z", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		link := resp.Header.Get("Link")
+		assert.Contains(t, link, `rel="next"`)
+		assert.Contains(t, link, "cursor=def", "Should link to the next page.")
+		assert.Contains(t, link, "sort=-createdAt", "Should keep the query in the next link.")
+	})
+
+	t.Run("Invalid Query", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().ListRequest(gomock.Any(), gomock.Any()).
+			Return(nil, fmt.Errorf("bad sort: %w", datastore.ErrInvalidQuery)).Times(1)
+
+		for _, query := range []string{"limit=abc", "limit=0", "createdBefore=yesterday", "sort=dueAt"} {
+			req, err := http.NewRequest("GET", testServer.URL+"/request?"+query, nil)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should reject '%s'.", query)
+		}
 	})
 
 	t.Run("Datastore error", func(t *testing.T) {
@@ -285,7 +468,7 @@ func TestHandleListRequests(t *testing.T) {
 		testServer := httptest.NewServer(s.newRouter())
 
 		// mock the creatRequest
-		mockLibraryStore.EXPECT().ListRequest(gomock.Any()).
+		mockLibraryStore.EXPECT().ListRequest(gomock.Any(), gomock.Any()).
 			Return(nil, fmt.Errorf("random error")).Times(1)
 
 		req, err := http.NewRequest("GET", testServer.URL+"/request", nil)
@@ -298,14 +481,14 @@ func TestHandleListRequests(t *testing.T) {
 	})
 }
 
-func TestHandleDeleteRequest(t *testing.T){
+func TestHandleDeleteRequest(t *testing.T) {
 	t.Run("Success Case", func(t *testing.T) {
 		mockCtrl := gomock.NewController(t)
 		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -314,7 +497,7 @@ func TestHandleDeleteRequest(t *testing.T){
 
 		// mock the creatRequest
 		mockLibraryStore.EXPECT().DeleteRequest(gomock.Any(), testRequestID).
-			Return(nil).Times(1)
+			Return(&types.Request{ID: testRequestID, Status: types.RequestStatusCancelled}, nil).Times(1)
 
 		url := fmt.Sprintf("%s/%d", testServer.URL+"/request", testRequestID)
 		req, err := http.NewRequest("DELETE", url, nil)
@@ -332,7 +515,7 @@ func TestHandleDeleteRequest(t *testing.T){
 
 		s := Server{
 			config: &Config{},
-			store: mockLibraryStore,
+			store:  mockLibraryStore,
 		}
 
 		testServer := httptest.NewServer(s.newRouter())
@@ -340,7 +523,7 @@ func TestHandleDeleteRequest(t *testing.T){
 		testRequestID := 123
 
 		mockLibraryStore.EXPECT().DeleteRequest(gomock.Any(), testRequestID).
-			Return(datastore.ErrNotFound).Times(1)
+			Return(nil, datastore.ErrNotFound).Times(1)
 
 		url := fmt.Sprintf("%s/%d", testServer.URL+"/request", testRequestID)
 		req, err := http.NewRequest("DELETE", url, nil)
@@ -352,6 +535,32 @@ func TestHandleDeleteRequest(t *testing.T){
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no request.")
 	})
 
+	t.Run("Request Already Closed", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().DeleteRequest(gomock.Any(), testRequestID).
+			Return(nil, datastore.ErrInvalidTransition).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should be conflict status code.")
+	})
+
 	t.Run("Invalid Request ID", func(t *testing.T) {
 		s := Server{
 			config: &Config{},
@@ -368,4 +577,536 @@ func TestHandleDeleteRequest(t *testing.T){
 
 		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
 	})
-}
\ No newline at end of file
+}
+
+func TestHandleRequestTransition(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().UpdateRequestStatus(gomock.Any(), testRequestID, types.RequestStatusReturned).
+			Return(&types.Request{
+				ID:     testRequestID,
+				Email:  "test@gmail.com",
+				Title:  testTitle,
+				Status: types.RequestStatusReturned,
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/return", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retRequest types.Request
+		err = json.NewDecoder(resp.Body).Decode(&retRequest)
+		require.NoError(t, err)
+
+		assert.Equal(t, types.RequestStatusReturned, retRequest.Status, "Should return the updated request.")
+	})
+
+	t.Run("Invalid Transition", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().UpdateRequestStatus(gomock.Any(), testRequestID, types.RequestStatusCheckedOut).
+			Return(nil, datastore.ErrInvalidTransition).Times(1)
+
+		url := fmt.Sprintf("%s/%d/checkout", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should be conflict status code.")
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().UpdateRequestStatus(gomock.Any(), testRequestID, types.RequestStatusCancelled).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d/cancel", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no request.")
+	})
+}
+
+func TestHandleGetQueuePosition(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().GetQueuePosition(gomock.Any(), testRequestID).
+			Return(&types.QueuePosition{
+				RequestID: testRequestID,
+				Status:    types.RequestStatusWaiting,
+				Position:  2,
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/position", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var position types.QueuePosition
+		err = json.NewDecoder(resp.Body).Decode(&position)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, position.Position, "Should return the queue position.")
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().GetQueuePosition(gomock.Any(), testRequestID).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d/position", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no request.")
+	})
+}
+
+func TestHandleRenewRequest(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+		dueAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+		mockLibraryStore.EXPECT().RenewRequest(gomock.Any(), testRequestID).
+			Return(&types.Request{
+				ID:       testRequestID,
+				Email:    "test@gmail.com",
+				Title:    testTitle,
+				Status:   types.RequestStatusCheckedOut,
+				DueAt:    &dueAt,
+				Renewals: 1,
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/renew", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retRequest types.Request
+		err = json.NewDecoder(resp.Body).Decode(&retRequest)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, retRequest.Renewals, "Should return the renewed request.")
+		require.NotNil(t, retRequest.DueAt)
+		assert.True(t, dueAt.Equal(*retRequest.DueAt), "Should return the new due date.")
+	})
+
+	t.Run("Renewal Refused", func(t *testing.T) {
+		for _, storeErr := range []error{datastore.ErrRenewalLimit, datastore.ErrHoldQueue, datastore.ErrInvalidTransition} {
+			mockCtrl := gomock.NewController(t)
+			mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+			s := Server{
+				config: &Config{},
+				store:  mockLibraryStore,
+			}
+
+			testServer := httptest.NewServer(s.newRouter())
+
+			testRequestID := 123
+
+			mockLibraryStore.EXPECT().RenewRequest(gomock.Any(), testRequestID).
+				Return(nil, storeErr).Times(1)
+
+			url := fmt.Sprintf("%s/%d/renew", testServer.URL+"/request", testRequestID)
+			req, err := http.NewRequest("POST", url, nil)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should be conflict status code for '%v'.", storeErr)
+		}
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testRequestID := 123
+
+		mockLibraryStore.EXPECT().RenewRequest(gomock.Any(), testRequestID).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d/renew", testServer.URL+"/request", testRequestID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no request.")
+	})
+}
+
+func TestHandleListOverdueRequests(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		asOf := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		dueAt := asOf.Add(-time.Hour)
+
+		mockLibraryStore.EXPECT().ListOverdueRequests(gomock.Any(), asOf).
+			Return([]*types.Request{
+				{ID: 1, Email: "test@gmail.com", Title: testTitle, Status: types.RequestStatusCheckedOut, DueAt: &dueAt},
+			}, nil).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/request/overdue?asOf="+asOf.Format(time.RFC3339), nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retRequests []*types.Request
+		err = json.NewDecoder(resp.Body).Decode(&retRequests)
+		require.NoError(t, err)
+
+		require.Len(t, retRequests, 1, "Should return the overdue requests.")
+		assert.Equal(t, 1, retRequests[0].ID)
+	})
+
+	t.Run("Invalid As Of", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		req, err := http.NewRequest("GET", testServer.URL+"/request/overdue?asOf=yesterday", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Finishes In-Flight Requests", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), 1).
+			DoAndReturn(func(_ context.Context, bookID int) (*types.Book, error) {
+				close(started)
+				<-release
+				return &types.Book{ID: bookID, Title: testTitle}, nil
+			}).Times(1)
+
+		addr := freeAddr(t)
+		s, err := NewServer(mockLibraryStore, &Config{ServerAddr: addr})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- s.Run(ctx)
+		}()
+		waitForServer(t, addr)
+
+		respCh := make(chan *http.Response, 1)
+		go func() {
+			resp, err := http.Get("http://" + addr + "/book/1")
+			assert.NoError(t, err)
+			respCh <- resp
+		}()
+
+		<-started
+		cancel()
+
+		// Stopped servers refuse new connections while they drain
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+			}
+			return err != nil
+		}, time.Second, 10*time.Millisecond, "Should stop listening.")
+
+		close(release)
+
+		resp := <-respCh
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should finish the in-flight request.")
+		resp.Body.Close()
+
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("Shutdown Timeout", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		defer close(release)
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), 1).
+			DoAndReturn(func(_ context.Context, bookID int) (*types.Book, error) {
+				close(started)
+				<-release
+				return nil, datastore.ErrNotFound
+			}).Times(1)
+
+		addr := freeAddr(t)
+		s, err := NewServer(mockLibraryStore, &Config{ServerAddr: addr, ShutdownTimeout: 10 * time.Millisecond})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- s.Run(ctx)
+		}()
+		waitForServer(t, addr)
+
+		go http.Get("http://" + addr + "/book/1")
+
+		<-started
+		cancel()
+
+		assert.Error(t, <-runErr, "Should give up on requests that don't finish in time.")
+	})
+
+	t.Run("Not Ready During Shutdown Delay", func(t *testing.T) {
+		addr := freeAddr(t)
+		s, err := NewServer(datastore.NewMemoryStore(), &Config{ServerAddr: addr, ShutdownDelay: 200 * time.Millisecond})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- s.Run(ctx)
+		}()
+		waitForServer(t, addr)
+
+		cancel()
+
+		require.Eventually(t, func() bool {
+			resp, err := http.Get("http://" + addr + "/readyz")
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusServiceUnavailable
+		}, time.Second, 10*time.Millisecond, "Should report not ready while still serving.")
+
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("Address In Use", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		s, err := NewServer(nil, &Config{ServerAddr: listener.Addr().String()})
+		require.NoError(t, err)
+
+		assert.Error(t, s.Run(context.Background()))
+	})
+}
+
+// spanRecorder records the spans that are exported
+type spanRecorder struct {
+	spans chan *trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(span *trace.SpanData) {
+	r.spans <- span
+}
+
+func TestTraceRoute(t *testing.T) {
+	recorder := &spanRecorder{spans: make(chan *trace.SpanData, 10)}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+
+	mockCtrl := gomock.NewController(t)
+	mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+	mockLibraryStore.EXPECT().GetBook(gomock.Any(), 1).Return(&types.Book{ID: 1, Title: testTitle}, nil).Times(1)
+
+	s := Server{
+		config: &Config{EnableTracing: true},
+		store:  mockLibraryStore,
+	}
+
+	testServer := httptest.NewServer(s.newRouter())
+	defer testServer.Close()
+
+	req, err := http.NewRequest("GET", testServer.URL+"/book/1", nil)
+	require.NoError(t, err)
+
+	// Continue the caller's sampled trace
+	req.Header.Set("X-B3-TraceId", "463ac35c9f6413ad48485a3953bb6124")
+	req.Header.Set("X-B3-SpanId", "a2fb4a1d1a96d312")
+	req.Header.Set("X-B3-Sampled", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case span := <-recorder.spans:
+		assert.Equal(t, "GET /book/{id}", span.Name, "Should name the span after the route template.")
+		assert.Equal(t, "463ac35c9f6413ad48485a3953bb6124", span.TraceID.String())
+		assert.Equal(t, "a2fb4a1d1a96d312", span.ParentSpanID.String())
+		assert.Equal(t, "/book/{id}", span.Attributes["http.route"])
+	case <-time.After(time.Second):
+		t.Fatal("Should export the request's span.")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, config := range []*Config{
+		nil,
+		{},
+		{ServerAddr: ":8080", TokenSecret: "too-short"},
+		{ServerAddr: ":8080", TokenTTL: -time.Hour},
+		{ServerAddr: ":8080", WriteTimeout: -time.Second},
+		{ServerAddr: ":8080", ShutdownTimeout: -time.Second},
+		{ServerAddr: ":8080", ShutdownDelay: -time.Second},
+		{ServerAddr: ":8080", AdminAddr: ":8080"},
+		{ServerAddr: "8080"},
+		{ServerAddr: ":8080", AdminAddr: "localhost"},
+	} {
+		assert.Error(t, ValidateConfig(config), "Should reject %+v.", config)
+	}
+
+	assert.NoError(t, ValidateConfig(&Config{ServerAddr: ":8080", AdminAddr: ":9090", ReadTimeout: time.Minute}))
+}
+
+// freeAddr returns a local address with a port that is free to listen on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// waitForServer waits for a server to listen on the address
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "Should start listening.")
+}
@@ -0,0 +1,171 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	// apiKeyHeader is the header API keys are sent in
+	apiKeyHeader = "X-API-Key"
+
+	// minTokenSecretLength is the shortest HMAC secret accepted for signing bearer tokens
+	minTokenSecretLength = 32
+
+	// defaultTokenTTL is how long bearer tokens are valid for when Config.TokenTTL is unset
+	defaultTokenTTL = time.Hour
+)
+
+// unauthenticatedError is returned by authenticate when the credentials are missing or not valid.
+// The message is safe to return to the caller.
+type unauthenticatedError struct {
+	message string
+}
+
+func (e *unauthenticatedError) Error() string {
+	return e.message
+}
+
+// authMiddleware rejects requests that are not authenticated with an API key or bearer token and
+// adds the authenticated principal to the request context
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		principal, err := s.authenticate(req)
+		if err != nil {
+			switch err := err.(type) {
+			case *unauthenticatedError:
+				writeUnauthorized(w, req, err.message)
+				return
+			default:
+				writeError(w, req, internalError(err))
+				return
+			}
+		}
+
+		ctx = auth.NewContext(ctx, principal)
+		ctx = log.WithLogger(ctx, log.G(ctx).WithField("principal", principal.Subject))
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// authenticate returns the principal for the credentials sent with the request. Bearer tokens are
+// sent in the Authorization header and API keys in the X-API-Key header.
+func (s *Server) authenticate(req *http.Request) (*auth.Principal, error) {
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		scheme, token, ok := splitAuthorization(authorization)
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, &unauthenticatedError{"unsupported authorization scheme, use a Bearer token"}
+		}
+
+		if s.config.TokenSecret == "" {
+			return nil, &unauthenticatedError{"bearer tokens are not enabled"}
+		}
+
+		claims, err := auth.VerifyToken(token, []byte(s.config.TokenSecret), time.Now())
+		if err != nil {
+			switch {
+			case err == auth.ErrTokenExpired:
+				return nil, &unauthenticatedError{"bearer token has expired"}
+			default:
+				return nil, &unauthenticatedError{"invalid bearer token"}
+			}
+		}
+
+		return claims.Principal(), nil
+	}
+
+	if apiKey := req.Header.Get(apiKeyHeader); apiKey != "" {
+		key, err := s.store.GetAPIKeyByHash(req.Context(), auth.HashAPIKey(apiKey))
+		if err != nil {
+			switch {
+			case err == datastore.ErrNotFound:
+				return nil, &unauthenticatedError{"invalid API key"}
+			default:
+				return nil, err
+			}
+		}
+
+		role, err := auth.ParseRole(key.Role)
+		if err != nil {
+			return nil, fmt.Errorf("API key %d has an invalid role: %w", key.ID, err)
+		}
+
+		return &auth.Principal{
+			Subject:  fmt.Sprintf("apikey:%d", key.ID),
+			PatronID: key.PatronID,
+			Method:   auth.MethodAPIKey,
+			Role:     role,
+		}, nil
+	}
+
+	return nil, &unauthenticatedError{"missing credentials, send an API key in the X-API-Key header or a Bearer token"}
+}
+
+// handlePostToken issues a bearer token to the API key the request is authenticated with
+func (s *Server) handlePostToken(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		writeUnauthorized(w, req, "missing credentials")
+		return
+	}
+
+	// Tokens can't be used to issue more tokens, otherwise a leaked token would never expire
+	if principal.Method != auth.MethodAPIKey {
+		writeForbidden(w, req, "tokens can only be issued to API keys")
+		return
+	}
+
+	ttl := s.config.TokenTTL
+	if ttl == 0 {
+		ttl = defaultTokenTTL
+	}
+
+	claims := auth.NewClaims(principal, time.Now(), ttl)
+	token, err := auth.SignToken(claims, []byte(s.config.TokenSecret))
+	if err != nil {
+		writeError(w, req, internalError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&types.Token{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	})
+	if err != nil {
+		logger.Errorf("handlePostToken: %v", err)
+		return
+	}
+}
+
+// splitAuthorization splits an Authorization header into its scheme and credentials
+func splitAuthorization(authorization string) (string, string, bool) {
+	parts := strings.SplitN(strings.TrimSpace(authorization), " ", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return "", "", false
+	}
+
+	return parts[0], strings.TrimSpace(parts[1]), true
+}
+
+// writeUnauthorized writes a 401 with the same body for every authentication failure
+func writeUnauthorized(w http.ResponseWriter, req *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="givedirectly"`)
+	writeError(w, req, newError(http.StatusUnauthorized, types.ErrorCodeUnauthorized, message))
+}
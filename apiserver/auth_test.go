@@ -0,0 +1,218 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	testAPIKey      = "gdk_testkey"
+	testTokenSecret = "0123456789abcdef0123456789abcdef"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Run("Missing Credentials", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assertUnauthorized(t, resp)
+	})
+
+	t.Run("Valid API Key", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetAPIKeyByHash(gomock.Any(), auth.HashAPIKey(testAPIKey)).
+			Return(&types.APIKey{ID: 1, Name: "test", Role: "librarian"}, nil).Times(1)
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), gomock.Any()).
+			Return(&datastore.BookPage{Books: []*types.Book{}}, nil).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", testAPIKey)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Invalid API Key", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "gdk_wrongkey")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assertUnauthorized(t, resp)
+	})
+
+	t.Run("Valid Bearer Token", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), gomock.Any()).
+			Return(&datastore.BookPage{Books: []*types.Book{}}, nil).Times(1)
+
+		claims := auth.NewClaims(&auth.Principal{Subject: "apikey:1", Role: auth.RoleLibrarian}, time.Now(), time.Hour)
+		token, err := auth.SignToken(claims, []byte(testTokenSecret))
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Invalid Bearer Tokens", func(t *testing.T) {
+		expired, err := auth.SignToken(auth.NewClaims(&auth.Principal{Subject: "apikey:1", Role: auth.RoleLibrarian}, time.Now().Add(-2*time.Hour), time.Hour), []byte(testTokenSecret))
+		require.NoError(t, err)
+
+		wrongSecret, err := auth.SignToken(auth.NewClaims(&auth.Principal{Subject: "apikey:1", Role: auth.RoleLibrarian}, time.Now(), time.Hour), []byte("another secret"))
+		require.NoError(t, err)
+
+		for _, authorization := range []string{"Bearer " + expired, "Bearer " + wrongSecret, "Bearer", "Basic dXNlcjpwYXNz"} {
+			s := Server{
+				config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			}
+
+			testServer := httptest.NewServer(s.newRouter())
+
+			req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", authorization)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			assertUnauthorized(t, resp)
+		}
+	})
+}
+
+func TestHandlePostToken(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetAPIKeyByHash(gomock.Any(), auth.HashAPIKey(testAPIKey)).
+			Return(&types.APIKey{ID: 1, Name: "test", PatronID: 2, Role: "patron"}, nil).Times(1)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/auth/token", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", testAPIKey)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Should be created status code.")
+
+		defer resp.Body.Close()
+		var token types.Token
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Bearer", token.TokenType)
+		assert.WithinDuration(t, time.Now().Add(defaultTokenTTL), token.ExpiresAt, time.Minute)
+
+		claims, err := auth.VerifyToken(token.Token, []byte(testTokenSecret), time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, "apikey:1", claims.Subject, "Should issue the token to the API key.")
+		assert.Equal(t, 2, claims.PatronID)
+		assert.Equal(t, auth.RolePatron, claims.Role, "Should carry the role of the API key.")
+	})
+
+	t.Run("Refuses Tokens", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		token, err := auth.SignToken(auth.NewClaims(&auth.Principal{Subject: "apikey:1", Role: auth.RoleLibrarian}, time.Now(), time.Hour), []byte(testTokenSecret))
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/auth/token", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should not issue tokens to tokens.")
+	})
+}
+
+func assertUnauthorized(t *testing.T, resp *http.Response) {
+	t.Helper()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Should be unauthorized status code.")
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	defer resp.Body.Close()
+	var problem types.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, types.ErrorCodeUnauthorized, problem.Code)
+	assert.NotEmpty(t, problem.Detail)
+}
@@ -0,0 +1,169 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// access is the authorization rule of a route. Callers granted the any permission may use the route on
+// every resource. Callers granted the own permission may only use it on resources belonging to their
+// patron, found by the owner. Routes with an own permission but no owner create resources, and the
+// handler makes them for the caller's patron. A route without permissions is open to every
+// authenticated caller, and a public route is open to everyone without authenticating.
+type access struct {
+	any    auth.Permission
+	own    auth.Permission
+	owner  ownerFunc
+	public bool
+}
+
+// ownerFunc returns the ID of the patron that owns the resource the request is for. If the resource
+// can't be found an error is written to the response and false is returned.
+type ownerFunc func(w http.ResponseWriter, req *http.Request) (int, bool)
+
+// route is an API route and who may call it
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	access  access
+}
+
+// routes returns the API routes. Every route declares its access here rather than checking the caller
+// in its handler.
+func (s *Server) routes() []route {
+	manageRequests := access{any: auth.PermManageRequests}
+	ownRequests := access{any: auth.PermManageRequests, own: auth.PermOwnRequests, owner: s.requestOwner}
+	readCatalog := access{any: auth.PermReadCatalog}
+	manageCatalog := access{any: auth.PermManageCatalog}
+	managePatrons := access{any: auth.PermManagePatrons}
+	ownPatron := access{any: auth.PermManagePatrons, own: auth.PermOwnPatron, owner: parsePatronID}
+	manageKeys := access{any: auth.PermManageKeys}
+	public := access{public: true}
+
+	routes := []route{
+		{"GET", "/healthz", s.handleHealthz, public},
+		{"GET", "/readyz", s.handleReadyz, public},
+		{"GET", "/openapi.json", s.handleGetOpenAPI, public},
+
+		{"POST", "/request", s.handlePostRequest, access{any: auth.PermManageRequests, own: auth.PermOwnRequests}},
+		{"GET", "/request", s.handleListRequest, manageRequests},
+		// Registered before /request/{id} so "overdue" isn't matched as a request ID
+		{"GET", "/request/overdue", s.handleListOverdueRequests, manageRequests},
+		{"GET", "/request/{id}", s.handleGetRequest, ownRequests},
+		{"DELETE", "/request/{id}", s.handleDeleteRequest, manageRequests},
+		{"GET", "/request/{id}/position", s.handleGetQueuePosition, ownRequests},
+		{"POST", "/request/{id}/checkout", s.handleRequestTransition(types.RequestStatusCheckedOut), manageRequests},
+		{"POST", "/request/{id}/return", s.handleRequestTransition(types.RequestStatusReturned), manageRequests},
+		{"POST", "/request/{id}/cancel", s.handleRequestTransition(types.RequestStatusCancelled), ownRequests},
+		{"POST", "/request/{id}/renew", s.handleRenewRequest, ownRequests},
+
+		{"POST", "/book", s.handlePostBook, manageCatalog},
+		{"GET", "/book", s.handleListBook, readCatalog},
+		{"POST", "/book/import", s.handleImportBook, manageCatalog},
+		{"GET", "/book/{id}", s.handleGetBook, readCatalog},
+		{"PUT", "/book/{id}", s.handlePutBook, manageCatalog},
+		{"DELETE", "/book/{id}", s.handleDeleteBook, manageCatalog},
+		{"POST", "/book/{id}/copies", s.handlePostCopy, manageCatalog},
+		{"DELETE", "/book/{id}/copies/{copyID}", s.handleDeleteCopy, manageCatalog},
+
+		{"POST", "/patron", s.handlePostPatron, managePatrons},
+		{"GET", "/patron", s.handleListPatron, managePatrons},
+		{"GET", "/patron/{id}", s.handleGetPatron, ownPatron},
+		{"PUT", "/patron/{id}", s.handlePutPatron, ownPatron},
+		{"GET", "/patron/{id}/requests", s.handleListPatronRequests, ownPatron},
+	}
+
+	// Metrics are served on the admin address instead when there is one
+	if s.config.AdminAddr == "" {
+		routes = append(routes, route{"GET", "/metrics", s.handleGetMetrics, public})
+	}
+
+	if s.config.EnableAuth {
+		routes = append(routes,
+			route{"GET", "/apikey", s.handleListAPIKey, manageKeys},
+			route{"POST", "/apikey", s.handlePostAPIKey, manageKeys},
+			route{"DELETE", "/apikey/{id}", s.handleDeleteAPIKey, manageKeys},
+		)
+
+		if s.config.TokenSecret != "" {
+			routes = append(routes, route{"POST", "/auth/token", s.handlePostToken, access{}})
+		}
+	}
+
+	return routes
+}
+
+// authorize only calls the handler if the authenticated principal is allowed access. Every request is
+// allowed when authentication is disabled.
+func (s *Server) authorize(rule access, handler http.Handler) http.Handler {
+	if !s.config.EnableAuth || (rule.any == "" && rule.own == "") {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal, ok := auth.FromContext(req.Context())
+		if !ok {
+			writeUnauthorized(w, req, "missing credentials")
+			return
+		}
+
+		if rule.any != "" && principal.Can(rule.any) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		if rule.own == "" || !principal.Can(rule.own) {
+			writeForbidden(w, req, "not allowed to "+req.Method+" "+req.URL.Path)
+			return
+		}
+
+		if rule.owner != nil {
+			ownerID, ok := rule.owner(w, req)
+			if !ok {
+				return
+			}
+
+			if !principal.Owns(ownerID) {
+				writeForbidden(w, req, "only allowed to access your own resources")
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// requestOwner returns the patron that made the request in the route variables
+func (s *Server) requestOwner(w http.ResponseWriter, req *http.Request) (int, bool) {
+	requestID, ok := parseRequestID(w, req)
+	if !ok {
+		return 0, false
+	}
+
+	request, err := s.store.GetRequest(req.Context(), requestID)
+	if err != nil {
+		writeError(w, req, storeError(err, "request"))
+		return 0, false
+	}
+
+	return request.PatronID, true
+}
+
+// ownPatronOnly returns the patron the caller is limited to acting for, if the caller is not granted
+// the any permission. It is used by handlers of routes with an own permission but no owner.
+func ownPatronOnly(req *http.Request, any auth.Permission) (int, bool) {
+	principal, ok := auth.FromContext(req.Context())
+	if !ok || principal.Can(any) {
+		return 0, false
+	}
+
+	return principal.PatronID, true
+}
+
+// writeForbidden writes a 403 for callers that are not allowed to make the request
+func writeForbidden(w http.ResponseWriter, req *http.Request, message string) {
+	writeError(w, req, newError(http.StatusForbidden, types.ErrorCodeForbidden, message))
+}
@@ -0,0 +1,232 @@
+package apiserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestAuthorize(t *testing.T) {
+	t.Run("Patron Can Read Catalog", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), gomock.Any()).
+			Return(&datastore.BookPage{Books: []*types.Book{}}, nil).Times(1)
+
+		resp := doWithToken(t, "GET", testServer.URL+"/book", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Patron Can Not Manage Catalog", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp := doWithToken(t, "DELETE", testServer.URL+"/book/1", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+
+	t.Run("Patron Can Not List All Requests", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp := doWithToken(t, "GET", testServer.URL+"/request", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+
+	t.Run("Patron Can Cancel Own Request", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetRequest(gomock.Any(), 1).
+			Return(&types.Request{ID: 1, PatronID: 1, Status: types.RequestStatusRequested}, nil).Times(1)
+		mockLibraryStore.EXPECT().UpdateRequestStatus(gomock.Any(), 1, types.RequestStatusCancelled).
+			Return(&types.Request{ID: 1, PatronID: 1, Status: types.RequestStatusCancelled}, nil).Times(1)
+
+		resp := doWithToken(t, "POST", testServer.URL+"/request/1/cancel", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Patron Can Not Cancel Others Request", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetRequest(gomock.Any(), 1).
+			Return(&types.Request{ID: 1, PatronID: 2, Status: types.RequestStatusRequested}, nil).Times(1)
+
+		resp := doWithToken(t, "POST", testServer.URL+"/request/1/cancel", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+
+	t.Run("Patron Request Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetRequest(gomock.Any(), 1).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		resp := doWithToken(t, "GET", testServer.URL+"/request/1", nil, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should be not found status code.")
+	})
+
+	t.Run("Patron Requests Are Made For Them", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().CreateRequest(gomock.Any(), &types.Request{PatronID: 1, Title: "testbook"}).
+			Return(&types.Book{ID: 1, Title: "testbook", Available: true}, nil).Times(1)
+
+		body := []byte(`{"email": "someone@else.com", "title": "testbook"}`)
+		resp := doWithToken(t, "POST", testServer.URL+"/request", body, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Patron Can Not Request For Others", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		body := []byte(`{"patronId": 2, "title": "testbook"}`)
+		resp := doWithToken(t, "POST", testServer.URL+"/request", body, testToken(t, auth.RolePatron, 1))
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+
+	t.Run("Patron Can Read Own Patron Record", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetPatron(gomock.Any(), 1).
+			Return(&types.Patron{ID: 1, Email: "test@gmail.com"}, nil).Times(1)
+
+		resp := doWithToken(t, "GET", testServer.URL+"/patron/1", nil, testToken(t, auth.RolePatron, 1))
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		resp = doWithToken(t, "GET", testServer.URL+"/patron/2", nil, testToken(t, auth.RolePatron, 1))
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+
+	t.Run("Librarian Can Manage Requests", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().DeleteRequest(gomock.Any(), 1).
+			Return(&types.Request{ID: 1, Status: types.RequestStatusCancelled}, nil).Times(1)
+
+		resp := doWithToken(t, "DELETE", testServer.URL+"/request/1", nil, testToken(t, auth.RoleLibrarian, 0))
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Librarian Can Not Manage Keys", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp := doWithToken(t, "GET", testServer.URL+"/apikey", nil, testToken(t, auth.RoleLibrarian, 0))
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Should be forbidden status code.")
+	})
+}
+
+// testToken returns a bearer token for a caller with the role acting for the patron
+func testToken(t *testing.T, role auth.Role, patronID int) string {
+	t.Helper()
+
+	principal := &auth.Principal{Subject: "apikey:1", PatronID: patronID, Role: role}
+	token, err := auth.SignToken(auth.NewClaims(principal, time.Now(), time.Hour), []byte(testTokenSecret))
+	require.NoError(t, err)
+
+	return token
+}
+
+// doWithToken sends the request authenticated with the bearer token
+func doWithToken(t *testing.T, method, url string, body []byte, token string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
@@ -0,0 +1,345 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/importer"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func (s *Server) handlePostBook(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	var book *types.Book
+	if err := json.NewDecoder(req.Body).Decode(&book); err != nil || book == nil {
+		writeError(w, req, badRequest("request body must be a JSON book"))
+		return
+	}
+
+	// Validate title
+	if len(book.Title) == 0 {
+		writeError(w, req, invalidFields(types.FieldError{Field: "title", Message: "must supply a title"}))
+		return
+	}
+
+	if book.ISBN != "" && !datastore.ValidISBN(datastore.NormalizeISBN(book.ISBN)) {
+		writeError(w, req, invalidFields(types.FieldError{Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"}))
+		return
+	}
+
+	// Validate copies, with the same limit as imported books
+	if len(book.Copies) > importer.MaxCopies {
+		writeError(w, req, invalidFields(types.FieldError{Field: "copies", Message: fmt.Sprintf("must be at most %d copies", importer.MaxCopies)}))
+		return
+	}
+
+	for _, c := range book.Copies {
+		if c == nil {
+			writeError(w, req, invalidFields(types.FieldError{Field: "copies", Message: "must not contain null copies"}))
+			return
+		}
+	}
+
+	book, err := s.store.CreateBook(ctx, book)
+	if err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(book); err != nil {
+		logger.Errorf("handlePostBook: %v", err)
+		return
+	}
+}
+
+// maxImportSize is the largest file POST /book/import accepts
+const maxImportSize = 32 << 20
+
+// importContentTypes are the media types of import files, for requests without a format parameter
+var importContentTypes = map[string]importer.Format{
+	"text/csv":              importer.FormatCSV,
+	"application/x-ndjson":  importer.FormatJSONL,
+	"application/jsonl":     importer.FormatJSONL,
+	"application/jsonlines": importer.FormatJSONL,
+}
+
+// handleImportBook adds the books in a CSV or JSON Lines body to the catalog, responding with a report of
+// the rows that were skipped or failed
+func (s *Server) handleImportBook(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	format, apiErr := importFormat(req)
+	if apiErr != nil {
+		writeError(w, req, apiErr)
+		return
+	}
+
+	body := &importBody{r: req.Body, remaining: maxImportSize}
+	report, err := importer.Import(ctx, s.store, body, importer.Options{Format: format, Kind: importer.KindBooks})
+	if err != nil {
+		var apiErr *apiError
+		switch {
+		case body.tooLarge:
+			apiErr = newError(http.StatusRequestEntityTooLarge, types.ErrorCodeTooLarge, errImportTooLarge.Error())
+		case errors.Is(err, importer.ErrInvalidFile):
+			apiErr = badRequest(err.Error())
+		default:
+			apiErr = storeError(err, "book")
+		}
+
+		// Batches imported before the error are kept, so the caller needs to know which rows they were
+		apiErr.report = report
+		writeError(w, req, apiErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Errorf("handleImportBook: %v", err)
+		return
+	}
+}
+
+// errImportTooLarge the import file is larger than maxImportSize
+var errImportTooLarge = fmt.Errorf("import file must be at most %dMB", maxImportSize>>20)
+
+// importBody reads at most maxImportSize bytes of an import file, failing with errImportTooLarge if the
+// file is larger
+type importBody struct {
+	r         io.Reader
+	remaining int64
+	tooLarge  bool
+}
+
+func (b *importBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Read past the limit to tell a file of exactly the limit from a larger one
+		var extra [1]byte
+		if n, err := b.r.Read(extra[:]); n == 0 {
+			return 0, err
+		}
+
+		b.tooLarge = true
+		return 0, errImportTooLarge
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// importFormat returns the format of the import file from the format query parameter, or else the
+// Content-Type of the request
+func importFormat(req *http.Request) (importer.Format, *apiError) {
+	if name := req.URL.Query().Get("format"); name != "" {
+		format, err := importer.ParseFormat(name)
+		if err != nil {
+			return "", newError(http.StatusBadRequest, types.ErrorCodeInvalidQuery, err.Error())
+		}
+		return format, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if format, ok := importContentTypes[mediaType]; ok && err == nil {
+		return format, nil
+	}
+
+	return "", newError(http.StatusUnsupportedMediaType, types.ErrorCodeInvalidRequest,
+		"request body must be text/csv or application/x-ndjson, or give the format parameter")
+}
+
+func (s *Server) handleListBook(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	query, err := parseBookQuery(req.URL.Query())
+	if err != nil {
+		writeError(w, req, newError(http.StatusBadRequest, types.ErrorCodeInvalidQuery, err.Error()))
+		return
+	}
+
+	page, err := s.store.ListBook(ctx, query)
+	if err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	setNextLink(w, req, page.NextCursor)
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page.Books); err != nil {
+		logger.Errorf("handleListBook: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleGetBook(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	bookID, ok := parseBookID(w, req)
+	if !ok {
+		return
+	}
+
+	book, err := s.store.GetBook(ctx, bookID)
+	if err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(book); err != nil {
+		logger.Errorf("handleGetBook: %v", err)
+		return
+	}
+}
+
+func (s *Server) handlePutBook(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	bookID, ok := parseBookID(w, req)
+	if !ok {
+		return
+	}
+
+	var book *types.Book
+	if err := json.NewDecoder(req.Body).Decode(&book); err != nil || book == nil {
+		writeError(w, req, badRequest("request body must be a JSON book"))
+		return
+	}
+
+	// Validate title
+	if len(book.Title) == 0 {
+		writeError(w, req, invalidFields(types.FieldError{Field: "title", Message: "must supply a title"}))
+		return
+	}
+
+	// Books without an ISBN in the body keep the one they have
+	if book.ISBN != "" && !datastore.ValidISBN(datastore.NormalizeISBN(book.ISBN)) {
+		writeError(w, req, invalidFields(types.FieldError{Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"}))
+		return
+	}
+
+	// The ID in the path always wins over the one in the body
+	book.ID = bookID
+
+	book, err := s.store.UpdateBook(ctx, book)
+	if err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(book); err != nil {
+		logger.Errorf("handlePutBook: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleDeleteBook(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	bookID, ok := parseBookID(w, req)
+	if !ok {
+		return
+	}
+
+	if err := s.store.DeleteBook(ctx, bookID); err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePostCopy(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	bookID, ok := parseBookID(w, req)
+	if !ok {
+		return
+	}
+
+	// New copies are available unless the body says otherwise
+	c := &types.Copy{Available: true}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(c); err != nil {
+			writeError(w, req, badRequest("request body must be a JSON copy"))
+			return
+		}
+	}
+
+	c, err := s.store.AddCopy(ctx, bookID, c)
+	if err != nil {
+		writeError(w, req, storeError(err, "book"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		logger.Errorf("handlePostCopy: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleDeleteCopy(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	bookID, ok := parseBookID(w, req)
+	if !ok {
+		return
+	}
+
+	copyID, err := strconv.Atoi(mux.Vars(req)["copyID"])
+	if err != nil {
+		writeError(w, req, badRequest("invalid copy id"))
+		return
+	}
+
+	if err := s.store.DeleteCopy(ctx, bookID, copyID); err != nil {
+		writeError(w, req, storeError(err, "copy"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseBookID reads the book ID from the route variables. If it is invalid a bad request is
+// written to the response and false is returned.
+func parseBookID(w http.ResponseWriter, req *http.Request) (int, bool) {
+	bookID, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, req, badRequest("invalid book id"))
+		return 0, false
+	}
+
+	return bookID, true
+}
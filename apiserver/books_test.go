@@ -0,0 +1,692 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/importer"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestHandlePostBook(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		book := &types.Book{
+			Title:     testTitle,
+			Available: true,
+		}
+
+		mockLibraryStore.EXPECT().CreateBook(gomock.Any(), book).
+			Return(&types.Book{
+				ID:        1,
+				Title:     testTitle,
+				Available: true,
+			}, nil).Times(1)
+
+		b, err := json.Marshal(book)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/book", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Should be created status code.")
+
+		defer resp.Body.Close()
+		var retBook types.Book
+		err = json.NewDecoder(resp.Body).Decode(&retBook)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, retBook.ID, "Should return the created book.")
+	})
+
+	t.Run("Invalid Title", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		b, err := json.Marshal(types.Book{Available: true})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/book", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+	})
+
+	t.Run("Invalid ISBN", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		b, err := json.Marshal(types.Book{Title: testTitle, ISBN: "978-0-306-40615-8"})
+		require.NoError(t, err)
+
+		resp, err := http.Post(testServer.URL+"/book", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+
+		var problem types.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		require.Len(t, problem.Errors, 1)
+		assert.Equal(t, "isbn", problem.Errors[0].Field)
+	})
+
+	t.Run("Invalid Copies", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		for name, body := range map[string]string{
+			"Null Copy": `{"title": "` + testTitle + `", "copies": [{"available": true}, null]}`,
+			"Too Many":  `{"title": "` + testTitle + `", "copies": [` + strings.Repeat(`{},`, importer.MaxCopies) + `{}]}`,
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp, err := http.Post(testServer.URL+"/book", "application/json", strings.NewReader(body))
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+
+				var problem types.Problem
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+				require.Len(t, problem.Errors, 1)
+				assert.Equal(t, "copies", problem.Errors[0].Field)
+			})
+		}
+	})
+}
+
+func TestHandleGetBook(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), testBookID).
+			Return(&types.Book{
+				ID:        testBookID,
+				Title:     testTitle,
+				Available: true,
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retBook types.Book
+		err = json.NewDecoder(resp.Body).Decode(&retBook)
+		require.NoError(t, err)
+
+		assert.Equal(t, testBookID, retBook.ID, "Should return correct book.")
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), testBookID).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no book.")
+	})
+
+	t.Run("Invalid Book ID", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		url := fmt.Sprintf("%s/%s", testServer.URL+"/book", "invalidBookID")
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+	})
+}
+
+func TestHandleListBooks(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		retBooks := []*types.Book{}
+		for i := 0; i < 10; i++ {
+			retBooks = append(retBooks, &types.Book{
+				ID:    i,
+				Title: fmt.Sprintf("test%d", i),
+			})
+		}
+
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), &datastore.BookQuery{}).
+			Return(&datastore.BookPage{Books: retBooks}, nil).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var books []*types.Book
+		err = json.NewDecoder(resp.Body).Decode(&books)
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, len(books), "Should return correct num of books.")
+	})
+
+	t.Run("Query And Next Link", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		available := true
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), &datastore.BookQuery{
+			Page:      datastore.Page{Limit: 5, Sort: "title"},
+			Title:     "go",
+			Available: &available,
+		}).Return(&datastore.BookPage{Books: []*types.Book{}, NextCursor: "next"}, nil).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book?limit=5&sort=title&title=go&available=true", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+		assert.Equal(t, `</book?available=true&cursor=next&limit=5&sort=title&title=go>; rel="next"`, resp.Header.Get("Link"))
+	})
+
+	t.Run("Invalid Query", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book?available=maybe", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be bad request status code.")
+	})
+
+	t.Run("Datastore error", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().ListBook(gomock.Any(), gomock.Any()).
+			Return(nil, fmt.Errorf("random error")).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Should be internal error status code.")
+	})
+}
+
+func TestHandlePutBook(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		// The path ID should be used over the ID in the body
+		mockLibraryStore.EXPECT().UpdateBook(gomock.Any(), &types.Book{ID: testBookID, Title: "newTitle", Available: true}).
+			Return(&types.Book{
+				ID:        testBookID,
+				Title:     "newTitle",
+				Available: true,
+			}, nil).Times(1)
+
+		b, err := json.Marshal(types.Book{ID: 1, Title: "newTitle", Available: true})
+		require.NoError(t, err)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retBook types.Book
+		err = json.NewDecoder(resp.Body).Decode(&retBook)
+		require.NoError(t, err)
+
+		assert.Equal(t, "newTitle", retBook.Title, "Should return the updated book.")
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().UpdateBook(gomock.Any(), gomock.Any()).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		b, err := json.Marshal(types.Book{Title: testTitle})
+		require.NoError(t, err)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no book.")
+	})
+
+	t.Run("Invalid ISBN", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		b, err := json.Marshal(types.Book{Title: testTitle, ISBN: "978-0-306-40615-8"})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("PUT", testServer.URL+"/book/123", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+
+		var problem types.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		require.Len(t, problem.Errors, 1)
+		assert.Equal(t, "isbn", problem.Errors[0].Field)
+	})
+}
+
+func TestHandleDeleteBook(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().DeleteBook(gomock.Any(), testBookID).
+			Return(nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().DeleteBook(gomock.Any(), testBookID).
+			Return(datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no book.")
+	})
+}
+
+func TestHandlePostCopy(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().AddCopy(gomock.Any(), testBookID, &types.Copy{Available: true}).
+			Return(&types.Copy{
+				ID:        1,
+				BookID:    testBookID,
+				Available: true,
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/copies", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Should be created status code.")
+
+		defer resp.Body.Close()
+		var retCopy types.Copy
+		err = json.NewDecoder(resp.Body).Decode(&retCopy)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, retCopy.ID, "Should return the created copy.")
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID := 123
+
+		mockLibraryStore.EXPECT().AddCopy(gomock.Any(), testBookID, gomock.Any()).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d/copies", testServer.URL+"/book", testBookID)
+		req, err := http.NewRequest("POST", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no book.")
+	})
+}
+
+func TestHandleDeleteCopy(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID, testCopyID := 123, 4
+
+		mockLibraryStore.EXPECT().DeleteCopy(gomock.Any(), testBookID, testCopyID).
+			Return(nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/copies/%d", testServer.URL+"/book", testBookID, testCopyID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Copy Lent Out", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testBookID, testCopyID := 123, 4
+
+		mockLibraryStore.EXPECT().DeleteCopy(gomock.Any(), testBookID, testCopyID).
+			Return(datastore.ErrInUse).Times(1)
+
+		url := fmt.Sprintf("%s/%d/copies/%d", testServer.URL+"/book", testBookID, testCopyID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should return conflict for a lent copy.")
+	})
+}
+
+func TestHandleImportBook(t *testing.T) {
+	post := func(t *testing.T, s *Server, url, contentType, body string) *http.Response {
+		testServer := httptest.NewServer(s.newRouter())
+		t.Cleanup(testServer.Close)
+
+		req, err := http.NewRequest("POST", testServer.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		store := datastore.NewMemoryStore()
+		_, err := store.CreateBook(context.Background(), &types.Book{Title: testTitle, Available: true})
+		require.NoError(t, err)
+
+		s := &Server{config: &Config{}, store: store}
+		resp := post(t, s, "/book/import", "text/csv; charset=utf-8", "title,isbn,copies\n"+testTitle+",,\nfirst,0-306-40615-2,2\nsecond,123,\n")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report types.ImportReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Equal(t, types.ImportReport{Rows: 3, Imported: 1, Skipped: 1, Failed: 1, Errors: []types.ImportError{
+			{Row: 2, Field: "title", Message: "title already in the catalog", Skipped: true},
+			{Row: 4, Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"},
+		}}, report)
+
+		page, err := store.ListBook(context.Background(), &datastore.BookQuery{Title: "first"})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 1)
+		assert.Len(t, page.Books[0].Copies, 2)
+	})
+
+	t.Run("Format Parameter", func(t *testing.T) {
+		s := &Server{config: &Config{}, store: datastore.NewMemoryStore()}
+		resp := post(t, s, "/book/import?format=jsonl", "application/octet-stream", `{"title": "first", "available": true}`+"\n")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report types.ImportReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Equal(t, types.ImportReport{Rows: 1, Imported: 1}, report)
+	})
+
+	t.Run("Unknown Format", func(t *testing.T) {
+		s := &Server{config: &Config{}}
+		assert.Equal(t, http.StatusUnsupportedMediaType, post(t, s, "/book/import", "application/json", "{}").StatusCode)
+		assert.Equal(t, http.StatusBadRequest, post(t, s, "/book/import?format=xml", "", "").StatusCode)
+	})
+
+	t.Run("Invalid File", func(t *testing.T) {
+		s := &Server{config: &Config{}, store: datastore.NewMemoryStore()}
+		resp := post(t, s, "/book/import", "text/csv", "title,author\n")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var problem types.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		assert.Equal(t, types.ErrorCodeInvalidRequest, problem.Code)
+		assert.Contains(t, problem.Detail, "unknown column 'author'")
+	})
+
+	t.Run("Partial Import", func(t *testing.T) {
+		store := datastore.NewMemoryStore()
+		s := &Server{config: &Config{}, store: store}
+
+		var body strings.Builder
+		body.WriteString("title\n")
+		for i := 0; i < importer.DefaultBatchSize+10; i++ {
+			fmt.Fprintf(&body, "book %d\n", i)
+		}
+		body.WriteString("a \"quoted\" title\n")
+
+		resp := post(t, s, "/book/import", "text/csv", body.String())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var problem types.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		require.NotNil(t, problem.Report, "Should report the rows imported before the error.")
+		assert.Equal(t, importer.DefaultBatchSize, problem.Report.Imported)
+
+		page, err := store.ListBook(context.Background(), &datastore.BookQuery{Page: datastore.Page{Limit: datastore.MaxPageSize}})
+		require.NoError(t, err)
+		assert.Len(t, page.Books, importer.DefaultBatchSize, "Should keep the first batch.")
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		s := &Server{config: &Config{}, store: datastore.NewMemoryStore()}
+		resp := post(t, s, "/book/import", "text/csv", "title\n\""+strings.Repeat("a", maxImportSize)+"\"\n")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		var problem types.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		assert.Equal(t, types.ErrorCodeTooLarge, problem.Code)
+	})
+}
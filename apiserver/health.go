@@ -0,0 +1,150 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// readyCheckTimeout is how long each readiness check can take before it fails
+const readyCheckTimeout = 2 * time.Second
+
+// Pinger is implemented by stores backed by a database, so readiness can check the database is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// MigrationReporter is implemented by stores with a migrated schema, so readiness can check every
+// migration is applied
+type MigrationReporter interface {
+	MigrationStatus(ctx context.Context) ([]*datastore.MigrationStatus, error)
+}
+
+var (
+	_ Pinger            = (*datastore.SQLStore)(nil)
+	_ MigrationReporter = (*datastore.SQLStore)(nil)
+)
+
+// healthCheck checks a dependency, returning a detail of the result and an error if it failed
+type healthCheck func(ctx context.Context) (string, error)
+
+// readyChecks returns the checks the server must pass to be ready, keyed by their names
+func (s *Server) readyChecks() map[string]healthCheck {
+	checks := map[string]healthCheck{
+		"shutdown": func(context.Context) (string, error) {
+			if s.isShuttingDown() {
+				return "", errors.New("the server is shutting down")
+			}
+			return "", nil
+		},
+	}
+
+	store := unwrapStore(s.store)
+
+	if pinger, ok := store.(Pinger); ok {
+		checks["datastore"] = func(ctx context.Context) (string, error) {
+			return "", pinger.Ping(ctx)
+		}
+	}
+
+	if reporter, ok := store.(MigrationReporter); ok {
+		checks["migrations"] = func(ctx context.Context) (string, error) {
+			statuses, err := reporter.MigrationStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			pending := 0
+			for _, status := range statuses {
+				if !status.Applied {
+					pending++
+				}
+			}
+
+			if pending > 0 {
+				return "", fmt.Errorf("%d of %d migrations are pending", pending, len(statuses))
+			}
+
+			return fmt.Sprintf("all %d migrations are applied", len(statuses)), nil
+		}
+	}
+
+	return checks
+}
+
+// handleHealthz reports the server is alive. It doesn't check any dependencies, so a failing database
+// doesn't get the server restarted.
+func (s *Server) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, req, &types.Health{Status: types.HealthStatusOK})
+}
+
+// handleReadyz reports whether the server can serve requests, with the result of every check. The
+// server is unavailable if any check fails.
+func (s *Server) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	health := &types.Health{
+		Status: types.HealthStatusOK,
+		Checks: map[string]*types.HealthCheck{},
+	}
+
+	for name, check := range s.readyChecks() {
+		result := runCheck(req.Context(), check)
+		if result.Status != types.HealthStatusOK {
+			health.Status = types.HealthStatusUnavailable
+		}
+
+		health.Checks[name] = result
+	}
+
+	writeHealth(w, req, health)
+}
+
+// runCheck runs the check with the ready check timeout
+func runCheck(ctx context.Context, check healthCheck) *types.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check(ctx)
+
+	result := &types.HealthCheck{
+		Status:   types.HealthStatusOK,
+		Detail:   detail,
+		Duration: time.Since(start).String(),
+	}
+
+	if err != nil {
+		result.Status = types.HealthStatusUnavailable
+		result.Detail = err.Error()
+	}
+
+	return result
+}
+
+func writeHealth(w http.ResponseWriter, req *http.Request, health *types.Health) {
+	status := http.StatusOK
+	if health.Status != types.HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.G(req.Context()).Errorf("writeHealth: %v", err)
+		return
+	}
+}
+
+// isShuttingDown returns true once the server has started shutting down
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
@@ -0,0 +1,158 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// healthStore is a store backed by a database, which only implements the readiness checks
+type healthStore struct {
+	LibraryStore
+	pingErr    error
+	migrations []*datastore.MigrationStatus
+}
+
+func (s *healthStore) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s *healthStore) MigrationStatus(ctx context.Context) ([]*datastore.MigrationStatus, error) {
+	return s.migrations, nil
+}
+
+func getHealth(t *testing.T, url string) (int, *types.Health) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+	var health types.Health
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+
+	return resp.StatusCode, &health
+}
+
+func TestHandleHealthz(t *testing.T) {
+	t.Run("Alive Without Credentials", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true},
+			store:  &healthStore{pingErr: errors.New("connection refused")},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/healthz")
+
+		assert.Equal(t, http.StatusOK, status, "Should not check dependencies.")
+		assert.Equal(t, types.HealthStatusOK, health.Status)
+	})
+}
+
+func TestHandleReadyz(t *testing.T) {
+	applied := []*datastore.MigrationStatus{
+		{Version: 1, Name: "create_books_and_requests", Applied: true},
+		{Version: 2, Name: "request_status", Applied: true},
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true},
+			store:  &healthStore{migrations: applied},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, types.HealthStatusOK, health.Status)
+		require.Contains(t, health.Checks, "datastore")
+		require.Contains(t, health.Checks, "migrations")
+		assert.Equal(t, "all 2 migrations are applied", health.Checks["migrations"].Detail)
+		assert.NotEmpty(t, health.Checks["datastore"].Duration)
+	})
+
+	t.Run("Datastore Unreachable", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+			store:  &healthStore{pingErr: errors.New("connection refused"), migrations: applied},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, types.HealthStatusUnavailable, health.Status)
+		assert.Equal(t, types.HealthStatusUnavailable, health.Checks["datastore"].Status)
+		assert.Equal(t, "connection refused", health.Checks["datastore"].Detail)
+		assert.Equal(t, types.HealthStatusOK, health.Checks["migrations"].Status)
+	})
+
+	t.Run("Pending Migrations", func(t *testing.T) {
+		pending := append(applied, &datastore.MigrationStatus{Version: 3, Name: "request_waitlist"})
+		s := Server{
+			config: &Config{},
+			store:  &healthStore{migrations: pending},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "1 of 3 migrations are pending", health.Checks["migrations"].Detail)
+	})
+
+	t.Run("Shutting Down", func(t *testing.T) {
+		s := Server{
+			config:       &Config{},
+			store:        &healthStore{migrations: applied},
+			shuttingDown: 1,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, types.HealthStatusUnavailable, health.Checks["shutdown"].Status)
+	})
+
+	t.Run("Store Wrapped For Metrics", func(t *testing.T) {
+		s, err := NewServer(&healthStore{pingErr: errors.New("connection refused"), migrations: applied}, &Config{ServerAddr: ":0"})
+		require.NoError(t, err)
+
+		testServer := httptest.NewServer(s.Handler())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Contains(t, health.Checks, "datastore", "Should check the store the metrics wrap.")
+	})
+
+	t.Run("Store Without Checks", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+			store:  datastore.NewMemoryStore(),
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		status, health := getHealth(t, testServer.URL+"/readyz")
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Len(t, health.Checks, 1, "Should only check the server isn't shutting down.")
+	})
+}
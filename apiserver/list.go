@@ -0,0 +1,118 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// parsePage reads the limit, cursor and sort query parameters of a listing
+func parsePage(values url.Values) (datastore.Page, error) {
+	page := datastore.Page{
+		Cursor: values.Get("cursor"),
+		Sort:   values.Get("sort"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("invalid limit '%s', must be a positive number", limit)
+		}
+
+		page.Limit = n
+	}
+
+	return page, nil
+}
+
+// parseRequestQuery reads the query parameters of a request listing. Statuses can be repeated or
+// comma separated and the created range is given in RFC 3339.
+func parseRequestQuery(values url.Values) (*datastore.RequestQuery, error) {
+	page, err := parsePage(values)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &datastore.RequestQuery{
+		Page:  page,
+		Email: values.Get("email"),
+		Title: values.Get("title"),
+	}
+
+	for _, statuses := range values["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				query.Statuses = append(query.Statuses, types.RequestStatus(status))
+			}
+		}
+	}
+
+	if query.CreatedAfter, err = parseTimeParam(values, "createdAfter"); err != nil {
+		return nil, err
+	}
+
+	if query.CreatedBefore, err = parseTimeParam(values, "createdBefore"); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// parseBookQuery reads the query parameters of a book listing
+func parseBookQuery(values url.Values) (*datastore.BookQuery, error) {
+	page, err := parsePage(values)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &datastore.BookQuery{
+		Page:  page,
+		Title: values.Get("title"),
+	}
+
+	if available := values.Get("available"); available != "" {
+		b, err := strconv.ParseBool(available)
+		if err != nil {
+			return nil, fmt.Errorf("invalid available '%s', must be true or false", available)
+		}
+
+		query.Available = &b
+	}
+
+	return query, nil
+}
+
+// parseTimeParam reads an optional RFC 3339 time query parameter
+func parseTimeParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s', must be an RFC 3339 time", name, value)
+	}
+
+	return &t, nil
+}
+
+// setNextLink adds a Link header for the next page of a listing, which is the same request with the
+// cursor of the next page. Nothing is added on the last page.
+func setNextLink(w http.ResponseWriter, req *http.Request, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+
+	values := req.URL.Query()
+	values.Set("cursor", nextCursor)
+
+	next := url.URL{Path: req.URL.Path, RawQuery: values.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
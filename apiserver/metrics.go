@@ -0,0 +1,405 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/metrics"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// unmatchedRoute is the route label of requests that didn't match a route
+const unmatchedRoute = "unmatched"
+
+// StatsReporter is implemented by stores backed by a connection pool, so the pool can be monitored
+type StatsReporter interface {
+	Stats() sql.DBStats
+}
+
+var _ StatsReporter = (*datastore.SQLStore)(nil)
+
+// serverMetrics are the metrics recorded by the server. A nil serverMetrics records nothing, so servers
+// built without NewServer don't need them.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	httpRequests *metrics.Counter
+	httpDuration *metrics.Histogram
+
+	storeDuration *metrics.Histogram
+	storeErrors   *metrics.Counter
+
+	requestsCreated     *metrics.Counter
+	requestsUnavailable *metrics.Counter
+	requestsReturned    *metrics.Counter
+}
+
+// newServerMetrics creates the server's metrics, including the connection pool of the store if it has one
+func newServerMetrics(store LibraryStore) *serverMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &serverMetrics{
+		registry: registry,
+		httpRequests: registry.NewCounter("givedirectly_http_requests_total",
+			"HTTP requests handled, by method, route template and status code.", "method", "route", "code"),
+		httpDuration: registry.NewHistogram("givedirectly_http_request_duration_seconds",
+			"How long HTTP requests took to handle, by method and route template.", metrics.DefaultBuckets, "method", "route"),
+		storeDuration: registry.NewHistogram("givedirectly_datastore_duration_seconds",
+			"How long datastore operations took, by operation.", metrics.DefaultBuckets, "operation"),
+		storeErrors: registry.NewCounter("givedirectly_datastore_errors_total",
+			"Datastore operations that failed with an unexpected error, by operation.", "operation"),
+		requestsCreated: registry.NewCounter("givedirectly_requests_created_total",
+			"Book requests created."),
+		requestsUnavailable: registry.NewCounter("givedirectly_requests_unavailable_total",
+			"Book requests made while no copy was available, which were added to the book's waitlist."),
+		requestsReturned: registry.NewCounter("givedirectly_requests_returned_total",
+			"Book requests returned."),
+	}
+
+	if reporter, ok := store.(StatsReporter); ok {
+		registerPoolMetrics(registry, reporter)
+	}
+
+	return m
+}
+
+// registerPoolMetrics registers gauges of the connection pool, read when the metrics are scraped
+func registerPoolMetrics(registry *metrics.Registry, reporter StatsReporter) {
+	stat := func(fn func(stats sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(reporter.Stats())
+		}
+	}
+
+	registry.NewGaugeFunc("givedirectly_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("givedirectly_db_open_connections", "Open connections to the database, in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("givedirectly_db_in_use_connections", "Connections to the database in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("givedirectly_db_idle_connections", "Idle connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("givedirectly_db_wait_count_total", "Times a connection was waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("givedirectly_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("givedirectly_db_max_idle_closed_total", "Connections closed because the pool had too many idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("givedirectly_db_max_lifetime_closed_total", "Connections closed because they reached their maximum lifetime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+// instrument records the requests handled by the handler. Requests are labeled with the route template
+// rather than their path, so IDs in the path don't make a series per resource.
+func (m *serverMetrics) instrument(method, route string, handler http.Handler) http.Handler {
+	if m == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, req)
+
+		// Unmatched requests are labeled with their method, which the route doesn't give
+		requestMethod := method
+		if requestMethod == "" {
+			requestMethod = req.Method
+		}
+		m.httpRequests.Inc(requestMethod, route, strconv.Itoa(recorder.status))
+		m.httpDuration.Observe(time.Since(start).Seconds(), requestMethod, route)
+	})
+}
+
+// requestCreated counts a request made through the API
+func (m *serverMetrics) requestCreated(request *types.Request) {
+	if m == nil {
+		return
+	}
+
+	m.requestsCreated.Inc()
+	if request.Status == types.RequestStatusWaiting {
+		m.requestsUnavailable.Inc()
+	}
+}
+
+// requestTransitioned counts a request moved to the status through the API, either by a transition or by
+// deleting it, which closes it with a status that depends on whether it was checked out
+func (m *serverMetrics) requestTransitioned(status types.RequestStatus) {
+	if m == nil {
+		return
+	}
+
+	if status == types.RequestStatusReturned {
+		m.requestsReturned.Inc()
+	}
+}
+
+// startStore starts timing the datastore operation. The returned func records how long it took and
+// whether it failed. Errors the API expects, such as ErrNotFound or a policy refusing a request, are
+// answers rather than failures so they are not counted.
+func (m *serverMetrics) startStore(operation string) func(err error) {
+	start := time.Now()
+
+	return func(err error) {
+		m.storeDuration.Observe(time.Since(start).Seconds(), operation)
+		if err != nil && storeError(err, "").status >= http.StatusInternalServerError {
+			m.storeErrors.Inc(operation)
+		}
+	}
+}
+
+// handler returns the handler serving the metrics to Prometheus
+func (m *serverMetrics) handler() http.Handler {
+	if m == nil {
+		return metrics.NewRegistry().Handler()
+	}
+
+	return m.registry.Handler()
+}
+
+func (s *Server) handleGetMetrics(w http.ResponseWriter, req *http.Request) {
+	s.metrics.handler().ServeHTTP(w, req)
+}
+
+// statusRecorder records the status code written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status = status
+		r.wrote = true
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return r.ResponseWriter.Write(b)
+}
+
+// metricsStore records the latency and errors of every operation of the store it wraps
+type metricsStore struct {
+	store   LibraryStore
+	metrics *serverMetrics
+}
+
+// unwrapStore returns the store wrapped by the metrics store, so the optional interfaces of the store
+// can be found
+func unwrapStore(store LibraryStore) LibraryStore {
+	if m, ok := store.(*metricsStore); ok {
+		return m.store
+	}
+
+	return store
+}
+
+func (m *metricsStore) CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error) {
+	done := m.metrics.startStore("CreateRequest")
+	result, err := m.store.CreateRequest(ctx, request)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) GetRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	done := m.metrics.startStore("GetRequest")
+	result, err := m.store.GetRequest(ctx, requestID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListRequest(ctx context.Context, query *datastore.RequestQuery) (*datastore.RequestPage, error) {
+	done := m.metrics.startStore("ListRequest")
+	result, err := m.store.ListRequest(ctx, query)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) DeleteRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	done := m.metrics.startStore("DeleteRequest")
+	result, err := m.store.DeleteRequest(ctx, requestID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) UpdateRequestStatus(ctx context.Context, requestID int, status types.RequestStatus) (*types.Request, error) {
+	done := m.metrics.startStore("UpdateRequestStatus")
+	result, err := m.store.UpdateRequestStatus(ctx, requestID, status)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) GetQueuePosition(ctx context.Context, requestID int) (*types.QueuePosition, error) {
+	done := m.metrics.startStore("GetQueuePosition")
+	result, err := m.store.GetQueuePosition(ctx, requestID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) RenewRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	done := m.metrics.startStore("RenewRequest")
+	result, err := m.store.RenewRequest(ctx, requestID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListOverdueRequests(ctx context.Context, asOf time.Time) ([]*types.Request, error) {
+	done := m.metrics.startStore("ListOverdueRequests")
+	result, err := m.store.ListOverdueRequests(ctx, asOf)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) CreateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	done := m.metrics.startStore("CreateBook")
+	result, err := m.store.CreateBook(ctx, book)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error) {
+	done := m.metrics.startStore("CreateBooks")
+	result, err := m.store.CreateBooks(ctx, books)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) GetBook(ctx context.Context, bookID int) (*types.Book, error) {
+	done := m.metrics.startStore("GetBook")
+	result, err := m.store.GetBook(ctx, bookID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListBook(ctx context.Context, query *datastore.BookQuery) (*datastore.BookPage, error) {
+	done := m.metrics.startStore("ListBook")
+	result, err := m.store.ListBook(ctx, query)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) UpdateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	done := m.metrics.startStore("UpdateBook")
+	result, err := m.store.UpdateBook(ctx, book)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) DeleteBook(ctx context.Context, bookID int) error {
+	done := m.metrics.startStore("DeleteBook")
+	err := m.store.DeleteBook(ctx, bookID)
+	done(err)
+
+	return err
+}
+
+func (m *metricsStore) AddCopy(ctx context.Context, bookID int, c *types.Copy) (*types.Copy, error) {
+	done := m.metrics.startStore("AddCopy")
+	result, err := m.store.AddCopy(ctx, bookID, c)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) DeleteCopy(ctx context.Context, bookID, copyID int) error {
+	done := m.metrics.startStore("DeleteCopy")
+	err := m.store.DeleteCopy(ctx, bookID, copyID)
+	done(err)
+
+	return err
+}
+
+func (m *metricsStore) CreatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	done := m.metrics.startStore("CreatePatron")
+	result, err := m.store.CreatePatron(ctx, patron)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) GetPatron(ctx context.Context, patronID int) (*types.Patron, error) {
+	done := m.metrics.startStore("GetPatron")
+	result, err := m.store.GetPatron(ctx, patronID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListPatron(ctx context.Context) ([]*types.Patron, error) {
+	done := m.metrics.startStore("ListPatron")
+	result, err := m.store.ListPatron(ctx)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) UpdatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	done := m.metrics.startStore("UpdatePatron")
+	result, err := m.store.UpdatePatron(ctx, patron)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListPatronRequests(ctx context.Context, patronID int) ([]*types.Request, error) {
+	done := m.metrics.startStore("ListPatronRequests")
+	result, err := m.store.ListPatronRequests(ctx, patronID)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) CreateAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	done := m.metrics.startStore("CreateAPIKey")
+	result, err := m.store.CreateAPIKey(ctx, key)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error) {
+	done := m.metrics.startStore("GetAPIKeyByHash")
+	result, err := m.store.GetAPIKeyByHash(ctx, hash)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) ListAPIKeys(ctx context.Context) ([]*types.APIKey, error) {
+	done := m.metrics.startStore("ListAPIKeys")
+	result, err := m.store.ListAPIKeys(ctx)
+	done(err)
+
+	return result, err
+}
+
+func (m *metricsStore) DeleteAPIKey(ctx context.Context, keyID int) error {
+	done := m.metrics.startStore("DeleteAPIKey")
+	err := m.store.DeleteAPIKey(ctx, keyID)
+	done(err)
+
+	return err
+}
@@ -0,0 +1,182 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// poolStore is a store backed by a connection pool, which only implements the pool statistics
+type poolStore struct {
+	LibraryStore
+	stats sql.DBStats
+}
+
+func (s *poolStore) Stats() sql.DBStats {
+	return s.stats
+}
+
+func getMetrics(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestHandleGetMetrics(t *testing.T) {
+	t.Run("Records Requests", func(t *testing.T) {
+		store := datastore.NewMemoryStore()
+		book, err := store.CreateBook(context.Background(), &types.Book{Title: testTitle, Available: true})
+		require.NoError(t, err)
+
+		s, err := NewServer(store, &Config{ServerAddr: ":0"})
+		require.NoError(t, err)
+
+		testServer := httptest.NewServer(s.Handler())
+		defer testServer.Close()
+
+		for _, email := range []string{"first@example.com", "second@example.com"} {
+			resp, err := http.Post(testServer.URL+"/request", "application/json",
+				strings.NewReader(`{"title": "`+testTitle+`", "email": "`+email+`"}`))
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		for _, path := range []string{fmt.Sprintf("/book/%d", book.ID), "/book/999", "/missing"} {
+			resp, err := http.Get(testServer.URL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		for _, transition := range []string{"checkout", "return"} {
+			resp, err := http.Post(testServer.URL+"/request/1/"+transition, "application/json", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		// Deleting a checked out request closes it as returned
+		resp, err := http.Post(testServer.URL+"/request/2/checkout", "application/json", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		req, err := http.NewRequest(http.MethodDelete, testServer.URL+"/request/2", nil)
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body := getMetrics(t, testServer.URL+"/metrics")
+
+		assert.Contains(t, body, `givedirectly_http_requests_total{method="POST",route="/request",code="200"} 1`)
+		assert.Contains(t, body, `givedirectly_http_requests_total{method="POST",route="/request",code="202"} 1`)
+		assert.Contains(t, body, `givedirectly_http_requests_total{method="GET",route="/book/{id}",code="200"} 1`)
+		assert.Contains(t, body, `givedirectly_http_requests_total{method="GET",route="/book/{id}",code="404"} 1`)
+		assert.Contains(t, body, `givedirectly_http_requests_total{method="GET",route="unmatched",code="404"} 1`)
+		assert.Contains(t, body, `givedirectly_http_request_duration_seconds_count{method="GET",route="/book/{id}"} 2`)
+		assert.NotContains(t, body, `route="/book/1"`, "Should label requests with the route template.")
+
+		assert.Contains(t, body, `givedirectly_datastore_duration_seconds_count{operation="CreateRequest"} 2`)
+		assert.NotContains(t, body, `givedirectly_datastore_errors_total{operation="GetBook"}`, "Should not count a book that is not found.")
+
+		assert.Contains(t, body, "givedirectly_requests_created_total 2\n")
+		assert.Contains(t, body, "givedirectly_requests_unavailable_total 1\n")
+		assert.Contains(t, body, "givedirectly_requests_returned_total 2\n")
+	})
+
+	t.Run("Counts Unexpected Store Errors", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), 1).Return(nil, errors.New("connection refused")).Times(1)
+
+		s, err := NewServer(mockLibraryStore, &Config{ServerAddr: ":0"})
+		require.NoError(t, err)
+
+		testServer := httptest.NewServer(s.Handler())
+		defer testServer.Close()
+
+		resp, err := http.Get(testServer.URL + "/book/1")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		body := getMetrics(t, testServer.URL+"/metrics")
+		assert.Contains(t, body, `givedirectly_datastore_errors_total{operation="GetBook"} 1`)
+	})
+
+	t.Run("Pool Statistics", func(t *testing.T) {
+		store := &poolStore{stats: sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4}}
+
+		s, err := NewServer(store, &Config{ServerAddr: ":0"})
+		require.NoError(t, err)
+
+		testServer := httptest.NewServer(s.Handler())
+		defer testServer.Close()
+
+		body := getMetrics(t, testServer.URL+"/metrics")
+
+		assert.Contains(t, body, "givedirectly_db_max_open_connections 10\n")
+		assert.Contains(t, body, "givedirectly_db_open_connections 3\n")
+		assert.Contains(t, body, "givedirectly_db_in_use_connections 1\n")
+		assert.Contains(t, body, "givedirectly_db_idle_connections 2\n")
+		assert.Contains(t, body, "givedirectly_db_wait_count_total 4\n")
+	})
+
+	t.Run("Public With Auth", func(t *testing.T) {
+		s, err := NewServer(datastore.NewMemoryStore(), &Config{ServerAddr: ":0", EnableAuth: true})
+		require.NoError(t, err)
+
+		testServer := httptest.NewServer(s.Handler())
+		defer testServer.Close()
+
+		assert.Contains(t, getMetrics(t, testServer.URL+"/metrics"), "givedirectly_http_requests_total")
+	})
+
+	t.Run("Admin Address", func(t *testing.T) {
+		addr, adminAddr := freeAddr(t), freeAddr(t)
+		s, err := NewServer(datastore.NewMemoryStore(), &Config{ServerAddr: addr, AdminAddr: adminAddr})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- s.Run(ctx)
+		}()
+		waitForServer(t, addr)
+		waitForServer(t, adminAddr)
+
+		resp, err := http.Get("http://" + addr + "/metrics")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should not serve metrics on the API address.")
+
+		assert.Contains(t, getMetrics(t, "http://"+adminAddr+"/metrics"), `route="unmatched",code="404"} 1`)
+
+		cancel()
+		require.NoError(t, <-runErr)
+	})
+}
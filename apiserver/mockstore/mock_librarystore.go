@@ -7,8 +7,10 @@ package mockstore
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	datastore "github.com/samkreter/givedirectly/datastore"
 	types "github.com/samkreter/givedirectly/types"
 )
 
@@ -35,6 +37,81 @@ func (m *MockLibraryStore) EXPECT() *MockLibraryStoreMockRecorder {
 	return m.recorder
 }
 
+// AddCopy mocks base method.
+func (m *MockLibraryStore) AddCopy(arg0 context.Context, arg1 int, arg2 *types.Copy) (*types.Copy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddCopy", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*types.Copy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddCopy indicates an expected call of AddCopy.
+func (mr *MockLibraryStoreMockRecorder) AddCopy(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCopy", reflect.TypeOf((*MockLibraryStore)(nil).AddCopy), arg0, arg1, arg2)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockLibraryStore) CreateAPIKey(arg0 context.Context, arg1 *types.APIKey) (*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockLibraryStoreMockRecorder) CreateAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockLibraryStore)(nil).CreateAPIKey), arg0, arg1)
+}
+
+// CreateBook mocks base method.
+func (m *MockLibraryStore) CreateBook(arg0 context.Context, arg1 *types.Book) (*types.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBook", arg0, arg1)
+	ret0, _ := ret[0].(*types.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateBook indicates an expected call of CreateBook.
+func (mr *MockLibraryStoreMockRecorder) CreateBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*MockLibraryStore)(nil).CreateBook), arg0, arg1)
+}
+
+// CreateBooks mocks base method.
+func (m *MockLibraryStore) CreateBooks(arg0 context.Context, arg1 []*types.Book) ([]*types.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBooks", arg0, arg1)
+	ret0, _ := ret[0].([]*types.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateBooks indicates an expected call of CreateBooks.
+func (mr *MockLibraryStoreMockRecorder) CreateBooks(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBooks", reflect.TypeOf((*MockLibraryStore)(nil).CreateBooks), arg0, arg1)
+}
+
+// CreatePatron mocks base method.
+func (m *MockLibraryStore) CreatePatron(arg0 context.Context, arg1 *types.Patron) (*types.Patron, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePatron", arg0, arg1)
+	ret0, _ := ret[0].(*types.Patron)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreatePatron indicates an expected call of CreatePatron.
+func (mr *MockLibraryStoreMockRecorder) CreatePatron(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePatron", reflect.TypeOf((*MockLibraryStore)(nil).CreatePatron), arg0, arg1)
+}
+
 // CreateRequest mocks base method.
 func (m *MockLibraryStore) CreateRequest(arg0 context.Context, arg1 *types.Request) (*types.Book, error) {
 	m.ctrl.T.Helper()
@@ -50,20 +127,123 @@ func (mr *MockLibraryStoreMockRecorder) CreateRequest(arg0, arg1 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRequest", reflect.TypeOf((*MockLibraryStore)(nil).CreateRequest), arg0, arg1)
 }
 
-// DeleteRequest mocks base method.
-func (m *MockLibraryStore) DeleteRequest(arg0 context.Context, arg1 int) error {
+// DeleteAPIKey mocks base method.
+func (m *MockLibraryStore) DeleteAPIKey(arg0 context.Context, arg1 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteRequest", arg0, arg1)
+	ret := m.ctrl.Call(m, "DeleteAPIKey", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockLibraryStoreMockRecorder) DeleteAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockLibraryStore)(nil).DeleteAPIKey), arg0, arg1)
+}
+
+// DeleteBook mocks base method.
+func (m *MockLibraryStore) DeleteBook(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBook", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBook indicates an expected call of DeleteBook.
+func (mr *MockLibraryStoreMockRecorder) DeleteBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockLibraryStore)(nil).DeleteBook), arg0, arg1)
+}
+
+// DeleteCopy mocks base method.
+func (m *MockLibraryStore) DeleteCopy(arg0 context.Context, arg1, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCopy", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCopy indicates an expected call of DeleteCopy.
+func (mr *MockLibraryStoreMockRecorder) DeleteCopy(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCopy", reflect.TypeOf((*MockLibraryStore)(nil).DeleteCopy), arg0, arg1, arg2)
+}
+
+// DeleteRequest mocks base method.
+func (m *MockLibraryStore) DeleteRequest(arg0 context.Context, arg1 int) (*types.Request, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRequest", arg0, arg1)
+	ret0, _ := ret[0].(*types.Request)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // DeleteRequest indicates an expected call of DeleteRequest.
 func (mr *MockLibraryStoreMockRecorder) DeleteRequest(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRequest", reflect.TypeOf((*MockLibraryStore)(nil).DeleteRequest), arg0, arg1)
 }
 
+// GetAPIKeyByHash mocks base method.
+func (m *MockLibraryStore) GetAPIKeyByHash(arg0 context.Context, arg1 string) (*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", arg0, arg1)
+	ret0, _ := ret[0].(*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockLibraryStoreMockRecorder) GetAPIKeyByHash(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockLibraryStore)(nil).GetAPIKeyByHash), arg0, arg1)
+}
+
+// GetBook mocks base method.
+func (m *MockLibraryStore) GetBook(arg0 context.Context, arg1 int) (*types.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBook", arg0, arg1)
+	ret0, _ := ret[0].(*types.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBook indicates an expected call of GetBook.
+func (mr *MockLibraryStoreMockRecorder) GetBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBook", reflect.TypeOf((*MockLibraryStore)(nil).GetBook), arg0, arg1)
+}
+
+// GetPatron mocks base method.
+func (m *MockLibraryStore) GetPatron(arg0 context.Context, arg1 int) (*types.Patron, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPatron", arg0, arg1)
+	ret0, _ := ret[0].(*types.Patron)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPatron indicates an expected call of GetPatron.
+func (mr *MockLibraryStoreMockRecorder) GetPatron(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPatron", reflect.TypeOf((*MockLibraryStore)(nil).GetPatron), arg0, arg1)
+}
+
+// GetQueuePosition mocks base method.
+func (m *MockLibraryStore) GetQueuePosition(arg0 context.Context, arg1 int) (*types.QueuePosition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetQueuePosition", arg0, arg1)
+	ret0, _ := ret[0].(*types.QueuePosition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetQueuePosition indicates an expected call of GetQueuePosition.
+func (mr *MockLibraryStoreMockRecorder) GetQueuePosition(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQueuePosition", reflect.TypeOf((*MockLibraryStore)(nil).GetQueuePosition), arg0, arg1)
+}
+
 // GetRequest mocks base method.
 func (m *MockLibraryStore) GetRequest(arg0 context.Context, arg1 int) (*types.Request, error) {
 	m.ctrl.T.Helper()
@@ -79,17 +259,152 @@ func (mr *MockLibraryStoreMockRecorder) GetRequest(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequest", reflect.TypeOf((*MockLibraryStore)(nil).GetRequest), arg0, arg1)
 }
 
-// ListRequest mocks base method.
-func (m *MockLibraryStore) ListRequest(arg0 context.Context) ([]*types.Request, error) {
+// ListAPIKeys mocks base method.
+func (m *MockLibraryStore) ListAPIKeys(arg0 context.Context) ([]*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAPIKeys", arg0)
+	ret0, _ := ret[0].([]*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAPIKeys indicates an expected call of ListAPIKeys.
+func (mr *MockLibraryStoreMockRecorder) ListAPIKeys(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAPIKeys", reflect.TypeOf((*MockLibraryStore)(nil).ListAPIKeys), arg0)
+}
+
+// ListBook mocks base method.
+func (m *MockLibraryStore) ListBook(arg0 context.Context, arg1 *datastore.BookQuery) (*datastore.BookPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBook", arg0, arg1)
+	ret0, _ := ret[0].(*datastore.BookPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBook indicates an expected call of ListBook.
+func (mr *MockLibraryStoreMockRecorder) ListBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBook", reflect.TypeOf((*MockLibraryStore)(nil).ListBook), arg0, arg1)
+}
+
+// ListOverdueRequests mocks base method.
+func (m *MockLibraryStore) ListOverdueRequests(arg0 context.Context, arg1 time.Time) ([]*types.Request, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListOverdueRequests", arg0, arg1)
+	ret0, _ := ret[0].([]*types.Request)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListOverdueRequests indicates an expected call of ListOverdueRequests.
+func (mr *MockLibraryStoreMockRecorder) ListOverdueRequests(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListOverdueRequests", reflect.TypeOf((*MockLibraryStore)(nil).ListOverdueRequests), arg0, arg1)
+}
+
+// ListPatron mocks base method.
+func (m *MockLibraryStore) ListPatron(arg0 context.Context) ([]*types.Patron, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPatron", arg0)
+	ret0, _ := ret[0].([]*types.Patron)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPatron indicates an expected call of ListPatron.
+func (mr *MockLibraryStoreMockRecorder) ListPatron(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPatron", reflect.TypeOf((*MockLibraryStore)(nil).ListPatron), arg0)
+}
+
+// ListPatronRequests mocks base method.
+func (m *MockLibraryStore) ListPatronRequests(arg0 context.Context, arg1 int) ([]*types.Request, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListRequest", arg0)
+	ret := m.ctrl.Call(m, "ListPatronRequests", arg0, arg1)
 	ret0, _ := ret[0].([]*types.Request)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
+// ListPatronRequests indicates an expected call of ListPatronRequests.
+func (mr *MockLibraryStoreMockRecorder) ListPatronRequests(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPatronRequests", reflect.TypeOf((*MockLibraryStore)(nil).ListPatronRequests), arg0, arg1)
+}
+
+// ListRequest mocks base method.
+func (m *MockLibraryStore) ListRequest(arg0 context.Context, arg1 *datastore.RequestQuery) (*datastore.RequestPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRequest", arg0, arg1)
+	ret0, _ := ret[0].(*datastore.RequestPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // ListRequest indicates an expected call of ListRequest.
-func (mr *MockLibraryStoreMockRecorder) ListRequest(arg0 interface{}) *gomock.Call {
+func (mr *MockLibraryStoreMockRecorder) ListRequest(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRequest", reflect.TypeOf((*MockLibraryStore)(nil).ListRequest), arg0, arg1)
+}
+
+// RenewRequest mocks base method.
+func (m *MockLibraryStore) RenewRequest(arg0 context.Context, arg1 int) (*types.Request, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenewRequest", arg0, arg1)
+	ret0, _ := ret[0].(*types.Request)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RenewRequest indicates an expected call of RenewRequest.
+func (mr *MockLibraryStoreMockRecorder) RenewRequest(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenewRequest", reflect.TypeOf((*MockLibraryStore)(nil).RenewRequest), arg0, arg1)
+}
+
+// UpdateBook mocks base method.
+func (m *MockLibraryStore) UpdateBook(arg0 context.Context, arg1 *types.Book) (*types.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBook", arg0, arg1)
+	ret0, _ := ret[0].(*types.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateBook indicates an expected call of UpdateBook.
+func (mr *MockLibraryStoreMockRecorder) UpdateBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockLibraryStore)(nil).UpdateBook), arg0, arg1)
+}
+
+// UpdatePatron mocks base method.
+func (m *MockLibraryStore) UpdatePatron(arg0 context.Context, arg1 *types.Patron) (*types.Patron, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePatron", arg0, arg1)
+	ret0, _ := ret[0].(*types.Patron)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdatePatron indicates an expected call of UpdatePatron.
+func (mr *MockLibraryStoreMockRecorder) UpdatePatron(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePatron", reflect.TypeOf((*MockLibraryStore)(nil).UpdatePatron), arg0, arg1)
+}
+
+// UpdateRequestStatus mocks base method.
+func (m *MockLibraryStore) UpdateRequestStatus(arg0 context.Context, arg1 int, arg2 types.RequestStatus) (*types.Request, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRequestStatus", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*types.Request)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateRequestStatus indicates an expected call of UpdateRequestStatus.
+func (mr *MockLibraryStoreMockRecorder) UpdateRequestStatus(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRequest", reflect.TypeOf((*MockLibraryStore)(nil).ListRequest), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRequestStatus", reflect.TypeOf((*MockLibraryStore)(nil).UpdateRequestStatus), arg0, arg1, arg2)
 }
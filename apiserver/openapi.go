@@ -0,0 +1,393 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// operation documents a route in the OpenAPI specification. Every route must have one, which is
+// checked by the tests.
+type operation struct {
+	id      string
+	summary string
+	// paged listings take the limit, cursor and sort parameters and link to their next page
+	paged bool
+	// query are the names of the queryParams the route takes
+	query []string
+	// body is a value of the type of the request body, nil if there is none
+	body         interface{}
+	optionalBody bool
+	// bodyTypes are the media types of a body that is a file rather than JSON
+	bodyTypes []string
+	// responses are the successful responses, errors are always problem details
+	responses []response
+}
+
+// response is a successful response of an operation
+type response struct {
+	status int
+	// body is a value of the type of the response body, nil if there is none
+	body interface{}
+}
+
+// operations documents the routes, keyed by the method and path of the route
+var operations = map[string]operation{
+	"GET /healthz": {id: "getLiveness", summary: "Check the apiserver is alive, without checking its dependencies",
+		responses: []response{{http.StatusOK, types.Health{}}}},
+	"GET /readyz": {id: "getReadiness", summary: "Check the apiserver and its dependencies can serve requests, returning 503 if not",
+		responses: []response{{http.StatusOK, types.Health{}}, {http.StatusServiceUnavailable, types.Health{}}}},
+	"GET /openapi.json": {id: "getOpenAPI", summary: "Get this OpenAPI specification",
+		responses: []response{{http.StatusOK, nil}}},
+	"GET /metrics": {id: "getMetrics", summary: "Get the metrics in the Prometheus text format, unless they are served on the admin address",
+		responses: []response{{http.StatusOK, nil}}},
+
+	"POST /request": {id: "createRequest", summary: "Request a book for a patron, given by ID or email. Returns the book and whether a copy was free, if not the request joins the waitlist and 202 is returned.",
+		body: types.Request{}, responses: []response{{http.StatusOK, types.Book{}}, {http.StatusAccepted, types.Book{}}}},
+	"GET /request": {id: "listRequests", summary: "List requests", paged: true,
+		query:     []string{"email", "title", "status", "createdAfter", "createdBefore"},
+		responses: []response{{http.StatusOK, []types.Request{}}}},
+	"GET /request/overdue": {id: "listOverdueRequests", summary: "List requests holding a copy, requested or checked out, that are past their due date",
+		query: []string{"asOf"}, responses: []response{{http.StatusOK, []types.Request{}}}},
+	"GET /request/{id}": {id: "getRequest", summary: "Get a request",
+		responses: []response{{http.StatusOK, types.Request{}}}},
+	"DELETE /request/{id}": {id: "deleteRequest", summary: "Delete a request",
+		responses: []response{{http.StatusOK, nil}}},
+	"GET /request/{id}/position": {id: "getQueuePosition", summary: "Get the position of a request in the waitlist of its book",
+		responses: []response{{http.StatusOK, types.QueuePosition{}}}},
+	"POST /request/{id}/checkout": {id: "checkoutRequest", summary: "Check out the copy reserved for a request",
+		responses: []response{{http.StatusOK, types.Request{}}}},
+	"POST /request/{id}/return": {id: "returnRequest", summary: "Return the copy lent to a request",
+		responses: []response{{http.StatusOK, types.Request{}}}},
+	"POST /request/{id}/cancel": {id: "cancelRequest", summary: "Cancel a request before it is checked out",
+		responses: []response{{http.StatusOK, types.Request{}}}},
+	"POST /request/{id}/renew": {id: "renewRequest", summary: "Extend the due date of a request holding a copy, requested or checked out",
+		responses: []response{{http.StatusOK, types.Request{}}}},
+
+	"POST /book": {id: "createBook", summary: "Add a book to the catalog",
+		body: types.Book{}, responses: []response{{http.StatusCreated, types.Book{}}}},
+	"GET /book": {id: "listBooks", summary: "List books", paged: true,
+		query:     []string{"title", "available"},
+		responses: []response{{http.StatusOK, []types.Book{}}}},
+	"POST /book/import": {id: "importBooks", summary: "Add the books in a CSV or JSON Lines file to the catalog, skipping titles and ISBNs already in it. Invalid rows are reported rather than failing the import.",
+		query: []string{"format"}, bodyTypes: []string{"text/csv", "application/x-ndjson"},
+		responses: []response{{http.StatusOK, types.ImportReport{}}}},
+	"GET /book/{id}": {id: "getBook", summary: "Get a book and its copies",
+		responses: []response{{http.StatusOK, types.Book{}}}},
+	"PUT /book/{id}": {id: "updateBook", summary: "Update a book",
+		body: types.Book{}, responses: []response{{http.StatusOK, types.Book{}}}},
+	"DELETE /book/{id}": {id: "deleteBook", summary: "Delete a book without open requests",
+		responses: []response{{http.StatusOK, nil}}},
+	"POST /book/{id}/copies": {id: "addCopy", summary: "Add a copy of a book, which is available unless the optional body says otherwise",
+		body: types.Copy{}, optionalBody: true, responses: []response{{http.StatusCreated, types.Copy{}}}},
+	"DELETE /book/{id}/copies/{copyID}": {id: "deleteCopy", summary: "Delete a copy that is not lent out",
+		responses: []response{{http.StatusOK, nil}}},
+
+	"POST /patron": {id: "createPatron", summary: "Register a patron",
+		body: types.Patron{}, responses: []response{{http.StatusCreated, types.Patron{}}}},
+	"GET /patron": {id: "listPatrons", summary: "List patrons",
+		responses: []response{{http.StatusOK, []types.Patron{}}}},
+	"GET /patron/{id}": {id: "getPatron", summary: "Get a patron",
+		responses: []response{{http.StatusOK, types.Patron{}}}},
+	"PUT /patron/{id}": {id: "updatePatron", summary: "Update a patron",
+		body: types.Patron{}, responses: []response{{http.StatusOK, types.Patron{}}}},
+	"GET /patron/{id}/requests": {id: "listPatronRequests", summary: "List the requests of a patron",
+		responses: []response{{http.StatusOK, []types.Request{}}}},
+
+	"GET /apikey": {id: "listAPIKeys", summary: "List API keys",
+		responses: []response{{http.StatusOK, []types.APIKey{}}}},
+	"POST /apikey": {id: "createAPIKey", summary: "Create an API key, which is only returned in this response",
+		body: types.APIKey{}, responses: []response{{http.StatusCreated, types.APIKey{}}}},
+	"DELETE /apikey/{id}": {id: "deleteAPIKey", summary: "Revoke an API key",
+		responses: []response{{http.StatusOK, nil}}},
+	"POST /auth/token": {id: "createToken", summary: "Exchange the API key the request is authenticated with for a bearer token",
+		responses: []response{{http.StatusCreated, types.Token{}}}},
+}
+
+// pageParams are the query parameters of paged listings
+var pageParams = []string{"limit", "cursor", "sort"}
+
+// queryParams are the query parameters routes can take
+var queryParams = map[string]map[string]interface{}{
+	"limit":         {"description": "The most results to return, at most 500", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 500, "default": 50}},
+	"cursor":        {"description": "The cursor of the next page, from the Link header of the previous page", "schema": map[string]interface{}{"type": "string"}},
+	"sort":          {"description": "The field to sort by, prefixed with '-' for descending order", "schema": map[string]interface{}{"type": "string"}},
+	"email":         {"description": "Only requests made by the patron with the email", "schema": map[string]interface{}{"type": "string"}},
+	"title":         {"description": "Only titles containing the value, ignoring case", "schema": map[string]interface{}{"type": "string"}},
+	"status":        {"description": "Only requests in any of the statuses, repeated or comma separated", "schema": map[string]interface{}{"type": "array", "items": schemaRef("RequestStatus")}},
+	"createdAfter":  {"description": "Only requests created at or after the time", "schema": map[string]interface{}{"type": "string", "format": "date-time"}},
+	"createdBefore": {"description": "Only requests created before the time", "schema": map[string]interface{}{"type": "string", "format": "date-time"}},
+	"available":     {"description": "Only books with, or without, a free copy", "schema": map[string]interface{}{"type": "boolean"}},
+	"format":        {"description": "The format of the file, csv or jsonl, instead of its Content-Type", "schema": map[string]interface{}{"type": "string", "enum": []string{"csv", "jsonl"}}},
+	"asOf":          {"description": "The time to check due dates against, now if not given", "schema": map[string]interface{}{"type": "string", "format": "date-time"}},
+}
+
+// enums are the values of the string types with a fixed set of values
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(types.RequestStatus("")): {
+		string(types.RequestStatusWaiting),
+		string(types.RequestStatusRequested),
+		string(types.RequestStatusCheckedOut),
+		string(types.RequestStatusReturned),
+		string(types.RequestStatusCancelled),
+	},
+	reflect.TypeOf(types.ErrorCode("")): {
+		string(types.ErrorCodeInvalidRequest),
+		string(types.ErrorCodeValidationFailed),
+		string(types.ErrorCodeInvalidQuery),
+		string(types.ErrorCodeUnauthorized),
+		string(types.ErrorCodeForbidden),
+		string(types.ErrorCodeNotFound),
+		string(types.ErrorCodeMethodNotAllowed),
+		string(types.ErrorCodeAlreadyExists),
+		string(types.ErrorCodeInvalidTransition),
+		string(types.ErrorCodeInUse),
+		string(types.ErrorCodeRenewalLimit),
+		string(types.ErrorCodeHoldQueue),
+		string(types.ErrorCodeUnknownPatron),
+		string(types.ErrorCodeLimitExceeded),
+		string(types.ErrorCodeRestricted),
+		string(types.ErrorCodeTooLarge),
+		string(types.ErrorCodeInternal),
+	},
+}
+
+// pathParamPattern matches the variables of a route path
+var pathParamPattern = regexp.MustCompile(`{([^}]+)}`)
+
+// openAPISpec returns the OpenAPI 3 specification of the routes the server registers. The schemas are
+// generated from the types package so they always match what the API sends.
+func (s *Server) openAPISpec() map[string]interface{} {
+	schemas := schemaSet{}
+	schemas.add(reflect.TypeOf(types.Problem{}))
+	// Referred to by the status query parameter
+	schemas.add(reflect.TypeOf(types.RequestStatus("")))
+
+	paths := map[string]interface{}{}
+	for _, r := range s.routes() {
+		op, ok := operations[r.method+" "+r.path]
+		if !ok {
+			continue
+		}
+
+		item, ok := paths[r.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[r.path] = item
+		}
+
+		item[strings.ToLower(r.method)] = s.openAPIOperation(r, op, schemas)
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "GiveDirectly Library API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+
+	if s.config.EnableAuth {
+		spec["security"] = []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearerToken": []string{}},
+		}
+		spec["components"].(map[string]interface{})["securitySchemes"] = map[string]interface{}{
+			"apiKey":      map[string]interface{}{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			"bearerToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+		}
+	}
+
+	return spec
+}
+
+// openAPIOperation returns the OpenAPI operation object of the route
+func (s *Server) openAPIOperation(r route, op operation, schemas schemaSet) map[string]interface{} {
+	var params []interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(r.path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "integer"},
+		})
+	}
+
+	query := op.query
+	if op.paged {
+		query = append(append([]string{}, pageParams...), query...)
+	}
+
+	for _, name := range query {
+		param := map[string]interface{}{"name": name, "in": "query"}
+		for k, v := range queryParams[name] {
+			param[k] = v
+		}
+		params = append(params, param)
+	}
+
+	responses := map[string]interface{}{
+		"default": map[string]interface{}{
+			"description": "An error",
+			"content": map[string]interface{}{
+				problemContentType: map[string]interface{}{"schema": schemaRef("Problem")},
+			},
+		},
+	}
+
+	for _, resp := range op.responses {
+		description := http.StatusText(resp.status)
+		if op.paged {
+			description += ". The Link header has the URL of the next page, unless this is the last page."
+		}
+
+		spec := map[string]interface{}{"description": description}
+		if resp.body != nil {
+			spec["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.add(reflect.TypeOf(resp.body))},
+			}
+		}
+
+		responses[strconv.Itoa(resp.status)] = spec
+	}
+
+	spec := map[string]interface{}{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"responses":   responses,
+	}
+
+	if len(params) > 0 {
+		spec["parameters"] = params
+	}
+
+	if op.body != nil {
+		spec["requestBody"] = map[string]interface{}{
+			"required": !op.optionalBody,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.add(reflect.TypeOf(op.body))},
+			},
+		}
+	}
+
+	if len(op.bodyTypes) > 0 {
+		content := map[string]interface{}{}
+		for _, mediaType := range op.bodyTypes {
+			content[mediaType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		spec["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+
+	if r.access.public && s.config.EnableAuth {
+		spec["security"] = []interface{}{}
+	}
+
+	return spec
+}
+
+// schemaSet are the component schemas of the specification, keyed by the name of their type
+type schemaSet map[string]interface{}
+
+// add returns the schema of the type, adding the schemas of the named types it uses to the set
+func (set schemaSet) add(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	if values, ok := enums[t]; ok {
+		if _, ok := set[t.Name()]; !ok {
+			set[t.Name()] = map[string]interface{}{"type": "string", "enum": values}
+		}
+		return schemaRef(t.Name())
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return set.add(t.Elem())
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": set.add(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": set.add(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Struct:
+		if _, ok := set[t.Name()]; !ok {
+			// Added before the fields so types that refer to themselves don't recurse forever
+			set[t.Name()] = nil
+			set[t.Name()] = set.structSchema(t)
+		}
+		return schemaRef(t.Name())
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// structSchema returns the schema of the JSON encoding of the struct. Fields without omitempty are
+// always sent, so they are required.
+func (set schemaSet) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, opts := field.Name, ""
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+
+			name, opts = tag, ""
+			if i := strings.Index(tag, ","); i >= 0 {
+				name, opts = tag[:i], tag[i:]
+			}
+		}
+
+		properties[name] = set.add(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	sort.Strings(required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (s *Server) handleGetOpenAPI(w http.ResponseWriter, req *http.Request) {
+	logger := log.G(req.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.openAPISpec()); err != nil {
+		logger.Errorf("handleGetOpenAPI: %v", err)
+		return
+	}
+}
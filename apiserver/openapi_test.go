@@ -0,0 +1,102 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// specFile is the committed specification clients are generated from. Regenerate it after changing
+// the routes or types with: go test ./apiserver -run TestOpenAPISpec -update
+const specFile = "openapi.json"
+
+var update = flag.Bool("update", false, "update the committed OpenAPI specification")
+
+func TestOpenAPISpec(t *testing.T) {
+	// Every optional route is registered with auth and tokens enabled
+	s := Server{
+		config: &Config{EnableAuth: true, TokenSecret: testTokenSecret},
+	}
+
+	t.Run("Documents Every Route", func(t *testing.T) {
+		registered := map[string]bool{}
+		for _, r := range s.routes() {
+			key := r.method + " " + r.path
+			registered[key] = true
+
+			assert.Contains(t, operations, key, "Route '%s' should be documented in operations.", key)
+		}
+
+		for key := range operations {
+			assert.True(t, registered[key], "Operation '%s' should be a registered route.", key)
+		}
+	})
+
+	t.Run("Unique Operation IDs", func(t *testing.T) {
+		ids := map[string]string{}
+		for key, op := range operations {
+			if other, ok := ids[op.id]; ok {
+				t.Errorf("Operations '%s' and '%s' have the same ID '%s'.", key, other, op.id)
+			}
+			ids[op.id] = key
+		}
+	})
+
+	t.Run("Known Query Parameters", func(t *testing.T) {
+		for key, op := range operations {
+			for _, name := range op.query {
+				assert.Contains(t, queryParams, name, "Operation '%s' should only take known query parameters.", key)
+			}
+		}
+	})
+
+	t.Run("Matches Committed Spec", func(t *testing.T) {
+		generated, err := json.MarshalIndent(s.openAPISpec(), "", "  ")
+		require.NoError(t, err)
+		generated = append(generated, '\n')
+
+		if *update {
+			require.NoError(t, ioutil.WriteFile(specFile, generated, 0644))
+		}
+
+		committed, err := ioutil.ReadFile(specFile)
+		require.NoError(t, err)
+
+		assert.True(t, bytes.Equal(committed, generated),
+			"The routes or types have changed, regenerate %s with: go test ./apiserver -run TestOpenAPISpec -update", specFile)
+	})
+}
+
+func TestHandleGetOpenAPI(t *testing.T) {
+	t.Run("Public With Auth", func(t *testing.T) {
+		s := Server{
+			config: &Config{EnableAuth: true},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp, err := http.Get(testServer.URL + "/openapi.json")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should not need credentials.")
+
+		defer resp.Body.Close()
+		var spec struct {
+			OpenAPI string                            `json:"openapi"`
+			Paths   map[string]map[string]interface{} `json:"paths"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&spec))
+
+		assert.Equal(t, "3.0.3", spec.OpenAPI)
+		assert.Contains(t, spec.Paths["/request/{id}"], "get")
+		assert.Contains(t, spec.Paths, "/apikey")
+		assert.NotContains(t, spec.Paths, "/auth/token", "Should only document registered routes.")
+	})
+}
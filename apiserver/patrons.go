@@ -0,0 +1,156 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/badoux/checkmail"
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+func (s *Server) handlePostPatron(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	var patron *types.Patron
+	if err := json.NewDecoder(req.Body).Decode(&patron); err != nil || patron == nil {
+		writeError(w, req, badRequest("request body must be a JSON patron"))
+		return
+	}
+
+	if err := checkmail.ValidateFormat(strings.TrimSpace(patron.Email)); err != nil {
+		writeError(w, req, invalidFields(types.FieldError{Field: "email", Message: "invalid email address"}))
+		return
+	}
+
+	created, err := s.store.CreatePatron(ctx, patron)
+	if err != nil {
+		writeError(w, req, storeError(err, "patron"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		logger.Errorf("handlePostPatron: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleListPatron(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	patrons, err := s.store.ListPatron(ctx)
+	if err != nil {
+		writeError(w, req, storeError(err, "patron"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(patrons); err != nil {
+		logger.Errorf("handleListPatron: %v", err)
+		return
+	}
+}
+
+func (s *Server) handleGetPatron(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	patronID, ok := parsePatronID(w, req)
+	if !ok {
+		return
+	}
+
+	patron, err := s.store.GetPatron(ctx, patronID)
+	if err != nil {
+		writeError(w, req, storeError(err, "patron"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(patron); err != nil {
+		logger.Errorf("handleGetPatron: %v", err)
+		return
+	}
+}
+
+func (s *Server) handlePutPatron(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	patronID, ok := parsePatronID(w, req)
+	if !ok {
+		return
+	}
+
+	var patron *types.Patron
+	if err := json.NewDecoder(req.Body).Decode(&patron); err != nil || patron == nil {
+		writeError(w, req, badRequest("request body must be a JSON patron"))
+		return
+	}
+
+	if err := checkmail.ValidateFormat(strings.TrimSpace(patron.Email)); err != nil {
+		writeError(w, req, invalidFields(types.FieldError{Field: "email", Message: "invalid email address"}))
+		return
+	}
+
+	// The ID in the path is the patron being updated
+	patron.ID = patronID
+
+	updated, err := s.store.UpdatePatron(ctx, patron)
+	if err != nil {
+		writeError(w, req, storeError(err, "patron"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		logger.Errorf("handlePutPatron: %v", err)
+		return
+	}
+}
+
+// handleListPatronRequests lists the patron's current requests
+func (s *Server) handleListPatronRequests(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.G(ctx)
+
+	patronID, ok := parsePatronID(w, req)
+	if !ok {
+		return
+	}
+
+	requests, err := s.store.ListPatronRequests(ctx, patronID)
+	if err != nil {
+		writeError(w, req, storeError(err, "patron"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		logger.Errorf("handleListPatronRequests: %v", err)
+		return
+	}
+}
+
+// parsePatronID reads the patron ID from the route variables. If it is invalid a bad request is
+// written to the response and false is returned.
+func parsePatronID(w http.ResponseWriter, req *http.Request) (int, bool) {
+	patronID, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, req, badRequest("invalid patron id"))
+		return 0, false
+	}
+
+	return patronID, true
+}
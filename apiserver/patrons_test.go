@@ -0,0 +1,297 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestHandlePostPatron(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		patron := &types.Patron{
+			Email: "Test@gmail.com",
+			Name:  "Test Patron",
+		}
+
+		mockLibraryStore.EXPECT().CreatePatron(gomock.Any(), patron).
+			Return(&types.Patron{
+				ID:    1,
+				Email: "test@gmail.com",
+				Name:  "Test Patron",
+			}, nil).Times(1)
+
+		b, err := json.Marshal(patron)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/patron", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Should be created status code.")
+
+		defer resp.Body.Close()
+		var retPatron types.Patron
+		err = json.NewDecoder(resp.Body).Decode(&retPatron)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, retPatron.ID, "Should return the created patron.")
+	})
+
+	t.Run("Invalid Email", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		b, err := json.Marshal(types.Patron{Email: "testemail"})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/patron", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Should be badrequest status code.")
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().CreatePatron(gomock.Any(), gomock.Any()).
+			Return(nil, datastore.ErrAlreadyExists).Times(1)
+
+		b, err := json.Marshal(types.Patron{Email: "test@gmail.com"})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", testServer.URL+"/patron", bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should be conflict status code.")
+	})
+}
+
+func TestHandleGetPatron(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+
+		mockLibraryStore.EXPECT().GetPatron(gomock.Any(), testPatronID).
+			Return(&types.Patron{
+				ID:    testPatronID,
+				Email: "test@gmail.com",
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retPatron types.Patron
+		err = json.NewDecoder(resp.Body).Decode(&retPatron)
+		require.NoError(t, err)
+
+		assert.Equal(t, testPatronID, retPatron.ID, "Should return the patron.")
+	})
+
+	t.Run("Patron Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+
+		mockLibraryStore.EXPECT().GetPatron(gomock.Any(), testPatronID).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no patron.")
+	})
+}
+
+func TestHandlePutPatron(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+		patron := &types.Patron{ID: testPatronID, Email: "new@gmail.com", Name: "New Name"}
+
+		mockLibraryStore.EXPECT().UpdatePatron(gomock.Any(), patron).
+			Return(patron, nil).Times(1)
+
+		// The ID in the body is ignored in favor of the path
+		b, err := json.Marshal(types.Patron{ID: 1, Email: "new@gmail.com", Name: "New Name"})
+		require.NoError(t, err)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+
+		mockLibraryStore.EXPECT().UpdatePatron(gomock.Any(), gomock.Any()).
+			Return(nil, datastore.ErrAlreadyExists).Times(1)
+
+		b, err := json.Marshal(types.Patron{Email: "taken@gmail.com"})
+		require.NoError(t, err)
+
+		url := fmt.Sprintf("%s/%d", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Should be conflict status code.")
+	})
+}
+
+func TestHandleListPatronRequests(t *testing.T) {
+	t.Run("Success Case", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+
+		mockLibraryStore.EXPECT().ListPatronRequests(gomock.Any(), testPatronID).
+			Return([]*types.Request{
+				{ID: 1, PatronID: testPatronID, Email: "test@gmail.com", Title: testTitle, Status: types.RequestStatusRequested},
+				{ID: 2, PatronID: testPatronID, Email: "test@gmail.com", Title: testTitle, Status: types.RequestStatusWaiting},
+			}, nil).Times(1)
+
+		url := fmt.Sprintf("%s/%d/requests", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Should be success status code.")
+
+		defer resp.Body.Close()
+		var retRequests []*types.Request
+		err = json.NewDecoder(resp.Body).Decode(&retRequests)
+		require.NoError(t, err)
+
+		assert.Len(t, retRequests, 2, "Should return the patron's requests.")
+	})
+
+	t.Run("Patron Not Found", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		testPatronID := 123
+
+		mockLibraryStore.EXPECT().ListPatronRequests(gomock.Any(), testPatronID).
+			Return(nil, datastore.ErrNotFound).Times(1)
+
+		url := fmt.Sprintf("%s/%d/requests", testServer.URL+"/patron", testPatronID)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Should return not found for no patron.")
+	})
+}
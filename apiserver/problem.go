@@ -0,0 +1,154 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// problemContentType is the content type of error responses
+const problemContentType = "application/problem+json"
+
+// apiError is an error returned to the caller. It is written as problem details by writeError.
+type apiError struct {
+	status int
+	code   types.ErrorCode
+	detail string
+	fields []types.FieldError
+	// report is what a bulk import did before it failed
+	report *types.ImportReport
+	// err is the cause of the error. It is logged for internal errors and never returned to the caller.
+	err error
+}
+
+func (e *apiError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %v", e.detail, e.err)
+	}
+
+	return e.detail
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// newError returns an error with the status, code and detail
+func newError(status int, code types.ErrorCode, detail string) *apiError {
+	return &apiError{status: status, code: code, detail: detail}
+}
+
+// badRequest returns an error for a malformed body or path parameter
+func badRequest(detail string) *apiError {
+	return newError(http.StatusBadRequest, types.ErrorCodeInvalidRequest, detail)
+}
+
+// invalidFields returns an error for the invalid fields of a request body
+func invalidFields(fields ...types.FieldError) *apiError {
+	err := newError(http.StatusBadRequest, types.ErrorCodeValidationFailed, "the request has invalid fields")
+	err.fields = fields
+	return err
+}
+
+// internalError returns an error for a failure the caller can't do anything about
+func internalError(err error) *apiError {
+	return &apiError{
+		status: http.StatusInternalServerError,
+		code:   types.ErrorCodeInternal,
+		detail: "failed with internal server error",
+		err:    err,
+	}
+}
+
+// storeErrors maps the datastore errors to API errors. It is the only place store errors are given
+// statuses and codes. Details are formatted with the kind of resource the handler was working on, and
+// errors without a detail return the store's message, which explains what was wrong.
+var storeErrors = []struct {
+	err    error
+	status int
+	code   types.ErrorCode
+	detail string
+}{
+	{datastore.ErrNotFound, http.StatusNotFound, types.ErrorCodeNotFound, "%s not found"},
+	{datastore.ErrAlreadyExists, http.StatusConflict, types.ErrorCodeAlreadyExists, "a %s with the same unique fields already exists"},
+	{datastore.ErrInvalidTransition, http.StatusConflict, types.ErrorCodeInvalidTransition, "%s can not move to that status from its current status"},
+	{datastore.ErrInUse, http.StatusConflict, types.ErrorCodeInUse, "%s is held by an open request"},
+	{datastore.ErrRenewalLimit, http.StatusConflict, types.ErrorCodeRenewalLimit, "%s has already been renewed the maximum number of times"},
+	{datastore.ErrHoldQueue, http.StatusConflict, types.ErrorCodeHoldQueue, "%s can not be renewed while others are waiting for the book"},
+	{datastore.ErrUnknownPatron, http.StatusUnprocessableEntity, types.ErrorCodeUnknownPatron, "patron not found"},
+	// Limits may clear once the patron returns books, restrictions will not
+	{datastore.ErrLimitExceeded, http.StatusConflict, types.ErrorCodeLimitExceeded, ""},
+	{datastore.ErrRestricted, http.StatusUnprocessableEntity, types.ErrorCodeRestricted, ""},
+	{datastore.ErrInvalidQuery, http.StatusBadRequest, types.ErrorCodeInvalidQuery, ""},
+}
+
+// storeError returns the API error for an error from the store about the kind of resource. Unknown
+// errors are internal errors.
+func storeError(err error, resource string) *apiError {
+	for _, mapping := range storeErrors {
+		if !errors.Is(err, mapping.err) {
+			continue
+		}
+
+		detail := err.Error()
+		if strings.Contains(mapping.detail, "%s") {
+			detail = fmt.Sprintf(mapping.detail, resource)
+		} else if mapping.detail != "" {
+			detail = mapping.detail
+		}
+
+		return &apiError{status: mapping.status, code: mapping.code, detail: detail, err: err}
+	}
+
+	return internalError(err)
+}
+
+// writeError writes the error as problem details. Errors that are not API errors are internal errors,
+// which are logged rather than returned to the caller.
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	ctx := req.Context()
+
+	var e *apiError
+	if !errors.As(err, &e) {
+		e = internalError(err)
+	}
+
+	if e.status >= http.StatusInternalServerError {
+		log.G(ctx).Errorf("%s %s failed with error: %v", req.Method, req.URL.Path, e.err)
+	}
+
+	problem := &types.Problem{
+		Title:         http.StatusText(e.status),
+		Status:        e.status,
+		Detail:        e.detail,
+		Instance:      req.URL.Path,
+		Code:          e.code,
+		CorrelationID: correlation.GetCorrelationID(ctx),
+		Errors:        e.fields,
+		Report:        e.report,
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(e.status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.G(ctx).Errorf("writeError: %v", err)
+	}
+}
+
+// handleNotFound writes problem details for requests that don't match a route
+func handleNotFound(w http.ResponseWriter, req *http.Request) {
+	writeError(w, req, newError(http.StatusNotFound, types.ErrorCodeNotFound, "no route matches the path"))
+}
+
+// handleMethodNotAllowed writes problem details for requests with a method their route doesn't support
+func handleMethodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	writeError(w, req, newError(http.StatusMethodNotAllowed, types.ErrorCodeMethodNotAllowed, "the route does not support the method"))
+}
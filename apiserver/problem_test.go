@@ -0,0 +1,158 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver/mockstore"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   types.ErrorCode
+		detail string
+	}{
+		{datastore.ErrNotFound, http.StatusNotFound, types.ErrorCodeNotFound, "book not found"},
+		{errors.Wrap(datastore.ErrInUse, "copy 2"), http.StatusConflict, types.ErrorCodeInUse, "book is held by an open request"},
+		{datastore.ErrUnknownPatron, http.StatusUnprocessableEntity, types.ErrorCodeUnknownPatron, "patron not found"},
+		{errors.Wrap(datastore.ErrLimitExceeded, "patron has 5 open requests"), http.StatusConflict, types.ErrorCodeLimitExceeded, "patron has 5 open requests: borrowing limit exceeded"},
+		{errors.New("connection refused"), http.StatusInternalServerError, types.ErrorCodeInternal, "failed with internal server error"},
+	}
+
+	for _, test := range tests {
+		err := storeError(test.err, "book")
+
+		assert.Equal(t, test.status, err.status, "Should map '%v' to the status.", test.err)
+		assert.Equal(t, test.code, err.code, "Should map '%v' to the code.", test.err)
+		assert.Equal(t, test.detail, err.detail, "Should map '%v' to the detail.", test.err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Run("Store Error", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{EnableReqCorrelation: true},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), 3).Return(nil, datastore.ErrNotFound).Times(1)
+
+		req, err := http.NewRequest("GET", testServer.URL+"/book/3", nil)
+		require.NoError(t, err)
+		req.Header.Set("correlation-request-id", "test-correlation-id")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		problem := decodeProblem(t, resp, http.StatusNotFound)
+
+		assert.Equal(t, types.ErrorCodeNotFound, problem.Code)
+		assert.Equal(t, "Not Found", problem.Title)
+		assert.Equal(t, "book not found", problem.Detail)
+		assert.Equal(t, "/book/3", problem.Instance)
+		assert.Equal(t, "test-correlation-id", problem.CorrelationID, "Should return the correlation ID.")
+	})
+
+	t.Run("Invalid Fields", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp, err := http.Post(testServer.URL+"/request", "application/json", bytes.NewReader([]byte(`{"email": "not-an-email"}`)))
+		require.NoError(t, err)
+
+		problem := decodeProblem(t, resp, http.StatusBadRequest)
+
+		assert.Equal(t, types.ErrorCodeValidationFailed, problem.Code)
+		assert.Equal(t, []types.FieldError{
+			{Field: "title", Message: "must supply a title"},
+			{Field: "email", Message: "invalid email address"},
+		}, problem.Errors, "Should return every invalid field.")
+	})
+
+	t.Run("Internal Error", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockLibraryStore := mockstore.NewMockLibraryStore(mockCtrl)
+
+		s := Server{
+			config: &Config{},
+			store:  mockLibraryStore,
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		mockLibraryStore.EXPECT().GetBook(gomock.Any(), 3).Return(nil, errors.New("password authentication failed")).Times(1)
+
+		resp, err := http.Get(testServer.URL + "/book/3")
+		require.NoError(t, err)
+
+		problem := decodeProblem(t, resp, http.StatusInternalServerError)
+
+		assert.Equal(t, types.ErrorCodeInternal, problem.Code)
+		assert.Equal(t, "failed with internal server error", problem.Detail, "Should not return the cause.")
+	})
+
+	t.Run("Unknown Route", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		resp, err := http.Get(testServer.URL + "/shelf")
+		require.NoError(t, err)
+
+		problem := decodeProblem(t, resp, http.StatusNotFound)
+		assert.Equal(t, types.ErrorCodeNotFound, problem.Code)
+	})
+
+	t.Run("Method Not Allowed", func(t *testing.T) {
+		s := Server{
+			config: &Config{},
+		}
+
+		testServer := httptest.NewServer(s.newRouter())
+
+		req, err := http.NewRequest("PATCH", testServer.URL+"/book/3", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		problem := decodeProblem(t, resp, http.StatusMethodNotAllowed)
+		assert.Equal(t, types.ErrorCodeMethodNotAllowed, problem.Code)
+	})
+}
+
+func decodeProblem(t *testing.T, resp *http.Response, status int) *types.Problem {
+	t.Helper()
+
+	assert.Equal(t, status, resp.StatusCode)
+	assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+
+	defer resp.Body.Close()
+	var problem types.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, status, problem.Status)
+
+	return &problem
+}
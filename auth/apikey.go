@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// apiKeyPrefix makes API keys easy to recognize, for example by secret scanners
+const apiKeyPrefix = "gdk_"
+
+// GenerateAPIKey returns a new random API key. Only its hash should be stored.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKey returns the hash of the API key that is stored and looked up. API keys are long and random
+// so a fast hash is enough, unlike passwords.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
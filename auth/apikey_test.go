@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, apiKeyPrefix))
+
+	other, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other, "Should generate random keys.")
+
+	assert.Equal(t, HashAPIKey(key), HashAPIKey(key), "Hashes should be stable.")
+	assert.NotEqual(t, HashAPIKey(key), HashAPIKey(other))
+	assert.NotContains(t, HashAPIKey(key), key)
+}
@@ -0,0 +1,45 @@
+// Package auth authenticates API callers with API keys and HMAC signed bearer tokens
+package auth
+
+import "context"
+
+// Authentication methods a principal can be authenticated with
+const (
+	MethodAPIKey = "api_key"
+	MethodToken  = "token"
+)
+
+// Principal is the authenticated caller of an API request
+type Principal struct {
+	// Subject identifies the caller, such as the API key the call was made with
+	Subject string
+	// PatronID is the patron the caller acts for, if any
+	PatronID int
+	// Method is how the caller was authenticated
+	Method string
+	// Role is what the caller is allowed to do
+	Role Role
+}
+
+// Can returns true if the principal's role is granted the permission
+func (p *Principal) Can(permission Permission) bool {
+	return p.Role.Can(permission)
+}
+
+// Owns returns true if the principal acts for the patron
+func (p *Principal) Owns(patronID int) bool {
+	return p.PatronID != 0 && p.PatronID == patronID
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal carried by ctx, if there is one
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import "github.com/pkg/errors"
+
+// Role is what a principal is allowed to do
+type Role string
+
+// Roles, from least to most privileged
+const (
+	// RolePatron can browse the catalog and manage their own patron record and requests
+	RolePatron Role = "patron"
+	// RoleLibrarian can manage the catalog, all patrons and all requests
+	RoleLibrarian Role = "librarian"
+	// RoleAdmin can do everything a librarian can and manage API keys
+	RoleAdmin Role = "admin"
+)
+
+// Permission is an action on a kind of resource
+type Permission string
+
+// Permissions granted to roles. The "own" permissions only apply to resources belonging to the
+// principal's patron.
+const (
+	PermReadCatalog    Permission = "catalog:read"
+	PermManageCatalog  Permission = "catalog:manage"
+	PermOwnRequests    Permission = "requests:own"
+	PermManageRequests Permission = "requests:manage"
+	PermOwnPatron      Permission = "patrons:own"
+	PermManagePatrons  Permission = "patrons:manage"
+	PermManageKeys     Permission = "keys:manage"
+)
+
+// rolePermissions is the permission matrix
+var rolePermissions = map[Role][]Permission{
+	RolePatron: {
+		PermReadCatalog,
+		PermOwnRequests,
+		PermOwnPatron,
+	},
+	RoleLibrarian: {
+		PermReadCatalog,
+		PermManageCatalog,
+		PermManageRequests,
+		PermManagePatrons,
+	},
+	RoleAdmin: {
+		PermReadCatalog,
+		PermManageCatalog,
+		PermManageRequests,
+		PermManagePatrons,
+		PermManageKeys,
+	},
+}
+
+// ParseRole returns the role with the name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", errors.Errorf("unknown role '%s', must be one of patron, librarian or admin", name)
+	}
+
+	return role, nil
+}
+
+// ParseKeyRole returns the role with the name for an API key bound to the patron. Patron keys must be
+// bound to a patron, since patrons can only act on their own requests.
+func ParseKeyRole(name string, patronID int) (Role, error) {
+	role, err := ParseRole(name)
+	if err != nil {
+		return "", err
+	}
+
+	if role == RolePatron && patronID == 0 {
+		return "", errors.New("patron keys must be bound to a patron")
+	}
+
+	return role, nil
+}
+
+// Can returns true if the role is granted the permission
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
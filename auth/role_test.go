@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRole(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		for _, name := range []string{"patron", "librarian", "admin"} {
+			role, err := ParseRole(name)
+			require.NoError(t, err)
+			assert.Equal(t, Role(name), role)
+		}
+
+		_, err := ParseRole("superuser")
+		assert.Error(t, err)
+
+		_, err = ParseRole("")
+		assert.Error(t, err)
+	})
+
+	t.Run("Parse Key Role", func(t *testing.T) {
+		role, err := ParseKeyRole("patron", 1)
+		require.NoError(t, err)
+		assert.Equal(t, RolePatron, role)
+
+		role, err = ParseKeyRole("librarian", 0)
+		require.NoError(t, err)
+		assert.Equal(t, RoleLibrarian, role)
+
+		_, err = ParseKeyRole("patron", 0)
+		assert.Error(t, err, "Should require patron keys to be bound to a patron.")
+	})
+
+	t.Run("Permissions", func(t *testing.T) {
+		assert.True(t, RolePatron.Can(PermReadCatalog))
+		assert.True(t, RolePatron.Can(PermOwnRequests))
+		assert.False(t, RolePatron.Can(PermManageRequests))
+		assert.False(t, RolePatron.Can(PermManageCatalog))
+
+		assert.True(t, RoleLibrarian.Can(PermManageCatalog))
+		assert.True(t, RoleLibrarian.Can(PermManageRequests))
+		assert.False(t, RoleLibrarian.Can(PermManageKeys))
+
+		assert.True(t, RoleAdmin.Can(PermManageKeys))
+		assert.False(t, Role("superuser").Can(PermReadCatalog))
+	})
+
+	t.Run("Owns", func(t *testing.T) {
+		assert.True(t, (&Principal{PatronID: 1}).Owns(1))
+		assert.False(t, (&Principal{PatronID: 1}).Owns(2))
+		assert.False(t, (&Principal{}).Owns(0))
+	})
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidToken the token is malformed, not signed with the secret or uses an unsupported algorithm
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrTokenExpired the token was valid but has expired
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// tokenHeader is the only JWT header tokens are signed and accepted with
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Claims are the JWT claims carried by a bearer token
+type Claims struct {
+	Subject   string `json:"sub"`
+	PatronID  int    `json:"pid,omitempty"`
+	Role      Role   `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewClaims returns the claims for a token for the principal that is valid for ttl from now
+func NewClaims(principal *Principal, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		Subject:   principal.Subject,
+		PatronID:  principal.PatronID,
+		Role:      principal.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+}
+
+// Principal returns the principal the token was issued to
+func (c *Claims) Principal() *Principal {
+	return &Principal{
+		Subject:  c.Subject,
+		PatronID: c.PatronID,
+		Method:   MethodToken,
+		Role:     c.Role,
+	}
+}
+
+// SignToken returns the claims as a JWT signed with HMAC SHA-256 using the secret
+func SignToken(claims *Claims, secret []byte) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encodeSegment(header) + "." + encodeSegment(payload)
+	return unsigned + "." + encodeSegment(sign(unsigned, secret)), nil
+}
+
+// VerifyToken checks the token was signed with the secret and has not expired at now, and returns its claims.
+// Only HS256 tokens are accepted, whatever algorithm the token claims to use.
+func VerifyToken(token string, secret []byte, now time.Time) (*Claims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, sign(segments[0]+"."+segments[1], secret)) {
+		return nil, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(segments[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(segments[1], claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := ParseRole(string(claims.Role)); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+func sign(unsigned string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
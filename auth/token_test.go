@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestToken(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	principal := &Principal{Subject: "apikey:1", PatronID: 2, Method: MethodAPIKey, Role: RolePatron}
+
+	t.Run("Round Trip", func(t *testing.T) {
+		token, err := SignToken(NewClaims(principal, now, time.Hour), testSecret)
+		require.NoError(t, err)
+
+		claims, err := VerifyToken(token, testSecret, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, &Principal{Subject: "apikey:1", PatronID: 2, Method: MethodToken, Role: RolePatron}, claims.Principal())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, err := SignToken(NewClaims(principal, now, time.Hour), testSecret)
+		require.NoError(t, err)
+
+		_, err = VerifyToken(token, testSecret, now.Add(time.Hour))
+		assert.Equal(t, ErrTokenExpired, err)
+	})
+
+	t.Run("Wrong Secret", func(t *testing.T) {
+		token, err := SignToken(NewClaims(principal, now, time.Hour), []byte("another secret"))
+		require.NoError(t, err)
+
+		_, err = VerifyToken(token, testSecret, now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Tampered Claims", func(t *testing.T) {
+		token, err := SignToken(NewClaims(principal, now, time.Hour), testSecret)
+		require.NoError(t, err)
+
+		segments := strings.Split(token, ".")
+		segments[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"apikey:1","pid":99,"exp":9999999999}`))
+
+		_, err = VerifyToken(strings.Join(segments, "."), testSecret, now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Unknown Role", func(t *testing.T) {
+		claims := NewClaims(principal, now, time.Hour)
+		claims.Role = "superuser"
+
+		token, err := SignToken(claims, testSecret)
+		require.NoError(t, err)
+
+		_, err = VerifyToken(token, testSecret, now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Unsigned Token", func(t *testing.T) {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"apikey:1","exp":9999999999}`))
+
+		_, err := VerifyToken(header+"."+payload+".", testSecret, now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, token := range []string{"", "abc", "a.b", "a.b.c.d", "!!.??.**"} {
+			_, err := VerifyToken(token, testSecret, now)
+			assert.Equal(t, ErrInvalidToken, err, "Should reject '%s'.", token)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/importer"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	importUsage = "usage: givedirectly [flags] import [-format csv | jsonl] [-kind books | requests] [-batch-size n] [-report file] [file]"
+	exportUsage = "usage: givedirectly [flags] export [-o file] [books | requests]"
+)
+
+// runImport adds the books or requests in a CSV or JSON Lines file, or stdin, to the configured postgres
+// database. Rows that are invalid or fail are reported and the rest are still imported, so an import can
+// be run again once they are fixed, skipping the rows already imported.
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "the format of the file, csv or jsonl. Defaults to csv for files ending in .csv and jsonl otherwise")
+	kind := flags.String("kind", string(importer.KindBooks), "what the file contains, books or requests")
+	batchSize := flags.Int("batch-size", importer.DefaultBatchSize, "how many books to add in each transaction")
+	reportFile := flags.String("report", "", "write the report of every skipped and failed row to the file as JSON, rather than to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 || *batchSize < 1 {
+		return errors.New(importUsage)
+	}
+
+	opts := importer.Options{Kind: importer.Kind(*kind), BatchSize: *batchSize}
+	if opts.Kind != importer.KindBooks && opts.Kind != importer.KindRequests {
+		return errors.New(importUsage)
+	}
+
+	file := flags.Arg(0)
+	opts.Format = importer.FormatOf(file)
+	if *format != "" {
+		var err error
+		if opts.Format, err = importer.ParseFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	in := io.Reader(os.Stdin)
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	sqlStore, err := openSQLStore(ctx, "import")
+	if err != nil {
+		return err
+	}
+	defer sqlStore.Close()
+
+	report, err := importer.Import(ctx, sqlStore, in, opts)
+	if report != nil {
+		log.G(ctx).Infof("read %d rows: imported %d, skipped %d already imported, %d failed", report.Rows, report.Imported, report.Skipped, report.Failed)
+
+		if reportErr := writeReport(report, *reportFile); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Failed > 0 {
+		return errors.Errorf("%d rows failed to import", report.Failed)
+	}
+
+	return nil
+}
+
+// writeReport writes the report as JSON to the file, or prints its rows to stderr if there is no file
+func writeReport(report *types.ImportReport, file string) error {
+	if file == "" {
+		printReport(os.Stderr, report)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// printReport writes a line for every skipped and failed row
+func printReport(w io.Writer, report *types.ImportReport) {
+	for _, rowErr := range report.Errors {
+		outcome := "failed"
+		if rowErr.Skipped {
+			outcome = "skipped"
+		}
+
+		if rowErr.Field != "" {
+			fmt.Fprintf(w, "row %d %s: %s: %s\n", rowErr.Row, outcome, rowErr.Field, rowErr.Message)
+		} else {
+			fmt.Fprintf(w, "row %d %s: %s\n", rowErr.Row, outcome, rowErr.Message)
+		}
+	}
+}
+
+// runExport writes the books or requests in the configured postgres database as JSON Lines
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "-", "the file to write to, stdout if '-'")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New(exportUsage)
+	}
+
+	export := exportBooks
+	switch flags.Arg(0) {
+	case "", "books":
+	case "requests":
+		export = exportRequests
+	default:
+		return errors.New(exportUsage)
+	}
+
+	sqlStore, err := openSQLStore(ctx, "export")
+	if err != nil {
+		return err
+	}
+	defer sqlStore.Close()
+
+	if *output == "-" {
+		return export(ctx, sqlStore, os.Stdout)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := export(ctx, sqlStore, f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// exportBooks writes every book in the catalog, with its copies, as a line of JSON
+func exportBooks(ctx context.Context, store apiserver.LibraryStore, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	query := &datastore.BookQuery{Page: datastore.Page{Limit: datastore.MaxPageSize}}
+	for {
+		page, err := store.ListBook(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, book := range page.Books {
+			if err := enc.Encode(book); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// exportRequests writes every request, including closed requests, as a line of JSON
+func exportRequests(ctx context.Context, store apiserver.LibraryStore, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	query := &datastore.RequestQuery{Page: datastore.Page{Limit: datastore.MaxPageSize}}
+	for {
+		page, err := store.ListRequest(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, request := range page.Requests {
+			if err := enc.Encode(request); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
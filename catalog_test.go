@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/importer"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestPrintReport(t *testing.T) {
+	var buf bytes.Buffer
+	printReport(&buf, &types.ImportReport{Rows: 3, Imported: 1, Skipped: 1, Failed: 1, Errors: []types.ImportError{
+		{Row: 2, Field: "title", Message: "title already in the catalog", Skipped: true},
+		{Row: 3, Message: "must be a JSON book"},
+	}})
+
+	assert.Equal(t, "row 2 skipped: title: title already in the catalog\nrow 3 failed: must be a JSON book\n", buf.String())
+}
+
+func TestExportBooks(t *testing.T) {
+	ctx := context.Background()
+
+	store := datastore.NewMemoryStore()
+	_, err := datastore.Seed(ctx, store, &datastore.Fixtures{Books: testBooks})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, exportBooks(ctx, store, &buf))
+	assert.Equal(t, len(testBooks), strings.Count(buf.String(), "\n"))
+
+	t.Run("Round Trip", func(t *testing.T) {
+		imported := datastore.NewMemoryStore()
+
+		report, err := importer.Import(ctx, imported, &buf, importer.Options{Format: importer.FormatJSONL})
+		require.NoError(t, err)
+		assert.Equal(t, &types.ImportReport{Rows: len(testBooks), Imported: len(testBooks)}, report)
+
+		for _, book := range testBooks {
+			page, err := imported.ListBook(ctx, &datastore.BookQuery{Title: book.Title, Available: &book.Available})
+			require.NoError(t, err)
+			assert.NotEmpty(t, page.Books, "Should keep the availability of %s.", book.Title)
+		}
+	})
+}
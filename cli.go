@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/datastore"
+)
+
+// command is a subcommand of the binary, given after the flags
+type command struct {
+	name string
+	// summary is shown in the list of subcommands
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// defaultCommand runs when no subcommand is given
+const defaultCommand = "serve"
+
+// commands are the subcommands of the binary
+var commands = []command{
+	{"serve", "run the API server, the default", runServe},
+	{"migrate", "apply or roll back database migrations", runMigrate},
+	{"seed", "add test books to the catalog", runSeed},
+	{"import", "add books or requests from a CSV or JSON Lines file", runImport},
+	{"export", "write the books or requests as JSON Lines", runExport},
+	{"requests", "list or cancel requests", runRequests},
+	{"apikey", "create, list or revoke API keys", runAPIKey},
+}
+
+// findCommand returns the subcommand with the name
+func findCommand(name string) (*command, bool) {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// commandUsage lists the subcommands
+func commandUsage() string {
+	var b strings.Builder
+	b.WriteString("usage: givedirectly [flags] [command] [args]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-10s%s\n", c.name, c.summary)
+	}
+
+	return b.String()
+}
+
+// openSQLStore connects to the configured postgres database for the subcommands that manage its data
+func openSQLStore(ctx context.Context, name string) (*datastore.SQLStore, error) {
+	if storeType != "postgres" {
+		return nil, errors.Errorf("the %s command manages the postgres store, it can not be used with -store=%s", name, storeType)
+	}
+
+	return datastore.NewSQLStore(ctx, sqlConfig)
+}
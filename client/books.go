@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// CreateBook adds a book to the catalog
+func (c *Client) CreateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	var created types.Book
+	if _, err := c.do(ctx, http.MethodPost, "/book", nil, book, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// GetBook gets a book and its copies
+func (c *Client) GetBook(ctx context.Context, bookID int) (*types.Book, error) {
+	var book types.Book
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/book/%d", bookID), nil, nil, &book); err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// BookListOptions filters, sorts and pages a listing of books. Filters that are left empty match
+// every book.
+type BookListOptions struct {
+	ListOptions
+
+	// Title matches books with titles containing it, ignoring case
+	Title string
+	// Available matches books with, or without, a free copy
+	Available *bool
+}
+
+// BookList is one page of a book listing
+type BookList struct {
+	Books []*types.Book
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// ListBooks lists one page of books. Pass the NextCursor of the list as the Cursor of the options
+// to get the next page.
+func (c *Client) ListBooks(ctx context.Context, opts *BookListOptions) (*BookList, error) {
+	query := url.Values{}
+	if opts != nil {
+		opts.ListOptions.encode(query)
+
+		setQuery(query, "title", opts.Title)
+
+		if opts.Available != nil {
+			query.Set("available", strconv.FormatBool(*opts.Available))
+		}
+	}
+
+	list := &BookList{}
+	resp, err := c.do(ctx, http.MethodGet, "/book", query, nil, &list.Books)
+	if err != nil {
+		return nil, err
+	}
+
+	list.NextCursor = nextCursor(resp.Header)
+	return list, nil
+}
+
+// UpdateBook updates the book with the ID of the book
+func (c *Client) UpdateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	var updated types.Book
+	if _, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/book/%d", book.ID), nil, book, &updated); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// DeleteBook deletes a book. Books with open requests can not be deleted.
+func (c *Client) DeleteBook(ctx context.Context, bookID int) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/book/%d", bookID), nil, nil, nil)
+	return err
+}
+
+// AddCopy adds a copy of a book
+func (c *Client) AddCopy(ctx context.Context, bookID int, copy *types.Copy) (*types.Copy, error) {
+	var created types.Copy
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/book/%d/copies", bookID), nil, copy, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// DeleteCopy deletes a copy of a book. Copies that are lent out can not be deleted.
+func (c *Client) DeleteCopy(ctx context.Context, bookID, copyID int) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/book/%d/copies/%d", bookID, copyID), nil, nil, nil)
+	return err
+}
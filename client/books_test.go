@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestBooks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Create Update And Delete", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{}, nil)
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		book, err := c.CreateBook(ctx, &types.Book{Title: "Dune", Available: true})
+		require.NoError(t, err)
+		require.NotZero(t, book.ID)
+
+		copy, err := c.AddCopy(ctx, book.ID, &types.Copy{Available: true})
+		require.NoError(t, err)
+
+		book.Title = "Dune Messiah"
+		_, err = c.UpdateBook(ctx, book)
+		require.NoError(t, err)
+
+		got, err := c.GetBook(ctx, book.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Dune Messiah", got.Title)
+		assert.Len(t, got.Copies, 2)
+
+		require.NoError(t, c.DeleteCopy(ctx, book.ID, copy.ID))
+		require.NoError(t, c.DeleteBook(ctx, book.ID))
+
+		_, err = c.GetBook(ctx, book.ID)
+		assert.True(t, IsNotFound(err), "Should be deleted.")
+	})
+
+	t.Run("Delete In Use", func(t *testing.T) {
+		testServer, store := newTestServer(t, &apiserver.Config{}, nil)
+		createBooks(t, store, "Dune")
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		request := &types.Request{Title: "Dune", Email: "reader@example.com"}
+		book, err := c.CreateRequest(ctx, request)
+		require.NoError(t, err)
+
+		err = c.DeleteBook(ctx, book.ID)
+		assert.True(t, HasCode(err, types.ErrorCodeInUse), "Should not delete a book with open requests.")
+	})
+
+	t.Run("List", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{}, nil)
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		for _, title := range []string{"Dune", "Emma", "Ulysses"} {
+			_, err := c.CreateBook(ctx, &types.Book{Title: title, Available: true})
+			require.NoError(t, err)
+		}
+
+		_, err := c.CreateRequest(ctx, &types.Request{Title: "Emma", Email: "reader@example.com"})
+		require.NoError(t, err)
+
+		available := true
+		first, err := c.ListBooks(ctx, &BookListOptions{ListOptions: ListOptions{Limit: 1, Sort: "title"}, Available: &available})
+		require.NoError(t, err)
+
+		require.Len(t, first.Books, 1)
+		assert.Equal(t, "Dune", first.Books[0].Title)
+
+		second, err := c.ListBooks(ctx, &BookListOptions{ListOptions: ListOptions{Limit: 1, Sort: "title", Cursor: first.NextCursor}, Available: &available})
+		require.NoError(t, err)
+
+		require.Len(t, second.Books, 1)
+		assert.Equal(t, "Ulysses", second.Books[0].Title, "Should skip the lent out book.")
+	})
+}
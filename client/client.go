@@ -0,0 +1,371 @@
+// Package client is a Go client for the library API served by the apiserver package
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samkreter/go-core/httputil"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	// defaultMaxRetries is how many times idempotent calls are retried when Config.MaxRetries is unset
+	defaultMaxRetries = 2
+
+	// defaultRetryBackoff is the wait before the first retry when Config.RetryBackoff is unset
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Config configuration for the API client
+type Config struct {
+	// BaseURL address of the apiserver, such as http://localhost:8080
+	BaseURL string
+	// APIKey sent with every request in the X-API-Key header, if set
+	APIKey string
+	// Token bearer token sent with every request, if set. It is used instead of the API key.
+	Token string
+	// EnableCorrelation propagate the correlation ID of the request context to the apiserver
+	EnableCorrelation bool
+	// EnableLogging log every outgoing request
+	EnableLogging bool
+	// EnableTracing trace every outgoing request
+	EnableTracing bool
+	// MaxRetries how many times idempotent calls are retried after a network error or an unavailable
+	// apiserver, 2 if unset. Set it negative to never retry.
+	MaxRetries int
+	// RetryBackoff the wait before the first retry, doubled before each retry after it. 100ms if unset.
+	RetryBackoff time.Duration
+}
+
+// Client calls the library API
+type Client struct {
+	config     *Config
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// New creates a new client and validates the configuration
+func New(config *Config) (*Client, error) {
+	if config == nil {
+		return nil, errors.New("missing client configuration")
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(config.BaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL '%s', must be an absolute URL", config.BaseURL)
+	}
+
+	if config.RetryBackoff < 0 {
+		return nil, errors.New("retry backoff can not be negative")
+	}
+
+	// Logging is added here rather than by go-core, whose logging transport can't handle requests
+	// that fail without a response
+	httpClient := httputil.NewHTTPClient(config.EnableCorrelation, false, config.EnableTracing)
+	if config.EnableLogging {
+		httpClient.Transport = &logTransport{transport: httpClient.Transport}
+	}
+
+	return &Client{
+		config:     config,
+		baseURL:    baseURL,
+		httpClient: httpClient,
+	}, nil
+}
+
+// CreateRequest requests a book for a patron, given by ID or email. The returned book reports whether
+// a copy was free for the request, if not the request was added to the book's waitlist. The ID and
+// status of the request are set from the response.
+func (c *Client) CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error) {
+	var book types.Book
+	resp, err := c.do(ctx, http.MethodPost, "/request", nil, request, &book)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := strconv.Atoi(strings.TrimPrefix(resp.Header.Get("Location"), "/request/")); err == nil {
+		request.ID = id
+	}
+
+	request.Status = types.RequestStatusRequested
+	if resp.StatusCode == http.StatusAccepted {
+		request.Status = types.RequestStatusWaiting
+	}
+
+	return &book, nil
+}
+
+// GetRequest gets a request
+func (c *Client) GetRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	var request types.Request
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/request/%d", requestID), nil, nil, &request); err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// RequestListOptions filters, sorts and pages a listing of requests. Filters that are left empty
+// match every request.
+type RequestListOptions struct {
+	ListOptions
+
+	// Email matches requests made by the patron with the email
+	Email string
+	// Title matches requests for titles containing it, ignoring case
+	Title string
+	// Statuses matches requests in any of the statuses
+	Statuses []types.RequestStatus
+	// CreatedAfter matches requests created at or after the time
+	CreatedAfter time.Time
+	// CreatedBefore matches requests created before the time
+	CreatedBefore time.Time
+}
+
+// RequestList is one page of a request listing
+type RequestList struct {
+	Requests []*types.Request
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// ListRequests lists one page of requests. Pass the NextCursor of the list as the Cursor of the
+// options to get the next page.
+func (c *Client) ListRequests(ctx context.Context, opts *RequestListOptions) (*RequestList, error) {
+	query := url.Values{}
+	if opts != nil {
+		opts.ListOptions.encode(query)
+
+		setQuery(query, "email", opts.Email)
+		setQuery(query, "title", opts.Title)
+
+		for _, status := range opts.Statuses {
+			query.Add("status", string(status))
+		}
+
+		if !opts.CreatedAfter.IsZero() {
+			query.Set("createdAfter", opts.CreatedAfter.Format(time.RFC3339))
+		}
+
+		if !opts.CreatedBefore.IsZero() {
+			query.Set("createdBefore", opts.CreatedBefore.Format(time.RFC3339))
+		}
+	}
+
+	list := &RequestList{}
+	resp, err := c.do(ctx, http.MethodGet, "/request", query, nil, &list.Requests)
+	if err != nil {
+		return nil, err
+	}
+
+	list.NextCursor = nextCursor(resp.Header)
+	return list, nil
+}
+
+// DeleteRequest deletes a request
+func (c *Client) DeleteRequest(ctx context.Context, requestID int) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/request/%d", requestID), nil, nil, nil)
+	return err
+}
+
+// ListOptions sorts and pages a listing
+type ListOptions struct {
+	// Limit is the most results to return, the apiserver's default if 0
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	// Sort is the field to sort by, prefixed with '-' for descending order
+	Sort string
+}
+
+func (o ListOptions) encode(query url.Values) {
+	if o.Limit != 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+
+	setQuery(query, "cursor", o.Cursor)
+	setQuery(query, "sort", o.Sort)
+}
+
+// setQuery sets the query parameter if the value is not empty
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// nextCursor returns the cursor of the next page from the Link header of a listing
+func nextCursor(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+			continue
+		}
+
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+		if err != nil {
+			return ""
+		}
+
+		return next.Query().Get("cursor")
+	}
+
+	return ""
+}
+
+// do sends a request to the apiserver, encoding the body as JSON and decoding the response into out.
+// Idempotent requests are retried when the apiserver can't be reached or is unavailable. It returns the
+// response, whose body has been read and closed.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	retries := 0
+	if idempotent(method) {
+		retries = c.config.MaxRetries
+		if retries == 0 {
+			retries = defaultMaxRetries
+		}
+	}
+
+	backoff := c.config.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, u.String(), payload)
+		if err == nil && !retryable(resp.StatusCode) || attempt >= retries {
+			if err != nil {
+				return nil, err
+			}
+
+			err := decodeResponse(method, u.Path, resp, out)
+			if attempt > 0 && method == http.MethodDelete && alreadyDeleted(err) {
+				// An earlier attempt deleted the resource but its response was lost
+				return resp, nil
+			}
+
+			return resp, err
+		}
+
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		log.G(ctx).Debugf("retrying %s %s after attempt %d", method, u.Path, attempt+1)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff << uint(attempt)):
+		}
+	}
+}
+
+// send sends a single request
+func (c *Client) send(ctx context.Context, method, url string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	switch {
+	case c.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	case c.config.APIKey != "":
+		req.Header.Set("X-API-Key", c.config.APIKey)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// decodeResponse decodes a successful response into out, or returns the error of a failed one
+func decodeResponse(method, path string, resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newError(method, path, resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// idempotent returns true if sending a request with the method more than once has the same effect
+// as sending it once. Deleting again fails once the resource is gone, so do treats that as success when
+// it retries a delete.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// alreadyDeleted returns true if a delete failed because the resource was already deleted, or the
+// request was already closed
+func alreadyDeleted(err error) bool {
+	return IsNotFound(err) || HasCode(err, types.ErrorCodeInvalidTransition)
+}
+
+// retryable returns true if a request that failed with the status may succeed if it is sent again
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// logTransport logs outgoing requests with go-core, including requests that fail without a response
+type logTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endLogging := httputil.StartLogOutgoingRequest(req)
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		log.G(req.Context()).WithError(err).Debugf("Outgoing Http Request to %s failed", req.URL)
+		return nil, err
+	}
+
+	endLogging(resp, nil)
+	return resp, nil
+}
@@ -0,0 +1,357 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// testAPIKey is the admin API key of servers created with auth enabled
+const testAPIKey = "gdk_clienttest"
+
+// newTestServer runs an apiserver backed by an in-memory store. The wrap function, if given, wraps
+// the apiserver's handler.
+func newTestServer(t *testing.T, config *apiserver.Config, wrap func(http.Handler) http.Handler) (*httptest.Server, *datastore.MemoryStore) {
+	t.Helper()
+
+	store := datastore.NewMemoryStore()
+
+	if config.EnableAuth {
+		_, err := store.CreateAPIKey(context.Background(), &types.APIKey{
+			Name: "client-test",
+			Role: string(auth.RoleAdmin),
+			Hash: auth.HashAPIKey(testAPIKey),
+		})
+		require.NoError(t, err)
+	}
+
+	config.ServerAddr = "127.0.0.1:0"
+	server, err := apiserver.NewServer(store, config)
+	require.NoError(t, err)
+
+	handler := server.Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+
+	return testServer, store
+}
+
+// createBooks adds books with a single free copy to the store
+func createBooks(t *testing.T, store *datastore.MemoryStore, titles ...string) {
+	t.Helper()
+
+	for _, title := range titles {
+		_, err := store.CreateBook(context.Background(), &types.Book{Title: title, Available: true})
+		require.NoError(t, err)
+	}
+}
+
+func newTestClient(t *testing.T, config *Config) *Client {
+	t.Helper()
+
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Millisecond
+	}
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	return c
+}
+
+// failFirst returns a wrapper that responds to the first n requests with the status
+func failFirst(n int32, status int, calls *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(calls, 1) <= n {
+				http.Error(w, "upstream unavailable", status)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, config := range []*Config{
+		nil,
+		{},
+		{BaseURL: "localhost:8080"},
+		{BaseURL: "http://localhost:8080", RetryBackoff: -time.Second},
+	} {
+		_, err := New(config)
+		assert.Error(t, err, "Should reject %+v.", config)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Create Get And Delete", func(t *testing.T) {
+		testServer, store := newTestServer(t, &apiserver.Config{}, nil)
+		createBooks(t, store, "Dune")
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		request := &types.Request{Title: "Dune", Email: "reader@example.com"}
+		book, err := c.CreateRequest(ctx, request)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Dune", book.Title)
+		assert.True(t, book.Available, "Should have a free copy for the first request.")
+		assert.NotZero(t, request.ID, "Should set the ID of the request.")
+		assert.Equal(t, types.RequestStatusRequested, request.Status)
+
+		waiting := &types.Request{Title: "Dune", Email: "other@example.com"}
+		_, err = c.CreateRequest(ctx, waiting)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusWaiting, waiting.Status, "Should join the waitlist.")
+
+		got, err := c.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "reader@example.com", got.Email)
+
+		require.NoError(t, c.DeleteRequest(ctx, request.ID))
+
+		got, err = c.GetRequest(ctx, waiting.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, got.Status, "Should give the copy to the waiting request.")
+	})
+
+	t.Run("List Pages", func(t *testing.T) {
+		testServer, store := newTestServer(t, &apiserver.Config{}, nil)
+		createBooks(t, store, "Dune", "Emma", "Ulysses")
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		for _, title := range []string{"Dune", "Emma", "Ulysses"} {
+			_, err := c.CreateRequest(ctx, &types.Request{Title: title, Email: "reader@example.com"})
+			require.NoError(t, err)
+		}
+
+		opts := &RequestListOptions{ListOptions: ListOptions{Limit: 2, Sort: "-title"}}
+		first, err := c.ListRequests(ctx, opts)
+		require.NoError(t, err)
+
+		require.Len(t, first.Requests, 2)
+		assert.Equal(t, "Ulysses", first.Requests[0].Title)
+		assert.NotEmpty(t, first.NextCursor, "Should have a next page.")
+
+		opts.Cursor = first.NextCursor
+		second, err := c.ListRequests(ctx, opts)
+		require.NoError(t, err)
+
+		require.Len(t, second.Requests, 1)
+		assert.Equal(t, "Dune", second.Requests[0].Title)
+		assert.Empty(t, second.NextCursor, "Should be the last page.")
+	})
+
+	t.Run("List Filters", func(t *testing.T) {
+		testServer, store := newTestServer(t, &apiserver.Config{}, nil)
+		createBooks(t, store, "Dune")
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		for _, email := range []string{"first@example.com", "second@example.com"} {
+			_, err := c.CreateRequest(ctx, &types.Request{Title: "Dune", Email: email})
+			require.NoError(t, err)
+		}
+
+		list, err := c.ListRequests(ctx, &RequestListOptions{
+			Statuses:     []types.RequestStatus{types.RequestStatusWaiting},
+			CreatedAfter: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		require.Len(t, list.Requests, 1)
+		assert.Equal(t, "second@example.com", list.Requests[0].Email)
+	})
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Problem Details", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{EnableReqCorrelation: true}, nil)
+		c := newTestClient(t, &Config{BaseURL: testServer.URL, EnableCorrelation: true})
+
+		_, err := c.GetRequest(correlation.SetCorrelationID(ctx, "client-test-correlation"), 404)
+
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, types.ErrorCodeNotFound, apiErr.Code)
+		assert.Equal(t, "client-test-correlation", apiErr.CorrelationID, "Should propagate the correlation ID.")
+		assert.Equal(t, "GET /request/404 failed with status 404 (not_found): request not found", err.Error())
+	})
+
+	t.Run("Invalid Fields", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{}, nil)
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		_, err := c.CreateRequest(ctx, &types.Request{Email: "reader@example.com"})
+		require.True(t, HasCode(err, types.ErrorCodeValidationFailed))
+
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, []types.FieldError{{Field: "title", Message: "must supply a title"}}, apiErr.Errors)
+	})
+
+	t.Run("Not Problem Details", func(t *testing.T) {
+		var calls int32
+		testServer, _ := newTestServer(t, &apiserver.Config{}, failFirst(1, http.StatusBadGateway, &calls))
+		c := newTestClient(t, &Config{BaseURL: testServer.URL, MaxRetries: -1})
+
+		_, err := c.GetRequest(ctx, 1)
+
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+		assert.Empty(t, apiErr.Code)
+		assert.Equal(t, "upstream unavailable", apiErr.Detail, "Should keep the body.")
+	})
+
+	t.Run("Authentication", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{EnableAuth: true}, nil)
+
+		_, err := newTestClient(t, &Config{BaseURL: testServer.URL}).ListRequests(ctx, nil)
+		assert.True(t, HasCode(err, types.ErrorCodeUnauthorized), "Should need an API key.")
+
+		_, err = newTestClient(t, &Config{BaseURL: testServer.URL, APIKey: testAPIKey}).ListRequests(ctx, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		testServer, _ := newTestServer(t, &apiserver.Config{}, nil)
+		testServer.Close()
+
+		c := newTestClient(t, &Config{BaseURL: testServer.URL, EnableLogging: true})
+
+		_, err := c.GetRequest(ctx, 1)
+		assert.Error(t, err)
+	})
+}
+
+// loseFirstResponse returns a wrapper that serves the first request but responds with 503, as when the
+// response is lost on its way back to the client
+func loseFirstResponse(calls *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(calls, 1) > 1 {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			next.ServeHTTP(httptest.NewRecorder(), req)
+			http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Retries Idempotent Calls", func(t *testing.T) {
+		var calls int32
+		testServer, store := newTestServer(t, &apiserver.Config{}, failFirst(2, http.StatusServiceUnavailable, &calls))
+		createBooks(t, store, "Dune")
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		request := &types.Request{Title: "Dune", Email: "reader@example.com"}
+		_, err := store.CreateRequest(ctx, request)
+		require.NoError(t, err)
+
+		got, err := c.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, request.ID, got.ID)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Should retry twice.")
+	})
+
+	t.Run("Gives Up", func(t *testing.T) {
+		var calls int32
+		testServer, _ := newTestServer(t, &apiserver.Config{}, failFirst(10, http.StatusServiceUnavailable, &calls))
+		c := newTestClient(t, &Config{BaseURL: testServer.URL, MaxRetries: 3})
+
+		err := c.DeleteRequest(ctx, 1)
+
+		assert.Error(t, err)
+		assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "Should stop after the max retries.")
+	})
+
+	t.Run("Retried Delete Already Deleted", func(t *testing.T) {
+		for name, status := range map[string]types.RequestStatus{
+			"Cancelled": types.RequestStatusRequested,
+			"Returned":  types.RequestStatusCheckedOut,
+		} {
+			t.Run(name, func(t *testing.T) {
+				var calls int32
+				testServer, store := newTestServer(t, &apiserver.Config{}, loseFirstResponse(&calls))
+				createBooks(t, store, "Dune")
+				c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+				request := &types.Request{Title: "Dune", Email: "reader@example.com"}
+				_, err := store.CreateRequest(ctx, request)
+				require.NoError(t, err)
+				if status == types.RequestStatusCheckedOut {
+					_, err = store.UpdateRequestStatus(ctx, request.ID, status)
+					require.NoError(t, err)
+				}
+
+				require.NoError(t, c.DeleteRequest(ctx, request.ID), "Should succeed when the lost attempt deleted the request.")
+				assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+			})
+		}
+
+		t.Run("Book", func(t *testing.T) {
+			var calls int32
+			testServer, store := newTestServer(t, &apiserver.Config{}, loseFirstResponse(&calls))
+			createBooks(t, store, "Dune")
+			c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+			require.NoError(t, c.DeleteBook(ctx, 1))
+			assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+			_, err := store.GetBook(ctx, 1)
+			assert.Equal(t, datastore.ErrNotFound, err)
+		})
+	})
+
+	t.Run("Does Not Retry Posts", func(t *testing.T) {
+		var calls int32
+		testServer, _ := newTestServer(t, &apiserver.Config{}, failFirst(1, http.StatusServiceUnavailable, &calls))
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		_, err := c.CreateRequest(ctx, &types.Request{Title: "Dune", Email: "reader@example.com"})
+
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Should not retry a call that isn't idempotent.")
+	})
+
+	t.Run("Does Not Retry Client Errors", func(t *testing.T) {
+		var calls int32
+		testServer, _ := newTestServer(t, &apiserver.Config{}, failFirst(0, 0, &calls))
+		c := newTestClient(t, &Config{BaseURL: testServer.URL})
+
+		_, err := c.GetRequest(ctx, 1)
+
+		assert.True(t, IsNotFound(err))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
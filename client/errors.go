@@ -0,0 +1,67 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// maxErrorBodySize is the most of a response body that is read into an error that is not problem details
+const maxErrorBodySize = 4 << 10
+
+// Error is an error response from the apiserver. Check the Code to handle specific errors.
+type Error struct {
+	// Method and Path are the request that failed
+	Method string
+	Path   string
+	// StatusCode is the status of the response
+	StatusCode int
+
+	types.Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s failed with status %d", e.Method, e.Path, e.StatusCode)
+	if e.Code != "" {
+		msg += fmt.Sprintf(" (%s)", e.Code)
+	}
+
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+
+	return msg
+}
+
+// newError returns the error of a failed response. Responses that are not problem details, such as
+// those from a proxy in front of the apiserver, keep their body as the detail.
+func newError(method, path string, resp *http.Response) *Error {
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+
+	body, _ := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxErrorBodySize))
+	if err := json.Unmarshal(body, &e.Problem); err != nil || e.Problem.Status == 0 {
+		e.Problem = types.Problem{
+			Title:  http.StatusText(resp.StatusCode),
+			Status: resp.StatusCode,
+			Detail: strings.TrimSpace(string(body)),
+		}
+	}
+
+	return e
+}
+
+// HasCode returns true if the error is an API error with the code
+func HasCode(err error, code types.ErrorCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// IsNotFound returns true if the error is because the resource does not exist
+func IsNotFound(err error) bool {
+	return HasCode(err, types.ErrorCodeNotFound)
+}
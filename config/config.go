@@ -0,0 +1,265 @@
+// Package config loads configuration into the flags of a flag set from a YAML or JSON file and
+// environment variables, so every setting can be given in whichever way suits the deployment
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source is where the value of a setting came from
+type Source string
+
+const (
+	SourceDefault    Source = "default"
+	SourceFile       Source = "file"
+	SourceEnv        Source = "env"
+	SourceFlag       Source = "flag"
+	SourceSecretFile Source = "secret file"
+)
+
+// redacted replaces the values of secrets when the configuration is dumped
+const redacted = "REDACTED"
+
+// configFlag is the flag giving the configuration file
+const configFlag = "config"
+
+// Loader loads configuration into the flags of a flag set. Flags given on the command line take
+// precedence over environment variables, which take precedence over the configuration file.
+type Loader struct {
+	flags     *flag.FlagSet
+	envPrefix string
+	secrets   map[string]bool
+	excluded  map[string]bool
+	sources   map[string]Source
+
+	// lookupEnv looks up environment variables, replaced in tests
+	lookupEnv func(key string) (string, bool)
+}
+
+// NewLoader creates a loader for the flag set, which registers the -config flag. Every flag can be set
+// by an environment variable named after it with the prefix, upper-cased and with dashes replaced by
+// underscores, so -pg-host is set by GIVEDIRECTLY_PG_HOST with the prefix GIVEDIRECTLY_.
+func NewLoader(flags *flag.FlagSet, envPrefix string) *Loader {
+	flags.String(configFlag, "", "a YAML or JSON file of configuration, keyed by flag name")
+
+	return &Loader{
+		flags:     flags,
+		envPrefix: envPrefix,
+		secrets:   map[string]bool{},
+		excluded:  map[string]bool{configFlag: true},
+		sources:   map[string]Source{},
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// Secret marks the flag as a secret. Secrets are redacted when the configuration is dumped, and can be
+// read from the file given by the flag's -file variant, such as -pg-password-file for -pg-password, so
+// they don't have to be passed on the command line.
+func (l *Loader) Secret(name string) {
+	f := l.flags.Lookup(name)
+	if f == nil {
+		panic(fmt.Sprintf("config: secret %q is not a flag", name))
+	}
+
+	l.secrets[name] = true
+	l.flags.String(name+"-file", "", "a file containing "+f.Usage)
+}
+
+// CommandLineOnly keeps the flag out of the configuration file, the environment and the dump, for flags
+// that only make sense for a single run
+func (l *Loader) CommandLineOnly(name string) {
+	l.excluded[name] = true
+}
+
+// EnvVar returns the environment variable that sets the flag
+func (l *Loader) EnvVar(name string) string {
+	return l.envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load parses the command line arguments into the flags. Flags that weren't given are then set from
+// the environment, or failing that the configuration file. Secrets are finally read from their files.
+func (l *Loader) Load(args []string) error {
+	if err := l.flags.Parse(args); err != nil {
+		return err
+	}
+
+	l.flags.VisitAll(func(f *flag.Flag) {
+		l.sources[f.Name] = SourceDefault
+	})
+	l.flags.Visit(func(f *flag.Flag) {
+		l.sources[f.Name] = SourceFlag
+	})
+
+	if l.sources[configFlag] == SourceDefault {
+		if file, ok := l.lookupEnv(l.EnvVar(configFlag)); ok {
+			if err := l.set(configFlag, file, SourceEnv); err != nil {
+				return err
+			}
+		}
+	}
+
+	fileValues := map[string]string{}
+	if file := l.flags.Lookup(configFlag).Value.String(); file != "" {
+		var err error
+		if fileValues, err = readFile(file); err != nil {
+			return err
+		}
+	}
+
+	for key := range fileValues {
+		if l.flags.Lookup(key) == nil || l.excluded[key] {
+			return fmt.Errorf("unknown configuration key %q", key)
+		}
+	}
+
+	var err error
+	l.flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || l.excluded[f.Name] || l.sources[f.Name] != SourceDefault {
+			return
+		}
+
+		if value, ok := l.lookupEnv(l.EnvVar(f.Name)); ok {
+			err = l.set(f.Name, value, SourceEnv)
+		} else if value, ok := fileValues[f.Name]; ok {
+			err = l.set(f.Name, value, SourceFile)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.readSecrets()
+}
+
+// readSecrets sets the secrets given by a file
+func (l *Loader) readSecrets() error {
+	names := make([]string, 0, len(l.secrets))
+	for name := range l.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := l.flags.Lookup(name + "-file").Value.String()
+		if file == "" {
+			continue
+		}
+
+		if l.sources[name] != SourceDefault {
+			return fmt.Errorf("%s is set by both %s and %s-file, only one can be used", name, l.sources[name], name)
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		if err := l.set(name, strings.TrimRight(string(b), "\r\n"), SourceSecretFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Loader) set(name, value string, source Source) error {
+	if err := l.flags.Set(name, value); err != nil {
+		if l.secrets[name] {
+			value = redacted
+		}
+		return fmt.Errorf("invalid value %q for %s from %s: %w", value, name, source, err)
+	}
+
+	l.sources[name] = source
+	return nil
+}
+
+// Source returns where the flag's value came from
+func (l *Loader) Source(name string) Source {
+	return l.sources[name]
+}
+
+// Dump writes the effective configuration as YAML, with where every value came from. Secrets are
+// redacted, so they must be removed or replaced before the dump is used as a configuration file.
+func (l *Loader) Dump(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+
+	l.flags.VisitAll(func(f *flag.Flag) {
+		if l.excluded[f.Name] {
+			return
+		}
+
+		value := f.Value.String()
+		if l.secrets[f.Name] && value != "" {
+			value = redacted
+		}
+
+		node := &yaml.Node{Kind: yaml.ScalarNode, Value: value, LineComment: string(l.sources[f.Name])}
+		if value == "" {
+			// Empty values must be quoted, or they would be read back as null
+			node.Style = yaml.DoubleQuotedStyle
+		}
+
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name}, node)
+	})
+
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// readFile reads a YAML or JSON configuration file into values keyed by flag name. Nested keys are joined
+// with dashes, so pg.host sets -pg-host.
+func readFile(file string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %s: %w", file, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten("", doc, values); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", file, err)
+	}
+
+	return values, nil
+}
+
+func flatten(prefix string, doc map[string]interface{}, values map[string]string) error {
+	for key, value := range doc {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flatten(key, v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			return fmt.Errorf("%s must not be a list", key)
+		case nil:
+			return errors.New(key + " must have a value")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig is a set of flags like the apiserver's
+type testConfig struct {
+	addr        string
+	enableAuth  bool
+	pgHost      string
+	pgPort      int
+	pgPassword  string
+	readTimeout time.Duration
+	dump        bool
+}
+
+func newTestLoader(env map[string]string) (*Loader, *testConfig) {
+	config := &testConfig{}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.StringVar(&config.addr, "addr", "0.0.0.0:8080", "the address")
+	flags.BoolVar(&config.enableAuth, "enable-auth", true, "require authentication")
+	flags.StringVar(&config.pgHost, "pg-host", "localhost", "the postgres host")
+	flags.IntVar(&config.pgPort, "pg-port", 5432, "the postgres port")
+	flags.StringVar(&config.pgPassword, "pg-password", "", "the postgres password")
+	flags.DurationVar(&config.readTimeout, "read-timeout", 30*time.Second, "the read timeout")
+	flags.BoolVar(&config.dump, "dump", false, "dump the configuration")
+
+	l := NewLoader(flags, "GIVEDIRECTLY_")
+	l.Secret("pg-password")
+	l.CommandLineOnly("dump")
+	l.lookupEnv = func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	return l, config
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		l, config := newTestLoader(nil)
+
+		require.NoError(t, l.Load(nil))
+
+		assert.Equal(t, "localhost", config.pgHost)
+		assert.Equal(t, SourceDefault, l.Source("pg-host"))
+	})
+
+	t.Run("YAML File", func(t *testing.T) {
+		file := writeFile(t, "config.yaml", `
+addr: 127.0.0.1:9000
+enable-auth: false
+read-timeout: 1m
+pg:
+  host: postgres
+  port: 5433
+`)
+		l, config := newTestLoader(nil)
+
+		require.NoError(t, l.Load([]string{"-config", file}))
+
+		assert.Equal(t, "127.0.0.1:9000", config.addr)
+		assert.False(t, config.enableAuth)
+		assert.Equal(t, time.Minute, config.readTimeout)
+		assert.Equal(t, "postgres", config.pgHost, "Should join nested keys with dashes.")
+		assert.Equal(t, 5433, config.pgPort)
+		assert.Equal(t, SourceFile, l.Source("pg-port"))
+	})
+
+	t.Run("JSON File", func(t *testing.T) {
+		file := writeFile(t, "config.json", `{"pg": {"host": "postgres", "port": 5433}, "read-timeout": "5s"}`)
+		l, config := newTestLoader(nil)
+
+		require.NoError(t, l.Load([]string{"-config", file}))
+
+		assert.Equal(t, "postgres", config.pgHost)
+		assert.Equal(t, 5433, config.pgPort)
+		assert.Equal(t, 5*time.Second, config.readTimeout)
+	})
+
+	t.Run("Precedence", func(t *testing.T) {
+		file := writeFile(t, "config.yaml", "pg-host: from-file\npg-port: 1\naddr: from-file:80\n")
+		l, config := newTestLoader(map[string]string{
+			"GIVEDIRECTLY_CONFIG":  file,
+			"GIVEDIRECTLY_PG_HOST": "from-env",
+			"GIVEDIRECTLY_ADDR":    "from-env:80",
+		})
+
+		require.NoError(t, l.Load([]string{"-addr", "from-flag:80"}))
+
+		assert.Equal(t, "from-flag:80", config.addr, "Flags should beat the environment.")
+		assert.Equal(t, "from-env", config.pgHost, "The environment should beat the file.")
+		assert.Equal(t, 1, config.pgPort)
+		assert.Equal(t, SourceFlag, l.Source("addr"))
+		assert.Equal(t, SourceEnv, l.Source("pg-host"))
+		assert.Equal(t, SourceFile, l.Source("pg-port"))
+	})
+
+	t.Run("Secret File", func(t *testing.T) {
+		secret := writeFile(t, "pg_password", "hunter2\n")
+		l, config := newTestLoader(map[string]string{"GIVEDIRECTLY_PG_PASSWORD_FILE": secret})
+
+		require.NoError(t, l.Load(nil))
+
+		assert.Equal(t, "hunter2", config.pgPassword, "Should trim the trailing newline.")
+		assert.Equal(t, SourceSecretFile, l.Source("pg-password"))
+	})
+
+	t.Run("Secret Set Twice", func(t *testing.T) {
+		secret := writeFile(t, "pg_password", "hunter2")
+		l, _ := newTestLoader(map[string]string{"GIVEDIRECTLY_PG_PASSWORD": "hunter3"})
+
+		assert.Error(t, l.Load([]string{"-pg-password-file", secret}))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"Unknown Key":   "pg-hots: postgres\n",
+			"Command Line":  "dump: true\n",
+			"Invalid Value": "pg-port: five\n",
+			"List":          "addr: [a, b]\n",
+			"Empty Value":   "addr:\n",
+			"Malformed":     "addr: [\n",
+		} {
+			t.Run(name, func(t *testing.T) {
+				l, _ := newTestLoader(nil)
+				assert.Error(t, l.Load([]string{"-config", writeFile(t, "config.yaml", content)}))
+			})
+		}
+
+		l, _ := newTestLoader(map[string]string{"GIVEDIRECTLY_PG_PORT": "five"})
+		assert.Error(t, l.Load(nil), "Should reject invalid environment variables.")
+
+		l, _ = newTestLoader(nil)
+		assert.Error(t, l.Load([]string{"-config", "missing.yaml"}))
+	})
+}
+
+func TestDump(t *testing.T) {
+	l, _ := newTestLoader(map[string]string{
+		"GIVEDIRECTLY_PG_HOST":     "postgres",
+		"GIVEDIRECTLY_PG_PASSWORD": "hunter2",
+	})
+	require.NoError(t, l.Load([]string{"-enable-auth=false", "-dump"}))
+
+	var buf bytes.Buffer
+	require.NoError(t, l.Dump(&buf))
+
+	assert.Equal(t, `addr: 0.0.0.0:8080 # default
+enable-auth: false # flag
+pg-host: postgres # env
+pg-password: REDACTED # env
+pg-password-file: "" # default
+pg-port: 5432 # default
+read-timeout: 30s # default
+`, buf.String())
+
+	assert.NotContains(t, buf.String(), "hunter2")
+
+	t.Run("Reloads", func(t *testing.T) {
+		reloaded, config := newTestLoader(nil)
+		require.NoError(t, reloaded.Load([]string{"-config", writeFile(t, "config.yaml", buf.String())}))
+
+		assert.Equal(t, "postgres", config.pgHost)
+		assert.False(t, config.enableAuth)
+	})
+}
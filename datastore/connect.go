@@ -0,0 +1,134 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+)
+
+// defaultConnectTimeout is how long to retry connecting when SQLConfig.ConnectTimeout is unset
+const defaultConnectTimeout = 30 * time.Second
+
+// SQLConfig configuration for connecting the SQLStore to postgres
+type SQLConfig struct {
+	User     string
+	DBName   string
+	Password string
+	Host     string
+	Port     int
+
+	// ConnectTimeout how long to keep retrying the first connection, 30 seconds if unset
+	ConnectTimeout time.Duration
+	// MaxOpenConns the most connections open to the database, unlimited if unset
+	MaxOpenConns int
+	// MaxIdleConns the most idle connections kept open for reuse, 2 if unset
+	MaxIdleConns int
+	// ConnMaxLifetime how long a connection is reused before it is closed, forever if unset
+	ConnMaxLifetime time.Duration
+}
+
+// Validate checks the configuration can be used to connect to postgres
+func (c *SQLConfig) Validate() error {
+	if c == nil {
+		return errors.New("missing postgres configuration")
+	}
+
+	if c.Host == "" || c.User == "" || c.DBName == "" {
+		return errors.New("must supply the postgres host, user and dbname")
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		return errors.Errorf("invalid postgres port: %d", c.Port)
+	}
+
+	if c.ConnectTimeout < 0 {
+		return errors.New("connect timeout can not be negative")
+	}
+
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return errors.New("connection pool sizes can not be negative")
+	}
+
+	if c.ConnMaxLifetime < 0 {
+		return errors.New("connection lifetime can not be negative")
+	}
+
+	return nil
+}
+
+// open opens the connection pool, retrying until postgres accepts a connection or the connect timeout
+func (c *SQLConfig) open(ctx context.Context) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s "+
+		"password=%s dbname=%s sslmode=disable",
+		c.Host, c.Port, c.User, c.Password, c.DBName)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+
+	timeout := c.ConnectTimeout
+	if timeout == 0 {
+		timeout = defaultConnectTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := retryConnect(ctx, connectBackoff, db.PingContext); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// backoff is the wait between attempts, doubled after every attempt up to the max
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// connectBackoff is the backoff between attempts to connect to postgres
+var connectBackoff = backoff{initial: 250 * time.Millisecond, max: 5 * time.Second}
+
+// wait returns how long to wait after the attempt, counting from 0. Waits are between half and all of
+// the backoff, so replicas that start together don't retry in lockstep.
+func (b backoff) wait(attempt int) time.Duration {
+	d := b.max
+	if attempt < 32 && b.initial<<uint(attempt) < b.max {
+		d = b.initial << uint(attempt)
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryConnect calls connect until it succeeds or the context is done, logging every failed attempt
+func retryConnect(ctx context.Context, b backoff, connect func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := connect(ctx)
+		if err == nil {
+			return nil
+		}
+
+		wait := b.wait(attempt)
+		log.G(ctx).Warnf("failed to connect to postgres on attempt %d, retrying in %s: %v", attempt+1, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "failed to connect to postgres after %d attempts", attempt+1)
+		case <-time.After(wait):
+		}
+	}
+}
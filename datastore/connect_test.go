@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{initial: 100 * time.Millisecond, max: time.Second}
+
+	for attempt, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		for i := 0; i < 20; i++ {
+			wait := b.wait(attempt)
+			assert.True(t, wait >= want/2 && wait <= want, "Attempt %d should wait between %s and %s, got %s.", attempt, want/2, want, wait)
+		}
+	}
+
+	assert.True(t, b.wait(100) <= time.Second, "Should not overflow.")
+}
+
+func TestRetryConnect(t *testing.T) {
+	b := backoff{initial: time.Millisecond, max: 5 * time.Millisecond}
+	errRefused := errors.New("connection refused")
+
+	t.Run("Retries Until Connected", func(t *testing.T) {
+		attempts := 0
+		err := retryConnect(context.Background(), b, func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return errRefused
+			}
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("Gives Up At Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := retryConnect(ctx, b, func(context.Context) error {
+			return errRefused
+		})
+
+		assert.True(t, errors.Is(err, errRefused), "Should return the last error.")
+	})
+}
+
+func TestSQLConfigValidate(t *testing.T) {
+	valid := func(update func(c *SQLConfig)) *SQLConfig {
+		c := &SQLConfig{Host: "localhost", Port: 5432, User: "librarystore", DBName: "librarystore"}
+		update(c)
+		return c
+	}
+
+	for _, config := range []*SQLConfig{
+		nil,
+		{},
+		valid(func(c *SQLConfig) { c.Host = "" }),
+		valid(func(c *SQLConfig) { c.Port = 0 }),
+		valid(func(c *SQLConfig) { c.Port = 65536 }),
+		valid(func(c *SQLConfig) { c.ConnectTimeout = -time.Second }),
+		valid(func(c *SQLConfig) { c.MaxOpenConns = -1 }),
+		valid(func(c *SQLConfig) { c.MaxIdleConns = -1 }),
+		valid(func(c *SQLConfig) { c.ConnMaxLifetime = -time.Minute }),
+	} {
+		assert.Error(t, config.Validate(), "Should reject %+v.", config)
+	}
+
+	assert.NoError(t, valid(func(c *SQLConfig) { c.MaxOpenConns = 10; c.ConnMaxLifetime = time.Hour }).Validate())
+}
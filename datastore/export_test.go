@@ -0,0 +1,7 @@
+package datastore
+
+// Reset removes all rows and restarts the ID sequences so every test starts from an empty database
+func (s *SQLStore) Reset() error {
+	_, err := s.db.Exec("TRUNCATE books, copies, requests, patrons, api_keys RESTART IDENTITY")
+	return err
+}
@@ -0,0 +1,43 @@
+package datastore
+
+import "strings"
+
+// NormalizeISBN returns the form ISBNs are stored and compared in, without hyphens or spaces and with
+// an ISBN-10 check digit of X upper cased
+func NormalizeISBN(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+}
+
+// ValidISBN returns true if the normalized ISBN is an ISBN-10 or ISBN-13 with a correct check digit
+func ValidISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			digit := int(c - '0')
+			switch {
+			case c == 'X' && i == 9:
+				digit = 10
+			case c < '0' || c > '9':
+				return false
+			}
+			sum += digit * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(c-'0') * weight
+		}
+		return sum%10 == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,23 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidISBN(t *testing.T) {
+	for isbn, valid := range map[string]bool{
+		"978-0-306-40615-7": true,
+		"0-306-40615-2":     true,
+		"0-8044-2957-x":     true,
+		"978-0-306-40615-8": false,
+		"0-306-40615-3":     false,
+		"X-306-40615-2":     false,
+		"978030640615":      false,
+		"97803064061a7":     false,
+		"":                  false,
+	} {
+		assert.Equal(t, valid, ValidISBN(NormalizeISBN(isbn)), isbn)
+	}
+}
@@ -0,0 +1,161 @@
+package datastore
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// requestTransitions is the request state machine. It maps each status to the statuses
+// a request may move to from it. Returned and cancelled requests are final. Waiting requests
+// are only moved to requested by the store when the book is freed.
+var requestTransitions = map[types.RequestStatus][]types.RequestStatus{
+	types.RequestStatusWaiting:    {types.RequestStatusCancelled},
+	types.RequestStatusRequested:  {types.RequestStatusCheckedOut, types.RequestStatusCancelled},
+	types.RequestStatusCheckedOut: {types.RequestStatusReturned},
+}
+
+// KnownStatus returns true if the status is one of the request statuses
+func KnownStatus(status types.RequestStatus) bool {
+	switch status {
+	case types.RequestStatusWaiting, types.RequestStatusRequested, types.RequestStatusCheckedOut,
+		types.RequestStatusReturned, types.RequestStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkTransition returns ErrInvalidTransition if a request cannot move from one status to the other
+func checkTransition(from, to types.RequestStatus) error {
+	for _, allowed := range requestTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+
+	return ErrInvalidTransition
+}
+
+// closingStatus returns the final status a request moves to when it is deleted. Waiting requests
+// and requests that were never picked up are cancelled and checked out requests are returned.
+func closingStatus(from types.RequestStatus) (types.RequestStatus, error) {
+	switch from {
+	case types.RequestStatusWaiting, types.RequestStatusRequested:
+		return types.RequestStatusCancelled, nil
+	case types.RequestStatusCheckedOut:
+		return types.RequestStatusReturned, nil
+	default:
+		return "", ErrInvalidTransition
+	}
+}
+
+// holdsBook returns true if a request in the status has the book reserved or checked out
+func holdsBook(status types.RequestStatus) bool {
+	return status == types.RequestStatusRequested || status == types.RequestStatusCheckedOut
+}
+
+// isOpen returns true if a request in the status is waiting for, or holding, a copy of its book
+func isOpen(status types.RequestStatus) bool {
+	return status == types.RequestStatusWaiting || holdsBook(status)
+}
+
+// releasesBook returns true if a request moving between the statuses gives its book back to the library
+func releasesBook(from, to types.RequestStatus) bool {
+	return holdsBook(from) && !holdsBook(to)
+}
+
+// summarizeCopies sets the availability of the book from its copies. A book is available when at least
+// one copy is free and was last requested when its most recently requested copy was.
+func summarizeCopies(book *types.Book) {
+	book.Available = false
+	book.TimeRequested = ""
+
+	var latest time.Time
+	for _, c := range book.Copies {
+		if c.Available {
+			book.Available = true
+		}
+
+		requestedAt, err := time.Parse(time.RFC3339, c.TimeRequested)
+		if err == nil && requestedAt.After(latest) {
+			latest = requestedAt
+			book.TimeRequested = c.TimeRequested
+		}
+	}
+}
+
+// copiesToCreate returns the copies to create for a new book, skipping nil copies. A book without copies
+// gets a single copy with the availability of the book.
+func copiesToCreate(book *types.Book) []*types.Copy {
+	var copies []*types.Copy
+	for _, c := range book.Copies {
+		if c != nil {
+			copies = append(copies, c)
+		}
+	}
+
+	if len(copies) > 0 {
+		return copies
+	}
+
+	return []*types.Copy{{Available: book.Available}}
+}
+
+// LoanPolicy controls how long a copy is lent for and how often a loan may be renewed
+type LoanPolicy struct {
+	// LoanDuration is how long a request may keep its copy before it is due back
+	LoanDuration time.Duration
+	// MaxRenewals is how many times a loan may be renewed
+	MaxRenewals int
+}
+
+// DefaultLoanPolicy lends copies for two weeks and allows two renewals
+var DefaultLoanPolicy = LoanPolicy{
+	LoanDuration: 14 * 24 * time.Hour,
+	MaxRenewals:  2,
+}
+
+// Validate returns an error if the loan policy cannot be used
+func (p LoanPolicy) Validate() error {
+	if p.LoanDuration <= 0 {
+		return errors.New("loan duration must be positive")
+	}
+
+	if p.MaxRenewals < 0 {
+		return errors.New("max renewals can not be negative")
+	}
+
+	return nil
+}
+
+// dueDate returns when a copy lent at the given time is due back
+func (p LoanPolicy) dueDate(lentAt time.Time) time.Time {
+	return lentAt.Add(p.LoanDuration).UTC()
+}
+
+// renew checks that the request may be renewed and returns its new due date. Loans are extended by a
+// full loan period from the later of the current due date and now, so an overdue loan is due a full
+// period after being renewed. Requests for books with a waitlist cannot be renewed.
+func (p LoanPolicy) renew(request *types.Request, numWaiting int, now time.Time) (time.Time, error) {
+	if !holdsBook(request.Status) || request.DueAt == nil {
+		return time.Time{}, ErrInvalidTransition
+	}
+
+	if request.Renewals >= p.MaxRenewals {
+		return time.Time{}, ErrRenewalLimit
+	}
+
+	if numWaiting > 0 {
+		return time.Time{}, ErrHoldQueue
+	}
+
+	from := *request.DueAt
+	if now.After(from) {
+		from = now
+	}
+
+	return p.dueDate(from), nil
+}
@@ -0,0 +1,939 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// MemoryStore is a thread safe in-memory library store. It has the same semantics as
+// the SQLStore and is intended for local development and tests where postgres is not available.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	// books are stored without their copies, which are attached when read
+	books         map[int]*types.Book
+	copies        map[int]*types.Copy
+	requests      map[int]*types.Request
+	patrons       map[int]*types.Patron
+	apiKeys       map[int]*types.APIKey
+	nextBookID    int
+	nextCopyID    int
+	nextRequestID int
+	nextPatronID  int
+	nextAPIKeyID  int
+
+	loanPolicy LoanPolicy
+	policies   []Policy
+}
+
+// NewMemoryStore creates a new empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		books:         map[int]*types.Book{},
+		copies:        map[int]*types.Copy{},
+		requests:      map[int]*types.Request{},
+		patrons:       map[int]*types.Patron{},
+		apiKeys:       map[int]*types.APIKey{},
+		nextBookID:    1,
+		nextCopyID:    1,
+		nextRequestID: 1,
+		nextPatronID:  1,
+		nextAPIKeyID:  1,
+		loanPolicy:    DefaultLoanPolicy,
+	}
+}
+
+// SetLoanPolicy sets the policy used for new loans and renewals. It defaults to DefaultLoanPolicy.
+func (s *MemoryStore) SetLoanPolicy(policy LoanPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loanPolicy = policy
+}
+
+// SetPolicies sets the borrowing policies checked when a request is created. There are none by default.
+func (s *MemoryStore) SetPolicies(policies ...Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies = policies
+}
+
+// ListRequest returns a page of the requests matching the query, including closed requests. Queries
+// with an invalid limit, sort or cursor return an error wrapping ErrInvalidQuery.
+func (s *MemoryStore) ListRequest(ctx context.Context, query *RequestQuery) (*RequestPage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	page, err := parsePage(query.Page, requestSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []*types.Request{}
+	for _, request := range s.requests {
+		if !query.matches(request) {
+			continue
+		}
+
+		if page.cursor != nil && !page.cursor.after(requestSortValue(request, page.order.field), request.ID, page.order.descending) {
+			continue
+		}
+
+		r := *request
+		requests = append(requests, &r)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return sortsBefore(requestSortValue(requests[i], page.order.field), requests[i].ID,
+			requestSortValue(requests[j], page.order.field), requests[j].ID, page.order.descending)
+	})
+
+	result := &RequestPage{Requests: requests}
+	if len(requests) > page.limit {
+		result.Requests = requests[:page.limit]
+		last := result.Requests[page.limit-1]
+		result.NextCursor = page.nextCursor(requestSortValue(last, page.order.field), last.ID)
+	}
+
+	return result, nil
+}
+
+// GetRequest returns the specific request
+func (s *MemoryStore) GetRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[requestID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	r := *request
+	return &r, nil
+}
+
+// DeleteRequest closes the request and gives its copy of the book to the next waiting request, or makes
+// the copy available if nobody is waiting. The request is kept for history: waiting requests and requests
+// that were never picked up are cancelled and checked out requests are returned. It returns the closed
+// request. Closing an already closed request returns ErrInvalidTransition.
+func (s *MemoryStore) DeleteRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	return s.transitionRequest(requestID, closingStatus)
+}
+
+// UpdateRequestStatus moves the request to the given status following the request state machine. When a
+// request holding a copy is returned or cancelled the copy is given to the next waiting request.
+func (s *MemoryStore) UpdateRequestStatus(ctx context.Context, requestID int, status types.RequestStatus) (*types.Request, error) {
+	return s.transitionRequest(requestID, func(from types.RequestStatus) (types.RequestStatus, error) {
+		if err := checkTransition(from, status); err != nil {
+			return "", err
+		}
+
+		return status, nil
+	})
+}
+
+func (s *MemoryStore) transitionRequest(requestID int, next func(from types.RequestStatus) (types.RequestStatus, error)) (*types.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[requestID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	status, err := next(request.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	from := request.Status
+	request.Status = status
+	request.UpdatedAt = time.Now()
+
+	// The copy may be gone if it was removed from the catalog while lent out
+	if releasesBook(from, status) && request.CopyID != 0 {
+		s.releaseCopy(request.BookID, request.CopyID)
+	}
+
+	r := *request
+	return &r, nil
+}
+
+// CreateRequest checks if a copy of the book is available. If one is, then it updates the copy and creates
+// a new request bound to it. Otherwise, the request is added to the back of the book's waitlist and will be
+// granted a copy when one is freed. Requests refused by a borrowing policy return a *PolicyViolation. The
+// returned book reports whether a copy was available. Requests made with a CreatedAt keep it, so imported
+// requests keep when they were made. The ID, status, copy and timestamps of the created request are set on
+// request.
+func (s *MemoryStore) CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book := s.bookByTitle(request.Title)
+	if book == nil {
+		return nil, ErrNotFound
+	}
+
+	patron, err := s.requestingPatron(request)
+	if err != nil {
+		return nil, err
+	}
+
+	freeCopy := s.freeCopy(book.ID)
+
+	borrow := &BorrowRequest{
+		Patron: patron,
+		Book:   &types.Book{ID: book.ID, Title: book.Title},
+		Lend:   freeCopy != nil,
+	}
+
+	for _, r := range s.requests {
+		// New patrons have no requests yet
+		if patron.ID == 0 || r.PatronID != patron.ID {
+			continue
+		}
+
+		switch {
+		case holdsBook(r.Status):
+			borrow.Loans++
+		case r.Status == types.RequestStatusWaiting:
+			borrow.Holds++
+		}
+	}
+
+	if err := checkPolicies(s.policies, borrow); err != nil {
+		return nil, err
+	}
+
+	// New patrons are only registered once the request is allowed
+	if patron.ID == 0 {
+		patron = s.addPatron(patron)
+	}
+
+	now := time.Now()
+	createdAt := request.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+
+	created := &types.Request{
+		ID:        s.nextRequestID,
+		PatronID:  patron.ID,
+		Email:     patron.Email,
+		Title:     book.Title,
+		BookID:    book.ID,
+		Status:    types.RequestStatusWaiting,
+		CreatedAt: createdAt,
+		UpdatedAt: now,
+	}
+
+	timeRequested := ""
+	if freeCopy != nil {
+		dueAt := s.loanPolicy.dueDate(now)
+		created.Status = types.RequestStatusRequested
+		created.CopyID = freeCopy.ID
+		created.DueAt = &dueAt
+
+		timeRequested = now.Format(time.RFC3339)
+		freeCopy.Available = false
+		freeCopy.TimeRequested = timeRequested
+	}
+
+	s.requests[created.ID] = created
+	s.nextRequestID++
+
+	// Report whether the request got a copy rather than the state after taking it, matching the SQLStore
+	ret := s.bookView(book)
+	ret.Available = created.Status == types.RequestStatusRequested
+	ret.TimeRequested = timeRequested
+
+	*request = *created
+	return ret, nil
+}
+
+// RenewRequest extends the loan of a request holding a copy by another loan period. Loans that have been
+// renewed the maximum number of times return ErrRenewalLimit and loans of books that other requests are
+// waiting for return ErrHoldQueue.
+func (s *MemoryStore) RenewRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[requestID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	numWaiting := 0
+	for _, r := range s.requests {
+		if r.BookID == request.BookID && r.Status == types.RequestStatusWaiting {
+			numWaiting++
+		}
+	}
+
+	now := time.Now()
+	dueAt, err := s.loanPolicy.renew(request, numWaiting, now)
+	if err != nil {
+		return nil, err
+	}
+
+	request.DueAt = &dueAt
+	request.Renewals++
+	request.UpdatedAt = now
+
+	r := *request
+	return &r, nil
+}
+
+// ListOverdueRequests returns the requests holding a copy that was due back before asOf, most overdue first
+func (s *MemoryStore) ListOverdueRequests(ctx context.Context, asOf time.Time) ([]*types.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []*types.Request{}
+	for _, request := range s.requests {
+		if holdsBook(request.Status) && request.DueAt != nil && request.DueAt.Before(asOf) {
+			r := *request
+			requests = append(requests, &r)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].DueAt.Equal(*requests[j].DueAt) {
+			return requests[i].DueAt.Before(*requests[j].DueAt)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+
+	return requests, nil
+}
+
+// requestingPatron returns the patron making the request. Requests without a patron ID are made for the
+// patron with the request's email. If they are new the returned patron has no ID and has not been added
+// yet. Must be called with the lock held.
+func (s *MemoryStore) requestingPatron(request *types.Request) (*types.Patron, error) {
+	if request.PatronID != 0 {
+		patron, ok := s.patrons[request.PatronID]
+		if !ok {
+			return nil, ErrUnknownPatron
+		}
+		return patron, nil
+	}
+
+	email := normalizeEmail(request.Email)
+	if patron := s.patronByEmail(email); patron != nil {
+		return patron, nil
+	}
+
+	return &types.Patron{Email: email}, nil
+}
+
+// GetQueuePosition returns where the request is in the waitlist for its book
+func (s *MemoryStore) GetQueuePosition(ctx context.Context, requestID int) (*types.QueuePosition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[requestID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	position := &types.QueuePosition{
+		RequestID: request.ID,
+		Status:    request.Status,
+	}
+
+	if request.Status != types.RequestStatusWaiting {
+		return position, nil
+	}
+
+	for _, r := range s.requests {
+		if r.BookID == request.BookID && r.Status == types.RequestStatusWaiting && r.ID <= request.ID {
+			position.Position++
+		}
+	}
+
+	return position, nil
+}
+
+// releaseCopy hands the freed copy to the oldest waiting request for the book that the borrowing
+// policies allow, or makes it available if nobody is waiting. Must be called with the lock held.
+func (s *MemoryStore) releaseCopy(bookID, copyID int) {
+	c, ok := s.copies[copyID]
+	if !ok {
+		return
+	}
+
+	var waiting []*types.Request
+	for _, r := range s.requests {
+		if r.BookID == bookID && r.Status == types.RequestStatusWaiting {
+			waiting = append(waiting, r)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].ID < waiting[j].ID })
+
+	now := time.Now()
+	for _, next := range waiting {
+		switch s.promotionStatus(next) {
+		case types.RequestStatusCancelled:
+			next.Status = types.RequestStatusCancelled
+			next.UpdatedAt = now
+			continue
+		case types.RequestStatusWaiting:
+			continue
+		}
+
+		dueAt := s.loanPolicy.dueDate(now)
+		next.Status = types.RequestStatusRequested
+		next.CopyID = copyID
+		next.DueAt = &dueAt
+		next.UpdatedAt = now
+
+		c.Available = false
+		c.TimeRequested = now.Format(time.RFC3339)
+		return
+	}
+
+	c.Available = true
+	c.TimeRequested = ""
+}
+
+// promotionStatus checks the borrowing policies for lending a freed copy to the waiting request. Must be
+// called with the lock held.
+func (s *MemoryStore) promotionStatus(request *types.Request) types.RequestStatus {
+	borrow := &BorrowRequest{
+		Patron: s.patrons[request.PatronID],
+		Book:   &types.Book{ID: request.BookID, Title: request.Title},
+		Lend:   true,
+	}
+
+	for _, r := range s.requests {
+		if r.PatronID != request.PatronID || r.ID == request.ID {
+			continue
+		}
+
+		switch {
+		case holdsBook(r.Status):
+			borrow.Loans++
+		case r.Status == types.RequestStatusWaiting:
+			borrow.Holds++
+		}
+	}
+
+	return promotionStatus(s.policies, borrow)
+}
+
+// ListBook returns a page of the books matching the query and their copies. Queries with an invalid
+// limit, sort or cursor return an error wrapping ErrInvalidQuery.
+func (s *MemoryStore) ListBook(ctx context.Context, query *BookQuery) (*BookPage, error) {
+	page, err := parsePage(query.Page, bookSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	books := []*types.Book{}
+	for _, b := range s.books {
+		book := s.bookView(b)
+		if !query.matches(book) {
+			continue
+		}
+
+		if page.cursor != nil && !page.cursor.after(bookSortValue(book, page.order.field), book.ID, page.order.descending) {
+			continue
+		}
+
+		books = append(books, book)
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		return sortsBefore(bookSortValue(books[i], page.order.field), books[i].ID,
+			bookSortValue(books[j], page.order.field), books[j].ID, page.order.descending)
+	})
+
+	result := &BookPage{Books: books}
+	if len(books) > page.limit {
+		result.Books = books[:page.limit]
+		last := result.Books[page.limit-1]
+		result.NextCursor = page.nextCursor(bookSortValue(last, page.order.field), last.ID)
+	}
+
+	return result, nil
+}
+
+// GetBook returns the specific book and its copies
+func (s *MemoryStore) GetBook(ctx context.Context, bookID int) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[bookID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return s.bookView(book), nil
+}
+
+// CreateBook adds a new book and its copies to the catalog and returns it with the generated IDs. A book
+// created without any copies gets a single copy with the availability of the book. Titles and ISBNs must be
+// unique, adding a title or ISBN that already exists returns ErrAlreadyExists.
+func (s *MemoryStore) CreateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bookExists(book) {
+		return nil, ErrAlreadyExists
+	}
+
+	return s.bookView(s.addBook(book)), nil
+}
+
+// CreateBooks adds the books and their copies to the catalog. Either every book is added or, if any
+// title or ISBN is already in the catalog or repeated in the batch, none are and ErrAlreadyExists is returned.
+func (s *MemoryStore) CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	titles := map[string]bool{}
+	isbns := map[string]bool{}
+	for _, book := range books {
+		isbn := NormalizeISBN(book.ISBN)
+		if s.bookExists(book) || titles[book.Title] || (isbn != "" && isbns[isbn]) {
+			return nil, ErrAlreadyExists
+		}
+
+		titles[book.Title] = true
+		isbns[isbn] = true
+	}
+
+	created := make([]*types.Book, 0, len(books))
+	for _, book := range books {
+		created = append(created, s.bookView(s.addBook(book)))
+	}
+
+	return created, nil
+}
+
+// bookExists returns true if a book with the title or ISBN is in the catalog. Must be called with the lock held.
+func (s *MemoryStore) bookExists(book *types.Book) bool {
+	if s.bookByTitle(book.Title) != nil {
+		return true
+	}
+
+	isbn := NormalizeISBN(book.ISBN)
+	if isbn == "" {
+		return false
+	}
+
+	for _, existing := range s.books {
+		if existing.ISBN == isbn {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addBook adds a new book and its copies. Must be called with the lock held.
+func (s *MemoryStore) addBook(book *types.Book) *types.Book {
+	created := &types.Book{
+		ID:    s.nextBookID,
+		Title: book.Title,
+		ISBN:  NormalizeISBN(book.ISBN),
+	}
+	s.books[created.ID] = created
+	s.nextBookID++
+
+	for _, c := range copiesToCreate(book) {
+		s.addCopy(created.ID, c.Available)
+	}
+
+	return created
+}
+
+// UpdateBook updates the title of an existing book and of its requests, and its ISBN if one is given.
+// Availability is tracked per copy so it is not updated.
+func (s *MemoryStore) UpdateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.books[book.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if other := s.bookByTitle(book.Title); other != nil && other.ID != book.ID {
+		return nil, ErrAlreadyExists
+	}
+
+	isbn := NormalizeISBN(book.ISBN)
+	for _, other := range s.books {
+		if isbn != "" && other.ISBN == isbn && other.ID != book.ID {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	existing.Title = book.Title
+	if isbn != "" {
+		existing.ISBN = isbn
+	}
+
+	for _, r := range s.requests {
+		if r.BookID == book.ID {
+			r.Title = book.Title
+		}
+	}
+
+	return s.bookView(existing), nil
+}
+
+// DeleteBook removes the book and its copies from the catalog. Books with open requests, including
+// waiting requests, cannot be deleted and return ErrInUse.
+func (s *MemoryStore) DeleteBook(ctx context.Context, bookID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[bookID]; !ok {
+		return ErrNotFound
+	}
+
+	for _, r := range s.requests {
+		if r.BookID == bookID && isOpen(r.Status) {
+			return ErrInUse
+		}
+	}
+
+	for id, c := range s.copies {
+		if c.BookID == bookID {
+			delete(s.copies, id)
+		}
+	}
+
+	// Closed requests are kept for history but no longer point at the book
+	for _, r := range s.requests {
+		if r.BookID == bookID {
+			r.BookID = 0
+			r.CopyID = 0
+		}
+	}
+
+	delete(s.books, bookID)
+
+	return nil
+}
+
+// AddCopy adds a new copy of the book to the catalog. If somebody is waiting for the book the new copy
+// is given to them straight away.
+func (s *MemoryStore) AddCopy(ctx context.Context, bookID int, c *types.Copy) (*types.Copy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[bookID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	created := s.addCopy(bookID, false)
+
+	// A copy added as available is released like a returned copy so it goes to the waitlist first
+	if c.Available {
+		s.releaseCopy(bookID, created.ID)
+	}
+
+	ret := *created
+	return &ret, nil
+}
+
+// DeleteCopy removes a copy of the book from the catalog. Copies that are lent out return ErrInUse.
+func (s *MemoryStore) DeleteCopy(ctx context.Context, bookID, copyID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.copies[copyID]
+	if !ok || c.BookID != bookID {
+		return ErrNotFound
+	}
+
+	for _, r := range s.requests {
+		if r.CopyID == copyID && holdsBook(r.Status) {
+			return ErrInUse
+		}
+	}
+
+	for _, r := range s.requests {
+		if r.CopyID == copyID {
+			r.CopyID = 0
+		}
+	}
+
+	delete(s.copies, copyID)
+
+	return nil
+}
+
+// addCopy adds a copy of the book. Must be called with the lock held.
+func (s *MemoryStore) addCopy(bookID int, available bool) *types.Copy {
+	c := &types.Copy{
+		ID:        s.nextCopyID,
+		BookID:    bookID,
+		Available: available,
+	}
+	s.copies[c.ID] = c
+	s.nextCopyID++
+
+	return c
+}
+
+// freeCopy returns the available copy of the book with the lowest ID. Must be called with the lock held.
+func (s *MemoryStore) freeCopy(bookID int) *types.Copy {
+	var found *types.Copy
+	for _, c := range s.copies {
+		if c.BookID == bookID && c.Available && (found == nil || c.ID < found.ID) {
+			found = c
+		}
+	}
+
+	return found
+}
+
+// bookView returns a copy of the book with its copies attached. Must be called with the lock held.
+func (s *MemoryStore) bookView(book *types.Book) *types.Book {
+	b := &types.Book{
+		ID:    book.ID,
+		Title: book.Title,
+		ISBN:  book.ISBN,
+	}
+
+	for _, c := range s.copies {
+		if c.BookID == book.ID {
+			cp := *c
+			b.Copies = append(b.Copies, &cp)
+		}
+	}
+
+	sort.Slice(b.Copies, func(i, j int) bool { return b.Copies[i].ID < b.Copies[j].ID })
+	summarizeCopies(b)
+
+	return b
+}
+
+// bookByTitle returns the book with the title. Must be called with the lock held.
+func (s *MemoryStore) bookByTitle(title string) *types.Book {
+	for _, book := range s.books {
+		if book.Title == title {
+			return book
+		}
+	}
+
+	return nil
+}
+
+// CreatePatron registers a new patron. Emails are unique ignoring case, registering an email that
+// already belongs to a patron returns ErrAlreadyExists.
+func (s *MemoryStore) CreatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := normalizeEmail(patron.Email)
+	if s.patronByEmail(email) != nil {
+		return nil, ErrAlreadyExists
+	}
+
+	created := s.addPatron(&types.Patron{Email: email, Name: patron.Name})
+
+	p := *created
+	return &p, nil
+}
+
+// GetPatron returns the specific patron
+func (s *MemoryStore) GetPatron(ctx context.Context, patronID int) (*types.Patron, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	patron, ok := s.patrons[patronID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	p := *patron
+	return &p, nil
+}
+
+// ListPatron returns all patrons ordered by ID
+func (s *MemoryStore) ListPatron(ctx context.Context) ([]*types.Patron, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	patrons := []*types.Patron{}
+	for _, patron := range s.patrons {
+		p := *patron
+		patrons = append(patrons, &p)
+	}
+
+	sort.Slice(patrons, func(i, j int) bool { return patrons[i].ID < patrons[j].ID })
+
+	return patrons, nil
+}
+
+// UpdatePatron updates the email and name of an existing patron. The patron's requests are updated
+// to the new email. Changing to an email that belongs to another patron returns ErrAlreadyExists.
+func (s *MemoryStore) UpdatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.patrons[patron.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	email := normalizeEmail(patron.Email)
+	if other := s.patronByEmail(email); other != nil && other.ID != patron.ID {
+		return nil, ErrAlreadyExists
+	}
+
+	existing.Email = email
+	existing.Name = patron.Name
+
+	for _, r := range s.requests {
+		if r.PatronID == patron.ID {
+			r.Email = email
+		}
+	}
+
+	p := *existing
+	return &p, nil
+}
+
+// ListPatronRequests returns the patron's current requests, those waiting for or holding a copy,
+// ordered by ID
+func (s *MemoryStore) ListPatronRequests(ctx context.Context, patronID int) ([]*types.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.patrons[patronID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	requests := []*types.Request{}
+	for _, request := range s.requests {
+		if request.PatronID == patronID && isOpen(request.Status) {
+			r := *request
+			requests = append(requests, &r)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].ID < requests[j].ID })
+
+	return requests, nil
+}
+
+// addPatron adds a patron whose email is already normalized. Must be called with the lock held.
+func (s *MemoryStore) addPatron(patron *types.Patron) *types.Patron {
+	created := &types.Patron{
+		ID:        s.nextPatronID,
+		Email:     patron.Email,
+		Name:      patron.Name,
+		CreatedAt: time.Now(),
+	}
+	s.patrons[created.ID] = created
+	s.nextPatronID++
+
+	return created
+}
+
+// patronByEmail returns the patron with the normalized email. Must be called with the lock held.
+func (s *MemoryStore) patronByEmail(email string) *types.Patron {
+	for _, patron := range s.patrons {
+		if patron.Email == email {
+			return patron
+		}
+	}
+
+	return nil
+}
+
+// CreateAPIKey stores a new API key by its hash. Keys for patrons that do not exist return ErrUnknownPatron.
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key.PatronID != 0 {
+		if _, ok := s.patrons[key.PatronID]; !ok {
+			return nil, ErrUnknownPatron
+		}
+	}
+
+	for _, existing := range s.apiKeys {
+		if existing.Hash == key.Hash {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	created := &types.APIKey{
+		ID:        s.nextAPIKeyID,
+		Name:      key.Name,
+		PatronID:  key.PatronID,
+		Role:      key.Role,
+		Hash:      key.Hash,
+		CreatedAt: time.Now(),
+	}
+	s.apiKeys[created.ID] = created
+	s.nextAPIKeyID++
+
+	k := *created
+	return &k, nil
+}
+
+// GetAPIKeyByHash returns the API key with the hash
+func (s *MemoryStore) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.apiKeys {
+		if key.Hash == hash {
+			k := *key
+			return &k, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// ListAPIKeys returns all API keys ordered by ID
+func (s *MemoryStore) ListAPIKeys(ctx context.Context) ([]*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []*types.APIKey{}
+	for _, key := range s.apiKeys {
+		k := *key
+		keys = append(keys, &k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes the API key
+func (s *MemoryStore) DeleteAPIKey(ctx context.Context, keyID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[keyID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.apiKeys, keyID)
+
+	return nil
+}
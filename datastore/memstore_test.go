@@ -0,0 +1,15 @@
+package datastore_test
+
+import (
+	"testing"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/datastore/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) apiserver.LibraryStore {
+		return datastore.NewMemoryStore()
+	})
+}
@@ -0,0 +1,296 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+)
+
+// migrationLockID is the postgres advisory lock key held while migrating so that
+// multiple replicas starting at the same time do not run migrations concurrently
+const migrationLockID = 7424611
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFileRegex matches migration file names such as 0001_create_books.up.sql
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the embedded schema migrations
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+// NewMigrator creates a migrator for the embedded migrations
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all pending migrations in order and returns the number applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	numApplied := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			log.G(ctx).Infof("applying migration %d_%s", migration.Version, migration.Name)
+			if err := runMigration(ctx, conn, migration.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name); err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d_%s", migration.Version, migration.Name)
+			}
+			numApplied++
+		}
+
+		return nil
+	})
+
+	return numApplied, err
+}
+
+// Down rolls back the most recently applied migrations, at most steps of them, and
+// returns the number rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	numRolledBack := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && numRolledBack < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			log.G(ctx).Infof("rolling back migration %d_%s", migration.Version, migration.Name)
+			if err := runMigration(ctx, conn, migration.Down,
+				"DELETE FROM schema_migrations WHERE version=$1", migration.Version); err != nil {
+				return errors.Wrapf(err, "failed to roll back migration %d_%s", migration.Version, migration.Name)
+			}
+			numRolledBack++
+		}
+
+		return nil
+	})
+
+	return numRolledBack, err
+}
+
+// Status returns the status of every known migration in order. It only reads, unlike Up and Down it
+// doesn't create the schema_migrations table, so it is cheap enough to call from readiness checks. Every
+// migration is pending if the table doesn't exist yet.
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var table sql.NullString
+	if err := m.db.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations')::text").Scan(&table); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	if table.Valid {
+		var err error
+		if applied, err = appliedVersions(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []*MigrationStatus
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, &MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// withLock runs fn on a single connection while holding the migration advisory lock.
+// Advisory locks belong to a session, so everything must run on the same connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return errors.Errorf("failed to acquire migration lock with error: %v", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+				log.G(ctx).Errorf("failed to release migration lock with error: %v", err)
+			}
+		}()
+
+		return fn(conn)
+	})
+}
+
+// withConn runs fn on a single connection after making sure the schema_migrations table exists
+func (m *Migrator) withConn(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	const qry = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			appliedAt TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`
+
+	if _, err := conn.ExecContext(ctx, qry); err != nil {
+		return errors.Errorf("failed to create schema_migrations table with error: %v", err)
+	}
+
+	return fn(conn)
+}
+
+// runMigration runs the migration script and records it in schema_migrations within a single
+// transaction, so a failed migration leaves no partial changes behind
+func runMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedVersions returns the applied migration versions mapped to when they were applied
+func appliedVersions(ctx context.Context, q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// loadMigrations reads the up and down scripts from the migrations directory of fsys and
+// returns them ordered by version. Every version must have both an up and a down script.
+func loadMigrations(fsys fs.FS) ([]*Migration, error) {
+	files, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		matches := migrationFileRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			return nil, errors.Errorf("invalid migration file name: '%s'", file.Name())
+		}
+
+		version, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, errors.Errorf("invalid migration version in '%s': %v", file.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != matches[2] {
+			return nil, errors.Errorf("migration version %d has conflicting names '%s' and '%s'", version, migration.Name, matches[2])
+		}
+
+		switch matches[3] {
+		case "up":
+			migration.Up = string(contents)
+		case "down":
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, errors.Errorf("migration %d_%s must have both an up and a down script", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
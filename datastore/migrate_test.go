@@ -0,0 +1,69 @@
+package datastore
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("Embedded Migrations", func(t *testing.T) {
+		migrations, err := loadMigrations(migrationFiles)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+
+		for i, migration := range migrations {
+			assert.NotEmpty(t, migration.Up, "Migration should have an up script.")
+			assert.NotEmpty(t, migration.Down, "Migration should have a down script.")
+			if i > 0 {
+				assert.Greater(t, migration.Version, migrations[i-1].Version, "Migrations should be ordered by version.")
+			}
+		}
+	})
+
+	t.Run("Orders By Version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/0010_second.up.sql":   {Data: []byte("up2")},
+			"migrations/0010_second.down.sql": {Data: []byte("down2")},
+			"migrations/0002_first.up.sql":    {Data: []byte("up1")},
+			"migrations/0002_first.down.sql":  {Data: []byte("down1")},
+		}
+
+		migrations, err := loadMigrations(fsys)
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+
+		assert.Equal(t, &Migration{Version: 2, Name: "first", Up: "up1", Down: "down1"}, migrations[0])
+		assert.Equal(t, &Migration{Version: 10, Name: "second", Up: "up2", Down: "down2"}, migrations[1])
+	})
+
+	t.Run("Missing Down Script", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/0001_first.up.sql": {Data: []byte("up")},
+		}
+
+		_, err := loadMigrations(fsys)
+		assert.Error(t, err, "Should require a down script.")
+	})
+
+	t.Run("Conflicting Names", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/0001_first.up.sql":   {Data: []byte("up")},
+			"migrations/0001_other.down.sql": {Data: []byte("down")},
+		}
+
+		_, err := loadMigrations(fsys)
+		assert.Error(t, err, "Should reject two migrations with the same version.")
+	})
+
+	t.Run("Invalid File Name", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/first.sql": {Data: []byte("up")},
+		}
+
+		_, err := loadMigrations(fsys)
+		assert.Error(t, err, "Should reject files that are not versioned migrations.")
+	})
+}
@@ -0,0 +1,14 @@
+-- The tables may already exist on databases created before versioned migrations
+-- were introduced, so this baseline migration must be safe to run against them.
+CREATE TABLE IF NOT EXISTS books (
+	id SERIAL PRIMARY KEY,
+	available BOOLEAN NOT NULL,
+	title TEXT NOT NULL,
+	timeRequested TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS requests (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL,
+	title TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Before statuses existed every request row held its book, so closed requests must be removed
+DELETE FROM requests WHERE status IN ('returned', 'cancelled');
+
+ALTER TABLE requests
+	DROP COLUMN status,
+	DROP COLUMN createdAt,
+	DROP COLUMN updatedAt;
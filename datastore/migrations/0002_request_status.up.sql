@@ -0,0 +1,5 @@
+ALTER TABLE requests
+	ADD COLUMN status TEXT NOT NULL DEFAULT 'requested'
+		CONSTRAINT requests_status_check CHECK (status IN ('requested', 'checked_out', 'returned', 'cancelled')),
+	ADD COLUMN createdAt TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN updatedAt TIMESTAMPTZ NOT NULL DEFAULT now();
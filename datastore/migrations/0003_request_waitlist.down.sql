@@ -0,0 +1,8 @@
+-- Without a waitlist there is nothing to hand the book to, so drop everybody out of the queue
+UPDATE requests SET status = 'cancelled', updatedAt = now() WHERE status = 'waiting';
+
+DROP INDEX requests_waitlist_idx;
+
+ALTER TABLE requests DROP CONSTRAINT requests_status_check;
+ALTER TABLE requests ADD CONSTRAINT requests_status_check
+	CHECK (status IN ('requested', 'checked_out', 'returned', 'cancelled'));
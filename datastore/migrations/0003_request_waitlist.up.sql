@@ -0,0 +1,6 @@
+ALTER TABLE requests DROP CONSTRAINT requests_status_check;
+ALTER TABLE requests ADD CONSTRAINT requests_status_check
+	CHECK (status IN ('waiting', 'requested', 'checked_out', 'returned', 'cancelled'));
+
+-- Supports finding the next request in a book's waitlist and queue positions
+CREATE INDEX requests_waitlist_idx ON requests (title, id) WHERE status = 'waiting';
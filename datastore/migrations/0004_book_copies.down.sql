@@ -0,0 +1,31 @@
+ALTER TABLE books
+	DROP CONSTRAINT books_title_key,
+	ADD COLUMN available BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN timeRequested TEXT NOT NULL DEFAULT '';
+
+-- The first copy of each book goes back on the book row. Books without copies stay unavailable.
+UPDATE books b SET available = c.available, timeRequested = c.timeRequested
+FROM (
+	SELECT DISTINCT ON (bookId) bookId, available, timeRequested
+	FROM copies
+	ORDER BY bookId, id
+) c
+WHERE c.bookId = b.id;
+
+-- Every other copy becomes a duplicate book row with the same title
+INSERT INTO books (available, title, timeRequested)
+SELECT c.available, b.title, c.timeRequested
+FROM copies c JOIN books b ON b.id = c.bookId
+WHERE c.id NOT IN (SELECT min(id) FROM copies GROUP BY bookId)
+ORDER BY c.id;
+
+ALTER TABLE books ALTER COLUMN available DROP DEFAULT;
+
+DROP INDEX requests_waitlist_idx;
+CREATE INDEX requests_waitlist_idx ON requests (title, id) WHERE status = 'waiting';
+
+ALTER TABLE requests
+	DROP COLUMN copyId,
+	DROP COLUMN bookId;
+
+DROP TABLE copies;
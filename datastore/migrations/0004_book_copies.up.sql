@@ -0,0 +1,43 @@
+-- Books become titles in the catalog, each with one or more physical copies
+CREATE TABLE copies (
+	id SERIAL PRIMARY KEY,
+	bookId INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+	available BOOLEAN NOT NULL,
+	timeRequested TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX copies_bookId_idx ON copies (bookId);
+
+-- Every existing book row becomes a copy of the first book row with the same title
+INSERT INTO copies (bookId, available, timeRequested)
+SELECT (SELECT min(b2.id) FROM books b2 WHERE b2.title = b.title), b.available, b.timeRequested
+FROM books b
+ORDER BY b.id;
+
+ALTER TABLE requests
+	ADD COLUMN bookId INTEGER REFERENCES books (id) ON DELETE SET NULL,
+	ADD COLUMN copyId INTEGER REFERENCES copies (id) ON DELETE SET NULL;
+
+UPDATE requests r SET bookId = (SELECT min(b.id) FROM books b WHERE b.title = r.title);
+
+-- Bind each request holding a book to a distinct unavailable copy of its book
+UPDATE requests r SET copyId = c.id
+FROM (
+	SELECT id, bookId, row_number() OVER (PARTITION BY bookId ORDER BY id) AS n
+	FROM copies WHERE NOT available
+) c, (
+	SELECT id, bookId, row_number() OVER (PARTITION BY bookId ORDER BY id) AS n
+	FROM requests WHERE status IN ('requested', 'checked_out')
+) held
+WHERE held.id = r.id AND c.bookId = held.bookId AND c.n = held.n;
+
+-- Only the first row of each title is kept, the rest are now copies of it
+DELETE FROM books b WHERE b.id <> (SELECT min(b2.id) FROM books b2 WHERE b2.title = b.title);
+
+ALTER TABLE books
+	DROP COLUMN available,
+	DROP COLUMN timeRequested,
+	ADD CONSTRAINT books_title_key UNIQUE (title);
+
+DROP INDEX requests_waitlist_idx;
+CREATE INDEX requests_waitlist_idx ON requests (bookId, id) WHERE status = 'waiting';
@@ -0,0 +1,5 @@
+DROP INDEX requests_due_idx;
+
+ALTER TABLE requests
+	DROP COLUMN renewals,
+	DROP COLUMN dueAt;
@@ -0,0 +1,15 @@
+ALTER TABLE requests
+	ADD COLUMN dueAt TIMESTAMPTZ,
+	ADD COLUMN renewals INTEGER NOT NULL DEFAULT 0;
+
+-- Requests holding a copy are due two weeks after the copy was lent to them
+UPDATE requests r
+	SET dueAt = COALESCE(NULLIF(c.timeRequested, '')::timestamptz, r.createdAt) + interval '14 days'
+	FROM copies c
+	WHERE c.id = r.copyId AND r.status IN ('requested', 'checked_out');
+
+UPDATE requests
+	SET dueAt = createdAt + interval '14 days'
+	WHERE dueAt IS NULL AND status IN ('requested', 'checked_out');
+
+CREATE INDEX requests_due_idx ON requests (dueAt) WHERE status IN ('requested', 'checked_out');
@@ -0,0 +1,5 @@
+DROP INDEX requests_patron_idx;
+
+ALTER TABLE requests DROP COLUMN patronId;
+
+DROP TABLE patrons;
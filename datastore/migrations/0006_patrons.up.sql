@@ -0,0 +1,21 @@
+CREATE TABLE patrons (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL DEFAULT '',
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Register a patron for every email that has made a request, merging emails that only differ by case
+INSERT INTO patrons (email)
+	SELECT DISTINCT lower(trim(email)) FROM requests;
+
+ALTER TABLE requests ADD COLUMN patronId INTEGER REFERENCES patrons (id);
+
+UPDATE requests r
+	SET patronId = p.id, email = p.email
+	FROM patrons p
+	WHERE p.email = lower(trim(r.email));
+
+ALTER TABLE requests ALTER COLUMN patronId SET NOT NULL;
+
+CREATE INDEX requests_patron_idx ON requests (patronId);
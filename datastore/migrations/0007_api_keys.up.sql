@@ -0,0 +1,7 @@
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	patronId INTEGER REFERENCES patrons (id) ON DELETE CASCADE,
+	hash TEXT NOT NULL UNIQUE,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT now()
+);
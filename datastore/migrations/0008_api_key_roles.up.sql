@@ -0,0 +1,7 @@
+-- Keys made before roles had full access. Keys bound to a patron become patron keys, the rest admin keys.
+ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT 'patron';
+
+UPDATE api_keys SET role = 'admin' WHERE patronId IS NULL;
+
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_check
+	CHECK (role IN ('patron', 'librarian', 'admin') AND (role <> 'patron' OR patronId IS NOT NULL));
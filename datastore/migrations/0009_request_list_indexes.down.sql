@@ -0,0 +1,2 @@
+DROP INDEX requests_created_idx;
+DROP INDEX requests_email_idx;
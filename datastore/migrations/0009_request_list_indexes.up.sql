@@ -0,0 +1,3 @@
+-- Support filtering request listings by email and paging through them by creation time
+CREATE INDEX requests_email_idx ON requests (email);
+CREATE INDEX requests_created_idx ON requests (createdAt, id);
@@ -0,0 +1,3 @@
+-- Books may have an ISBN, which identifies them like their title when importing the catalog
+ALTER TABLE books ADD COLUMN isbn TEXT;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn) WHERE isbn IS NOT NULL;
@@ -0,0 +1,9 @@
+package datastore
+
+import "strings"
+
+// normalizeEmail returns the form emails are stored and compared in, so the same address with
+// different capitalization or surrounding whitespace belongs to one patron
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,246 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+var (
+	// ErrLimitExceeded the patron has reached a borrowing limit. The request may be allowed once the
+	// patron returns or cancels other requests.
+	ErrLimitExceeded = errors.New("borrowing limit exceeded")
+
+	// ErrRestricted the patron is not allowed to make the request
+	ErrRestricted = errors.New("borrowing restricted")
+)
+
+// PolicyViolation is returned by CreateRequest when a borrowing policy refuses the request. It wraps
+// ErrLimitExceeded or ErrRestricted.
+type PolicyViolation struct {
+	// Rule is the name of the policy that refused the request
+	Rule string
+	// Reason explains why the request was refused
+	Reason string
+	// Kind is ErrLimitExceeded or ErrRestricted
+	Kind error
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("request refused by policy '%s': %s", v.Rule, v.Reason)
+}
+
+// Unwrap returns the kind of violation so it can be checked with errors.Is
+func (v *PolicyViolation) Unwrap() error {
+	return v.Kind
+}
+
+// BorrowRequest is a request being created, along with what the store knows about the patron
+// making it, for borrowing policies to evaluate
+type BorrowRequest struct {
+	Patron *types.Patron
+	Book   *types.Book
+	// Lend is true if a copy is free and will be lent to the request, false if the request will wait
+	Lend bool
+	// Loans is the number of copies the patron currently holds
+	Loans int
+	// Holds is the number of the patron's requests that are waiting for a copy
+	Holds int
+}
+
+// Policy is a borrowing rule evaluated by the store when a request is created
+type Policy interface {
+	// Check returns a *PolicyViolation if the request is not allowed
+	Check(request *BorrowRequest) error
+}
+
+// checkPolicies returns the first violation of the policies, in order
+func checkPolicies(policies []Policy, request *BorrowRequest) error {
+	for _, policy := range policies {
+		if err := policy.Check(request); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// promotionStatus checks the policies before a waiting request is lent a freed copy. It returns requested
+// if the loan is allowed, cancelled if the patron is restricted from borrowing the book, or waiting if
+// the patron is over a limit, so the request keeps its place and can be lent a later copy.
+func promotionStatus(policies []Policy, request *BorrowRequest) types.RequestStatus {
+	switch err := checkPolicies(policies, request); {
+	case err == nil:
+		return types.RequestStatusRequested
+	case errors.Is(err, ErrRestricted):
+		return types.RequestStatusCancelled
+	default:
+		return types.RequestStatusWaiting
+	}
+}
+
+// MaxLoansPolicy limits how many copies a patron may hold at once
+type MaxLoansPolicy struct {
+	Limit int
+}
+
+// Check refuses requests that would lend the patron more than the limit
+func (p MaxLoansPolicy) Check(request *BorrowRequest) error {
+	if request.Lend && request.Loans >= p.Limit {
+		return &PolicyViolation{
+			Rule:   "max-loans",
+			Reason: fmt.Sprintf("patron already holds %d copies, the limit is %d", request.Loans, p.Limit),
+			Kind:   ErrLimitExceeded,
+		}
+	}
+
+	return nil
+}
+
+// MaxHoldsPolicy limits how many requests a patron may have waiting at once
+type MaxHoldsPolicy struct {
+	Limit int
+}
+
+// Check refuses requests that would give the patron more waiting requests than the limit
+func (p MaxHoldsPolicy) Check(request *BorrowRequest) error {
+	if !request.Lend && request.Holds >= p.Limit {
+		return &PolicyViolation{
+			Rule:   "max-holds",
+			Reason: fmt.Sprintf("patron already has %d requests waiting, the limit is %d", request.Holds, p.Limit),
+			Kind:   ErrLimitExceeded,
+		}
+	}
+
+	return nil
+}
+
+// BlockedPatronsPolicy refuses all requests from the patrons with the emails
+type BlockedPatronsPolicy struct {
+	Emails []string
+}
+
+// Check refuses requests from blocked patrons
+func (p BlockedPatronsPolicy) Check(request *BorrowRequest) error {
+	for _, email := range p.Emails {
+		if normalizeEmail(email) == request.Patron.Email {
+			return &PolicyViolation{
+				Rule:   "blocked-patron",
+				Reason: "patron is blocked from borrowing",
+				Kind:   ErrRestricted,
+			}
+		}
+	}
+
+	return nil
+}
+
+// TitleRestriction only lets the allowed patrons request the title. A restriction without allowed
+// patrons makes the title unavailable to everybody, such as for reference only books.
+type TitleRestriction struct {
+	Title          string   `json:"title"`
+	Reason         string   `json:"reason"`
+	AllowedPatrons []string `json:"allowedPatrons"`
+}
+
+// Check refuses requests for the title from patrons that are not allowed
+func (r TitleRestriction) Check(request *BorrowRequest) error {
+	if request.Book.Title != r.Title {
+		return nil
+	}
+
+	for _, email := range r.AllowedPatrons {
+		if normalizeEmail(email) == request.Patron.Email {
+			return nil
+		}
+	}
+
+	reason := r.Reason
+	if reason == "" {
+		reason = "title is restricted"
+	}
+
+	return &PolicyViolation{
+		Rule:   "title-restriction",
+		Reason: fmt.Sprintf("'%s': %s", r.Title, reason),
+		Kind:   ErrRestricted,
+	}
+}
+
+// PolicyConfig is the borrowing policy file. Limits of 0 are not enforced.
+type PolicyConfig struct {
+	MaxLoans          int                `json:"maxLoans"`
+	MaxHolds          int                `json:"maxHolds"`
+	BlockedPatrons    []string           `json:"blockedPatrons"`
+	TitleRestrictions []TitleRestriction `json:"titleRestrictions"`
+}
+
+// LoadPolicyConfig reads and validates the JSON policy file at path
+func LoadPolicyConfig(path string) (*PolicyConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &PolicyConfig{}
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse policy file '%s'", path)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid policy file '%s'", path)
+	}
+
+	return config, nil
+}
+
+// Validate returns an error if the policy config cannot be used
+func (c *PolicyConfig) Validate() error {
+	if c.MaxLoans < 0 {
+		return errors.New("maxLoans can not be negative")
+	}
+
+	if c.MaxHolds < 0 {
+		return errors.New("maxHolds can not be negative")
+	}
+
+	for i, restriction := range c.TitleRestrictions {
+		if restriction.Title == "" {
+			return errors.Errorf("titleRestrictions[%d] must have a title", i)
+		}
+	}
+
+	return nil
+}
+
+// Policies returns the policies in the config. Restrictions are checked before limits so patrons are
+// not told to return books before making a request that would be refused anyway.
+func (c *PolicyConfig) Policies() []Policy {
+	var policies []Policy
+
+	if len(c.BlockedPatrons) > 0 {
+		policies = append(policies, BlockedPatronsPolicy{Emails: c.BlockedPatrons})
+	}
+
+	for _, restriction := range c.TitleRestrictions {
+		policies = append(policies, restriction)
+	}
+
+	if c.MaxLoans > 0 {
+		policies = append(policies, MaxLoansPolicy{Limit: c.MaxLoans})
+	}
+
+	if c.MaxHolds > 0 {
+		policies = append(policies, MaxHoldsPolicy{Limit: c.MaxHolds})
+	}
+
+	return policies
+}
@@ -0,0 +1,147 @@
+package datastore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestPolicies(t *testing.T) {
+	patron := &types.Patron{ID: 1, Email: "test@gmail.com"}
+	book := &types.Book{ID: 1, Title: "testTitle"}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		request *BorrowRequest
+		kind    error
+	}{
+		{
+			name:    "Under Loan Limit",
+			policy:  MaxLoansPolicy{Limit: 2},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true, Loans: 1},
+		},
+		{
+			name:    "At Loan Limit",
+			policy:  MaxLoansPolicy{Limit: 2},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true, Loans: 2},
+			kind:    ErrLimitExceeded,
+		},
+		{
+			name:    "Loan Limit Ignores Holds",
+			policy:  MaxLoansPolicy{Limit: 2},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: false, Loans: 2},
+		},
+		{
+			name:    "At Hold Limit",
+			policy:  MaxHoldsPolicy{Limit: 1},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: false, Holds: 1},
+			kind:    ErrLimitExceeded,
+		},
+		{
+			name:    "Hold Limit Ignores Loans",
+			policy:  MaxHoldsPolicy{Limit: 1},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true, Holds: 1},
+		},
+		{
+			name:    "Blocked Patron",
+			policy:  BlockedPatronsPolicy{Emails: []string{"Test@Gmail.com"}},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true},
+			kind:    ErrRestricted,
+		},
+		{
+			name:    "Restricted Title",
+			policy:  TitleRestriction{Title: "testTitle", Reason: "reference only"},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true},
+			kind:    ErrRestricted,
+		},
+		{
+			name:    "Allowed Patron",
+			policy:  TitleRestriction{Title: "testTitle", AllowedPatrons: []string{"test@gmail.com"}},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true},
+		},
+		{
+			name:    "Other Title",
+			policy:  TitleRestriction{Title: "otherTitle"},
+			request: &BorrowRequest{Patron: patron, Book: book, Lend: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Check(test.request)
+			if test.kind == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var violation *PolicyViolation
+			require.True(t, errors.As(err, &violation), "Should return a policy violation.")
+			assert.True(t, errors.Is(err, test.kind), "Should be a '%v' violation.", test.kind)
+			assert.NotEmpty(t, violation.Rule)
+			assert.NotEmpty(t, violation.Reason)
+		})
+	}
+}
+
+func TestLoadPolicyConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, contents string) string {
+		dir, err := ioutil.TempDir("", "policy")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		path := filepath.Join(dir, "policy.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+
+		return path
+	}
+
+	t.Run("Success Case", func(t *testing.T) {
+		path := writeConfig(t, `{
+			"maxLoans": 5,
+			"maxHolds": 3,
+			"blockedPatrons": ["blocked@gmail.com"],
+			"titleRestrictions": [{"title": "rare", "reason": "reference only"}]
+		}`)
+
+		config, err := LoadPolicyConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, 5, config.MaxLoans)
+		assert.Equal(t, 3, config.MaxHolds)
+
+		policies := config.Policies()
+		require.Len(t, policies, 4)
+		assert.IsType(t, BlockedPatronsPolicy{}, policies[0], "Should check restrictions before limits.")
+		assert.IsType(t, TitleRestriction{}, policies[1])
+	})
+
+	t.Run("Limits Of Zero Are Not Enforced", func(t *testing.T) {
+		config, err := LoadPolicyConfig(writeConfig(t, `{}`))
+		require.NoError(t, err)
+		assert.Empty(t, config.Policies())
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		for _, contents := range []string{
+			`{"maxLoans": -1}`,
+			`{"titleRestrictions": [{"reason": "no title"}]}`,
+			`{"maxLoan": 5}`,
+			`not json`,
+		} {
+			_, err := LoadPolicyConfig(writeConfig(t, contents))
+			assert.Error(t, err, "Should reject '%s'.", contents)
+		}
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		_, err := LoadPolicyConfig(filepath.Join(os.TempDir(), "does-not-exist.json"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,431 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	// DefaultPageSize is how many results a listing returns when no limit is given
+	DefaultPageSize = 50
+
+	// MaxPageSize is the largest limit a listing accepts
+	MaxPageSize = 500
+)
+
+// ErrInvalidQuery the listing query has an invalid limit, sort or cursor
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Page is how a listing is sorted and which page of it to return
+type Page struct {
+	// Limit is the most results to return, DefaultPageSize if 0
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	// Sort is the field to sort by, prefixed with '-' for descending order. Results are sorted by ID
+	// when empty, and by ID after the field when values are the same.
+	Sort string
+}
+
+// RequestQuery filters, sorts and pages a listing of requests. Filters that are left empty match
+// every request.
+type RequestQuery struct {
+	Page
+
+	// Email matches requests made by the patron with the email
+	Email string
+	// Title matches requests for titles containing it, ignoring case
+	Title string
+	// Statuses matches requests in any of the statuses
+	Statuses []types.RequestStatus
+	// CreatedAfter matches requests created at or after the time
+	CreatedAfter *time.Time
+	// CreatedBefore matches requests created before the time
+	CreatedBefore *time.Time
+}
+
+// RequestPage is one page of a request listing
+type RequestPage struct {
+	Requests []*types.Request
+	// NextCursor is the cursor for the next page, empty on the last page
+	NextCursor string
+}
+
+// BookQuery filters, sorts and pages a listing of books. Filters that are left empty match every book.
+type BookQuery struct {
+	Page
+
+	// Title matches books with titles containing it, ignoring case
+	Title string
+	// Available matches books with, or without, a free copy
+	Available *bool
+}
+
+// BookPage is one page of a book listing
+type BookPage struct {
+	Books []*types.Book
+	// NextCursor is the cursor for the next page, empty on the last page
+	NextCursor string
+}
+
+// sortField is a field listings can be sorted by. Values are compared as strings, so times are
+// formatted with a fixed width to sort the same way as text and in SQL.
+type sortField struct {
+	// column is the SQL expression to sort by, empty when sorting by ID alone
+	column string
+}
+
+// requestSortFields are the fields requests can be sorted by
+var requestSortFields = map[string]sortField{
+	"id":        {},
+	"createdAt": {column: "createdAt"},
+	"updatedAt": {column: "updatedAt"},
+	"title":     {column: `title COLLATE "C"`},
+	"email":     {column: `email COLLATE "C"`},
+	"status":    {column: `status COLLATE "C"`},
+}
+
+// bookSortFields are the fields books can be sorted by
+var bookSortFields = map[string]sortField{
+	"id":    {},
+	"title": {column: `title COLLATE "C"`},
+}
+
+// requestSortValue returns the value of the sort field of the request
+func requestSortValue(request *types.Request, field string) string {
+	switch field {
+	case "createdAt":
+		return formatSortTime(request.CreatedAt)
+	case "updatedAt":
+		return formatSortTime(request.UpdatedAt)
+	case "title":
+		return request.Title
+	case "email":
+		return request.Email
+	case "status":
+		return string(request.Status)
+	default:
+		return ""
+	}
+}
+
+// bookSortValue returns the value of the sort field of the book
+func bookSortValue(book *types.Book, field string) string {
+	switch field {
+	case "title":
+		return book.Title
+	default:
+		return ""
+	}
+}
+
+// sortTimeFormat is RFC 3339 with a fixed number of fractional digits, so formatted UTC times sort
+// the same way as strings as they do as times
+const sortTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatSortTime(t time.Time) string {
+	return t.UTC().Format(sortTimeFormat)
+}
+
+// sortOrder is a parsed Page.Sort
+type sortOrder struct {
+	field      string
+	descending bool
+}
+
+// parseSort returns the sort order for the value, which must name one of the fields
+func parseSort(value string, fields map[string]sortField) (sortOrder, error) {
+	order := sortOrder{field: strings.TrimPrefix(value, "-"), descending: strings.HasPrefix(value, "-")}
+	if order.field == "" {
+		order.field = "id"
+	}
+
+	if _, ok := fields[order.field]; !ok {
+		return sortOrder{}, errors.Wrapf(ErrInvalidQuery, "can not sort by '%s'", order.field)
+	}
+
+	return order, nil
+}
+
+// String returns the sort order in the format of Page.Sort
+func (o sortOrder) String() string {
+	if o.descending {
+		return "-" + o.field
+	}
+
+	return o.field
+}
+
+// cursor is the position after which the next page starts: the sort value and ID of the last result
+// of the previous page. The sort order is included so a cursor can't be used with a different one.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string, order sortOrder) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidQuery, "malformed cursor")
+	}
+
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.Wrap(ErrInvalidQuery, "malformed cursor")
+	}
+
+	if c.Sort != order.String() {
+		return nil, errors.Wrap(ErrInvalidQuery, "cursor is for a different sort order")
+	}
+
+	return c, nil
+}
+
+// after returns true if a result with the sort value and ID comes after the cursor in the sort order
+func (c *cursor) after(value string, id int, descending bool) bool {
+	return sortsBefore(c.Value, c.ID, value, id, descending)
+}
+
+// sortsBefore returns true if a result with the first sort value and ID comes before one with the second.
+// Results with the same value are sorted by ID in the same direction.
+func sortsBefore(value string, id int, otherValue string, otherID int, descending bool) bool {
+	cmp := strings.Compare(value, otherValue)
+	if cmp == 0 {
+		cmp = id - otherID
+	}
+
+	if descending {
+		return cmp > 0
+	}
+
+	return cmp < 0
+}
+
+// pageQuery is a validated Page
+type pageQuery struct {
+	limit  int
+	order  sortOrder
+	cursor *cursor
+}
+
+// parsePage validates the page against the fields it can be sorted by
+func parsePage(page Page, fields map[string]sortField) (*pageQuery, error) {
+	limit := page.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+
+	if limit < 0 || limit > MaxPageSize {
+		return nil, errors.Wrapf(ErrInvalidQuery, "limit must be between 1 and %d", MaxPageSize)
+	}
+
+	order, err := parseSort(page.Sort, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := decodeCursor(page.Cursor, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pageQuery{limit: limit, order: order, cursor: c}, nil
+}
+
+// nextCursor returns the cursor for the page after the last result
+func (p *pageQuery) nextCursor(lastValue string, lastID int) string {
+	return encodeCursor(cursor{Sort: p.order.String(), Value: lastValue, ID: lastID})
+}
+
+// orderBy returns the SQL ORDER BY clause for the sort order
+func (p *pageQuery) orderBy(fields map[string]sortField) string {
+	direction := "ASC"
+	if p.order.descending {
+		direction = "DESC"
+	}
+
+	column := fields[p.order.field].column
+	if column == "" {
+		return fmt.Sprintf("ORDER BY id %s", direction)
+	}
+
+	return fmt.Sprintf("ORDER BY %s %s, id %s", column, direction, direction)
+}
+
+// where builds the SQL WHERE clause of a listing, numbering its arguments in order
+type where struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add adds a condition, where each '?' is replaced by the placeholder of the next argument
+func (w *where) add(condition string, args ...interface{}) {
+	for _, arg := range args {
+		w.args = append(w.args, arg)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+
+	w.conditions = append(w.conditions, condition)
+}
+
+// addCursor adds the condition for results after the cursor
+func (w *where) addCursor(p *pageQuery, fields map[string]sortField) {
+	if p.cursor == nil {
+		return
+	}
+
+	op := ">"
+	if p.order.descending {
+		op = "<"
+	}
+
+	column := fields[p.order.field].column
+	if column == "" {
+		w.add("id "+op+" ?", p.cursor.ID)
+		return
+	}
+
+	w.add(fmt.Sprintf("(%s, id) %s (?, ?)", column, op), p.cursor.Value, p.cursor.ID)
+}
+
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// validate checks the filters of the query
+func (q *RequestQuery) validate() error {
+	for _, status := range q.Statuses {
+		if !KnownStatus(status) {
+			return errors.Wrapf(ErrInvalidQuery, "unknown status '%s'", status)
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if the request matches the filters of the query
+func (q *RequestQuery) matches(request *types.Request) bool {
+	if q.Email != "" && request.Email != normalizeEmail(q.Email) {
+		return false
+	}
+
+	if q.Title != "" && !containsFold(request.Title, q.Title) {
+		return false
+	}
+
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, status := range q.Statuses {
+			if request.Status == status {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.CreatedAfter != nil && request.CreatedAt.Before(*q.CreatedAfter) {
+		return false
+	}
+
+	if q.CreatedBefore != nil && !request.CreatedAt.Before(*q.CreatedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// where returns the SQL conditions for the filters of the query
+func (q *RequestQuery) where() *where {
+	w := &where{}
+
+	if q.Email != "" {
+		w.add("email = ?", normalizeEmail(q.Email))
+	}
+
+	if q.Title != "" {
+		w.add("title ILIKE ?", likePattern(q.Title))
+	}
+
+	if len(q.Statuses) > 0 {
+		statuses := make([]string, len(q.Statuses))
+		for i, status := range q.Statuses {
+			statuses[i] = string(status)
+		}
+		w.add("status = ANY(?)", pq.Array(statuses))
+	}
+
+	if q.CreatedAfter != nil {
+		w.add("createdAt >= ?", *q.CreatedAfter)
+	}
+
+	if q.CreatedBefore != nil {
+		w.add("createdAt < ?", *q.CreatedBefore)
+	}
+
+	return w
+}
+
+// matches returns true if the book matches the filters of the query
+func (q *BookQuery) matches(book *types.Book) bool {
+	if q.Title != "" && !containsFold(book.Title, q.Title) {
+		return false
+	}
+
+	if q.Available != nil && book.Available != *q.Available {
+		return false
+	}
+
+	return true
+}
+
+// where returns the SQL conditions for the filters of the query
+func (q *BookQuery) where() *where {
+	w := &where{}
+
+	if q.Title != "" {
+		w.add("title ILIKE ?", likePattern(q.Title))
+	}
+
+	if q.Available != nil {
+		w.add("EXISTS (SELECT 1 FROM copies c WHERE c.bookId = books.id AND c.available) = ?", *q.Available)
+	}
+
+	return w
+}
+
+// containsFold returns true if s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// likePattern returns an ILIKE pattern matching values containing s, escaping its wildcards
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
@@ -0,0 +1,449 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"syreclabs.com/go/faker"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+// Fixtures is data to seed a store with. Books are matched to the catalog by title, patrons by email and
+// requests by the email and title, so seeding the same fixtures again adds nothing.
+type Fixtures struct {
+	// Books are added with their copies. Books already in the catalog get any copies they are missing.
+	Books []*types.Book `json:"books"`
+	// Patrons are registered, or renamed if they are already registered under another name
+	Patrons []*types.Patron `json:"patrons"`
+	// Requests are made by email for a title, in order, and moved to their status. Requests without a
+	// status are left requested, or waiting if no copy was free. Requests left waiting are cancelled if
+	// their status is closed. Requests with a createdAt keep it.
+	Requests []*types.Request `json:"requests"`
+}
+
+// SeedSummary counts what seeding added to the store
+type SeedSummary struct {
+	Books    int `json:"books"`
+	Copies   int `json:"copies"`
+	Patrons  int `json:"patrons"`
+	Requests int `json:"requests"`
+}
+
+// Seeder is the store seeded with fixtures, implemented by every LibraryStore
+type Seeder interface {
+	CreateBook(ctx context.Context, book *types.Book) (*types.Book, error)
+	ListBook(ctx context.Context, query *BookQuery) (*BookPage, error)
+	AddCopy(ctx context.Context, bookID int, c *types.Copy) (*types.Copy, error)
+
+	CreatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error)
+	ListPatron(ctx context.Context) ([]*types.Patron, error)
+	UpdatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error)
+
+	CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error)
+	ListRequest(ctx context.Context, query *RequestQuery) (*RequestPage, error)
+	UpdateRequestStatus(ctx context.Context, requestID int, status types.RequestStatus) (*types.Request, error)
+}
+
+// LoadFixtures reads and validates the JSON fixture file at path
+func LoadFixtures(path string) (*Fixtures, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fixtures := &Fixtures{}
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(fixtures); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse fixture file '%s'", path)
+	}
+
+	if err := fixtures.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid fixture file '%s'", path)
+	}
+
+	return fixtures, nil
+}
+
+// Validate returns an error if the fixtures cannot be seeded
+func (f *Fixtures) Validate() error {
+	for i, book := range f.Books {
+		if book == nil || book.Title == "" {
+			return errors.Errorf("book %d must have a title", i)
+		}
+	}
+
+	for i, patron := range f.Patrons {
+		if patron == nil || !strings.Contains(patron.Email, "@") {
+			return errors.Errorf("patron %d must have an email", i)
+		}
+	}
+
+	for i, request := range f.Requests {
+		if request == nil || request.Title == "" || !strings.Contains(request.Email, "@") {
+			return errors.Errorf("request %d must have a title and an email", i)
+		}
+
+		if request.Status != "" && !KnownStatus(request.Status) {
+			return errors.Errorf("request %d has unknown status '%s'", i, request.Status)
+		}
+	}
+
+	return nil
+}
+
+// Append adds the books, patrons and requests of other to the fixtures
+func (f *Fixtures) Append(other *Fixtures) {
+	f.Books = append(f.Books, other.Books...)
+	f.Patrons = append(f.Patrons, other.Patrons...)
+	f.Requests = append(f.Requests, other.Requests...)
+}
+
+// Seed adds the fixtures to the store, skipping what is already there. A seed that fails part way through
+// can be run again to finish it.
+func Seed(ctx context.Context, store Seeder, fixtures *Fixtures) (*SeedSummary, error) {
+	if err := fixtures.Validate(); err != nil {
+		return nil, err
+	}
+
+	summary := &SeedSummary{}
+
+	books, err := booksByTitle(ctx, store)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, book := range fixtures.Books {
+		if err := seedBook(ctx, store, books, book, summary); err != nil {
+			return summary, errors.Wrapf(err, "failed to seed book '%s'", book.Title)
+		}
+	}
+
+	patrons, err := patronsByEmail(ctx, store)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, patron := range fixtures.Patrons {
+		if err := seedPatron(ctx, store, patrons, patron, summary); err != nil {
+			return summary, errors.Wrapf(err, "failed to seed patron '%s'", patron.Email)
+		}
+	}
+
+	requests, err := NewRequestImporter(ctx, store)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, request := range fixtures.Requests {
+		created, err := requests.Import(ctx, request)
+		if created {
+			summary.Requests++
+		}
+		if err != nil {
+			return summary, errors.Wrapf(err, "failed to seed request for '%s' by '%s'", request.Title, request.Email)
+		}
+	}
+
+	return summary, nil
+}
+
+// seedBook adds the book, or the copies it is missing if its title is already in the catalog
+func seedBook(ctx context.Context, store Seeder, books map[string]*types.Book, book *types.Book, summary *SeedSummary) error {
+	copies := copiesToCreate(book)
+
+	existing, ok := books[book.Title]
+	if !ok {
+		created, err := store.CreateBook(ctx, book)
+		if err != nil {
+			return err
+		}
+
+		books[created.Title] = created
+		summary.Books++
+		summary.Copies += len(created.Copies)
+		return nil
+	}
+
+	// Missing copies are added like copies added through the API, so they go to the waitlist first
+	for _, c := range copies[min(len(existing.Copies), len(copies)):] {
+		added, err := store.AddCopy(ctx, existing.ID, c)
+		if err != nil {
+			return err
+		}
+
+		existing.Copies = append(existing.Copies, added)
+		summary.Copies++
+	}
+
+	return nil
+}
+
+// seedPatron registers the patron, or renames them if they are registered under another name
+func seedPatron(ctx context.Context, store Seeder, patrons map[string]*types.Patron, patron *types.Patron, summary *SeedSummary) error {
+	existing, ok := patrons[normalizeEmail(patron.Email)]
+	if !ok {
+		created, err := store.CreatePatron(ctx, patron)
+		if err != nil {
+			return err
+		}
+
+		patrons[created.Email] = created
+		summary.Patrons++
+		return nil
+	}
+
+	if patron.Name == "" || patron.Name == existing.Name {
+		return nil
+	}
+
+	updated, err := store.UpdatePatron(ctx, &types.Patron{ID: existing.ID, Email: existing.Email, Name: patron.Name})
+	if err != nil {
+		return err
+	}
+
+	patrons[updated.Email] = updated
+	return nil
+}
+
+// RequestImporter makes requests with the status they had in another system, such as the fixtures or an
+// export, unless the patron has already requested the title
+type RequestImporter struct {
+	store Seeder
+	// requested is the email and title of every request in the store, as made by requestKey
+	requested map[string]bool
+}
+
+// NewRequestImporter creates an importer for the store, reading the requests already in it
+func NewRequestImporter(ctx context.Context, store Seeder) (*RequestImporter, error) {
+	requested, err := requestKeys(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestImporter{store: store, requested: requested}, nil
+}
+
+// Import makes the request for its email and title, keeping when it was made if it has a CreatedAt, and
+// moves it to its status, returning false if the patron has already requested the title. It returns true
+// with an error if the request was made but could not be moved to its status. Requests can only be moved
+// along the request state machine, so a request left waiting for a copy is cancelled if it should be
+// closed and otherwise keeps waiting.
+func (i *RequestImporter) Import(ctx context.Context, request *types.Request) (bool, error) {
+	email := normalizeEmail(request.Email)
+
+	key := requestKey(email, request.Title)
+	if i.requested[key] {
+		return false, nil
+	}
+
+	created := &types.Request{Email: email, Title: request.Title, CreatedAt: request.CreatedAt}
+	if _, err := i.store.CreateRequest(ctx, created); err != nil {
+		return false, err
+	}
+	i.requested[key] = true
+
+	for _, status := range statusPath(created.Status, request.Status) {
+		if _, err := i.store.UpdateRequestStatus(ctx, created.ID, status); err != nil {
+			return true, errors.Wrapf(err, "failed to move request %d from %s to %s", created.ID, created.Status, status)
+		}
+		created.Status = status
+	}
+
+	return true, nil
+}
+
+// statusPath returns the statuses a new request moves through to reach the status, or to get as close to
+// it as the state machine allows. Requests can't be moved out of waiting except by cancelling them, so
+// waiting requests are cancelled if they should be closed and are otherwise left waiting.
+func statusPath(from, to types.RequestStatus) []types.RequestStatus {
+	switch {
+	case to == "" || to == from:
+		return nil
+	case from == types.RequestStatusWaiting && isOpen(to):
+		return nil
+	case from == types.RequestStatusWaiting:
+		return []types.RequestStatus{types.RequestStatusCancelled}
+	case from == types.RequestStatusRequested && to == types.RequestStatusReturned:
+		return []types.RequestStatus{types.RequestStatusCheckedOut, types.RequestStatusReturned}
+	default:
+		return []types.RequestStatus{to}
+	}
+}
+
+// booksByTitle returns every book in the catalog keyed by title
+func booksByTitle(ctx context.Context, store Seeder) (map[string]*types.Book, error) {
+	books := map[string]*types.Book{}
+
+	query := &BookQuery{Page: Page{Limit: MaxPageSize}}
+	for {
+		page, err := store.ListBook(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, book := range page.Books {
+			books[book.Title] = book
+		}
+
+		if page.NextCursor == "" {
+			return books, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// requestKeys returns the email and title of every request, including closed requests, as keys made by requestKey
+func requestKeys(ctx context.Context, store Seeder) (map[string]bool, error) {
+	keys := map[string]bool{}
+
+	query := &RequestQuery{Page: Page{Limit: MaxPageSize}}
+	for {
+		page, err := store.ListRequest(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, request := range page.Requests {
+			keys[requestKey(request.Email, request.Title)] = true
+		}
+
+		if page.NextCursor == "" {
+			return keys, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// requestKey is the natural key of a request, the patron's email and the title
+func requestKey(email, title string) string {
+	return email + "\x00" + title
+}
+
+// patronsByEmail returns every patron keyed by email
+func patronsByEmail(ctx context.Context, store Seeder) (map[string]*types.Patron, error) {
+	patrons, err := store.ListPatron(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byEmail := map[string]*types.Patron{}
+	for _, patron := range patrons {
+		byEmail[patron.Email] = patron
+	}
+
+	return byEmail, nil
+}
+
+// GenerateOptions is how many fixtures GenerateFixtures makes and the random seed they are made from
+type GenerateOptions struct {
+	// Seed makes the same fixtures every time it is used
+	Seed int64
+	// Books is the number of books, each with one to three available copies
+	Books int
+	// Patrons is the number of patrons
+	Patrons int
+	// Requests is the most requests made by the patrons for the books, fewer if patrons pick the same book
+	// twice. Most are returned or cancelled, and the rest are left open.
+	Requests int
+}
+
+// generateEpoch is when the first generated request is made. Later requests are made a random time after
+// the one before, so the same seed always makes the same history.
+var generateEpoch = time.Date(2020, time.January, 1, 9, 0, 0, 0, time.UTC)
+
+// generateMu serializes generating fixtures, since faker draws from a single shared random source
+var generateMu sync.Mutex
+
+// GenerateFixtures makes random, but reproducible, books, patrons and requests. Titles and emails are
+// unique, and every request can be seeded into a store that only has the generated books.
+func GenerateFixtures(opts GenerateOptions) *Fixtures {
+	generateMu.Lock()
+	defer generateMu.Unlock()
+
+	faker.Seed(opts.Seed)
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	fixtures := &Fixtures{}
+
+	titles := map[string]bool{}
+	for i := 0; i < opts.Books; i++ {
+		title := uniqueTitle(titles, strings.Join(faker.Lorem().Words(2+rnd.Intn(3)), " "), i+1)
+		titles[title] = true
+
+		book := &types.Book{Title: title, Available: true}
+		for copies := 1 + rnd.Intn(3); copies > 0; copies-- {
+			book.Copies = append(book.Copies, &types.Copy{Available: true})
+		}
+		fixtures.Books = append(fixtures.Books, book)
+	}
+
+	for i := 0; i < opts.Patrons; i++ {
+		fixtures.Patrons = append(fixtures.Patrons, &types.Patron{
+			Name:  faker.Name().Name(),
+			Email: fmt.Sprintf("%s.%d@example.com", faker.Internet().UserName(), i+1),
+		})
+	}
+
+	if len(fixtures.Books) == 0 || len(fixtures.Patrons) == 0 {
+		return fixtures
+	}
+
+	// Closed requests come first so each one frees its copy for the next, leaving the open requests to
+	// take whatever copies are left
+	numOpen := opts.Requests / 5
+	requested := map[string]bool{}
+	createdAt := generateEpoch
+	for i := 0; i < opts.Requests; i++ {
+		patron := fixtures.Patrons[rnd.Intn(len(fixtures.Patrons))]
+		book := fixtures.Books[rnd.Intn(len(fixtures.Books))]
+
+		// Requests are matched by email and title, so a repeat would be skipped when seeding
+		key := requestKey(patron.Email, book.Title)
+		if requested[key] {
+			continue
+		}
+		requested[key] = true
+
+		status := types.RequestStatusReturned
+		switch {
+		case i >= opts.Requests-numOpen:
+			status = ""
+		case rnd.Intn(5) == 0:
+			status = types.RequestStatusCancelled
+		}
+
+		createdAt = createdAt.Add(time.Duration(1+rnd.Intn(48*60)) * time.Minute)
+		fixtures.Requests = append(fixtures.Requests, &types.Request{Email: patron.Email, Title: book.Title, Status: status, CreatedAt: createdAt})
+	}
+
+	return fixtures
+}
+
+// uniqueTitle returns the title, or if it is already taken the title followed by the first number from n
+// that is not
+func uniqueTitle(titles map[string]bool, title string, n int) string {
+	unique := title
+	for ; titles[unique]; n++ {
+		unique = fmt.Sprintf("%s %d", title, n)
+	}
+
+	return unique
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,95 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestGenerateFixtures(t *testing.T) {
+	opts := GenerateOptions{Seed: 42, Books: 50, Patrons: 10, Requests: 40}
+
+	fixtures := GenerateFixtures(opts)
+	assert.Equal(t, fixtures, GenerateFixtures(opts), "The same seed should generate the same fixtures.")
+	assert.NotEqual(t, fixtures, GenerateFixtures(GenerateOptions{Seed: 43, Books: 50, Patrons: 10, Requests: 40}))
+	require.NoError(t, fixtures.Validate())
+
+	require.Len(t, fixtures.Books, 50)
+	titles := map[string]bool{}
+	for _, book := range fixtures.Books {
+		assert.False(t, titles[book.Title], "Titles should be unique.")
+		titles[book.Title] = true
+		assert.NotEmpty(t, book.Copies)
+	}
+
+	require.Len(t, fixtures.Patrons, 10)
+	emails := map[string]bool{}
+	for _, patron := range fixtures.Patrons {
+		assert.False(t, emails[patron.Email], "Emails should be unique.")
+		emails[patron.Email] = true
+		assert.NotEmpty(t, patron.Name)
+	}
+
+	assert.NotEmpty(t, fixtures.Requests)
+	assert.LessOrEqual(t, len(fixtures.Requests), 40)
+	open := false
+	createdAt := generateEpoch
+	for _, request := range fixtures.Requests {
+		assert.True(t, titles[request.Title])
+		assert.True(t, emails[request.Email])
+
+		// Requests are made one after another from a fixed time rather than when they are generated
+		assert.True(t, request.CreatedAt.After(createdAt), "Requests should be made in order.")
+		createdAt = request.CreatedAt
+
+		// Open requests come last so they can't hold the copies the closed requests need
+		if request.Status == "" {
+			open = true
+		} else {
+			assert.False(t, open, "Closed requests should come before open requests.")
+		}
+	}
+
+	assert.Empty(t, GenerateFixtures(GenerateOptions{Seed: 1, Books: 5, Requests: 5}).Requests, "Requests need patrons.")
+}
+
+func TestUniqueTitle(t *testing.T) {
+	titles := map[string]bool{"a title": true, "a title 3": true, "a title 4": true}
+
+	assert.Equal(t, "other title", uniqueTitle(titles, "other title", 3))
+	assert.Equal(t, "a title 5", uniqueTitle(titles, "a title", 3), "Should skip numbered titles that are taken.")
+}
+
+func TestLoadFixtures(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "fixtures.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	fixtures, err := LoadFixtures(write(t, `{
+		"books": [{"title": "testbook", "copies": [{"available": true}]}],
+		"patrons": [{"email": "test@gmail.com", "name": "Test Patron"}],
+		"requests": [{"email": "test@gmail.com", "title": "testbook", "status": "checked_out"}]
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "testbook", fixtures.Books[0].Title)
+	assert.Equal(t, types.RequestStatusCheckedOut, fixtures.Requests[0].Status)
+
+	for name, content := range map[string]string{
+		"Unknown Field":  `{"authors": []}`,
+		"Missing Title":  `{"books": [{"available": true}]}`,
+		"Missing Email":  `{"patrons": [{"name": "Test Patron"}]}`,
+		"Unknown Status": `{"requests": [{"email": "test@gmail.com", "title": "testbook", "status": "lost"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadFixtures(write(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
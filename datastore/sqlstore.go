@@ -1,50 +1,200 @@
 package datastore
 
-
-
 import (
-	"database/sql"
 	"context"
-	"time"
+	"database/sql"
 	"fmt"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-	_ "github.com/lib/pq"
 
 	"github.com/samkreter/givedirectly/types"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidTransition the request cannot move to the requested status from its current status
+	ErrInvalidTransition = errors.New("invalid request status transition")
+
+	// ErrAlreadyExists a book with the same title is already in the catalog
+	ErrAlreadyExists = errors.New("already exists")
+
+	// ErrInUse the book or copy is held by an open request
+	ErrInUse = errors.New("in use")
+
+	// ErrRenewalLimit the request has already been renewed the maximum number of times
+	ErrRenewalLimit = errors.New("renewal limit reached")
+
+	// ErrHoldQueue the request cannot be renewed because other requests are waiting for the book
+	ErrHoldQueue = errors.New("other requests are waiting for the book")
+
+	// ErrUnknownPatron the request is for a patron that does not exist
+	ErrUnknownPatron = errors.New("unknown patron")
 )
 
 type SQLStore struct {
-	db *sql.DB
+	db         *tracedDB
+	loanPolicy LoanPolicy
+	policies   []Policy
 }
 
-// NewSQLStore creates a new sqlStore for access postgres
-func NewSQLStore(user, dbname, password, host string, port int) (*SQLStore, error){
-	connStr := fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
+// NewSQLStore creates a new sqlStore for access postgres. Postgres is given the connect timeout to
+// start accepting connections.
+func NewSQLStore(ctx context.Context, config *SQLConfig) (*SQLStore, error) {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	db, err := config.open(ctx)
+	if err != nil {
 		return nil, err
 	}
 
 	return &SQLStore{
-		db: db,
+		db:         &tracedDB{DB: db},
+		loanPolicy: DefaultLoanPolicy,
 	}, nil
 }
 
-// ListRequest returns all request from the database
-func (s *SQLStore) ListRequest(ctx context.Context)  ([]*types.Request, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT id, email, title FROM requests")
+// Close closes the connections to the database. The store can't be used after it is closed.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// SetLoanPolicy sets the policy used for new loans and renewals. It defaults to DefaultLoanPolicy and
+// must be set before the store is used.
+func (s *SQLStore) SetLoanPolicy(policy LoanPolicy) {
+	s.loanPolicy = policy
+}
+
+// SetPolicies sets the borrowing policies checked when a request is created. There are none by default
+// and they must be set before the store is used.
+func (s *SQLStore) SetPolicies(policies ...Policy) {
+	s.policies = policies
+}
+
+// requestColumns the columns selected for a request, in the order scanRequest expects them
+const requestColumns = "id, patronId, email, title, COALESCE(bookId, 0), COALESCE(copyId, 0), status, dueAt, renewals, createdAt, updatedAt"
+
+// copyColumns the columns selected for a copy, in the order scanCopy expects them
+const copyColumns = "id, bookId, available, timeRequested"
+
+// patronColumns the columns selected for a patron, in the order scanPatron expects them
+const patronColumns = "id, email, name, createdAt"
+
+// apiKeyColumns the columns selected for an API key, in the order scanAPIKey expects them
+const apiKeyColumns = "id, name, COALESCE(patronId, 0), role, hash, createdAt"
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRequest(row scanner) (*types.Request, error) {
+	request := &types.Request{}
+	err := row.Scan(&request.ID, &request.PatronID, &request.Email, &request.Title, &request.BookID, &request.CopyID,
+		&request.Status, &request.DueAt, &request.Renewals, &request.CreatedAt, &request.UpdatedAt)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return request, nil
+}
+
+func scanCopy(row scanner) (*types.Copy, error) {
+	c := &types.Copy{}
+	if err := row.Scan(&c.ID, &c.BookID, &c.Available, &c.TimeRequested); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func scanPatron(row scanner) (*types.Patron, error) {
+	patron := &types.Patron{}
+	if err := row.Scan(&patron.ID, &patron.Email, &patron.Name, &patron.CreatedAt); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return patron, nil
+}
+
+func scanAPIKey(row scanner) (*types.APIKey, error) {
+	key := &types.APIKey{}
+	if err := row.Scan(&key.ID, &key.Name, &key.PatronID, &key.Role, &key.Hash, &key.CreatedAt); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ListRequest returns a page of the requests matching the query, including closed requests. Queries
+// with an invalid limit, sort or cursor return an error wrapping ErrInvalidQuery.
+func (s *SQLStore) ListRequest(ctx context.Context, query *RequestQuery) (*RequestPage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	page, err := parsePage(query.Page, requestSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	where := query.where()
+	where.addCursor(page, requestSortFields)
+
+	// One more than the limit is read to know if there is a next page
+	requests, err := queryRequests(ctx, s.db, fmt.Sprintf("SELECT %s FROM requests %s %s LIMIT %d",
+		requestColumns, where, page.orderBy(requestSortFields), page.limit+1), where.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(requests) > page.limit
+	if more {
+		requests = requests[:page.limit]
+	}
+
+	result := &RequestPage{Requests: requests}
+	if more {
+		last := requests[len(requests)-1]
+		result.NextCursor = page.nextCursor(requestSortValue(last, page.order.field), last.ID)
+	}
+
+	return result, nil
+}
+
+// queryRequests runs a query selecting requestColumns and returns the requests
+func queryRequests(ctx context.Context, q querier, query string, args ...interface{}) ([]*types.Request, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -53,8 +203,8 @@ func (s *SQLStore) ListRequest(ctx context.Context)  ([]*types.Request, error) {
 
 	defer rows.Close()
 	for rows.Next() {
-		request := &types.Request{}
-		if err := rows.Scan(&request.ID, &request.Email, &request.Title); err != nil {
+		request, err := scanRequest(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -69,74 +219,94 @@ func (s *SQLStore) ListRequest(ctx context.Context)  ([]*types.Request, error) {
 }
 
 // GetRequest returns the specific request
-func (s *SQLStore) GetRequest(ctx context.Context, requestID int)  (*types.Request, error) {
-	request := &types.Request{}
+func (s *SQLStore) GetRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+requestColumns+" FROM requests WHERE id=$1", requestID)
+	return scanRequest(row)
+}
 
-	row := s.db.QueryRowContext(ctx, "SELECT id, email, title FROM requests WHERE id=$1", requestID)
-	if err := row.Scan(&request.ID, &request.Email, &request.Title); err != nil {
-		switch {
-		case err == sql.ErrNoRows:
-			return nil, ErrNotFound
-		default:
-			return nil, err
+// DeleteRequest closes the request and gives its copy of the book to the next waiting request, or makes
+// the copy available if nobody is waiting. The request is kept for history: waiting requests and requests
+// that were never picked up are cancelled and checked out requests are returned. It returns the closed
+// request. Closing an already closed request returns ErrInvalidTransition.
+func (s *SQLStore) DeleteRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	return s.transitionRequest(ctx, requestID, closingStatus)
+}
+
+// UpdateRequestStatus moves the request to the given status following the request state machine. When a
+// request holding a copy is returned or cancelled the copy is given to the next waiting request.
+func (s *SQLStore) UpdateRequestStatus(ctx context.Context, requestID int, status types.RequestStatus) (*types.Request, error) {
+	return s.transitionRequest(ctx, requestID, func(from types.RequestStatus) (types.RequestStatus, error) {
+		if err := checkTransition(from, status); err != nil {
+			return "", err
 		}
-	}
 
-	return request, nil
+		return status, nil
+	})
 }
 
-// DeleteRequest removes the request and updates the associated book to available
-func (s *SQLStore) DeleteRequest(ctx context.Context, requestID int) error {
-	tx, err := s.db.BeginTx(ctx, nil)
+// transitionRequest moves the request to the status returned by next within a transaction. The
+// request row is locked so concurrent transitions of the same request are serialized.
+func (s *SQLStore) transitionRequest(ctx context.Context, requestID int, next func(from types.RequestStatus) (types.RequestStatus, error)) (*types.Request, error) {
+	tx, err := s.db.begin(ctx, "TransitionRequest")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var title string
-	row := tx.QueryRowContext(ctx, "SELECT title FROM requests WHERE id=$1", requestID)
-	if err := row.Scan(&title); err != nil {
+	row := tx.QueryRowContext(ctx, "SELECT "+requestColumns+" FROM requests WHERE id=$1 FOR UPDATE", requestID)
+	request, err := scanRequest(row)
+	if err != nil {
 		tx.Rollback()
-		switch {
-		case err == sql.ErrNoRows:
-			return ErrNotFound
-		default:
-			return err
-		}
+		return nil, err
 	}
 
-	_, err = tx.ExecContext(ctx, "UPDATE books SET timeRequested='', available=true WHERE title=$1", title)
+	status, err := next(request.Status)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	_, err = tx.ExecContext(ctx, "DELETE FROM requests WHERE id=$1", requestID)
+	from := request.Status
+	row = tx.QueryRowContext(ctx, "UPDATE requests SET status=$1, updatedAt=now() WHERE id=$2 RETURNING "+requestColumns, status, requestID)
+	request, err = scanRequest(row)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
+	}
+
+	// The copy may be gone if it was removed from the catalog while lent out
+	if releasesBook(from, status) && request.CopyID != 0 {
+		if err := s.releaseCopy(ctx, tx, request.BookID, request.CopyID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return request, nil
 }
 
-// CreateRequest creates a checks if a book is available. If it is, then it updates the book and
-// creates a new request. Otherwise, it will return the book without creating the request. This is all
-// handled within a transaction to make sure the book does not change availability while the func is running.
+// CreateRequest checks if a copy of the book is available. If one is, then it updates the copy and creates
+// a new request bound to it. Otherwise, the request is added to the back of the book's waitlist and will be
+// granted a copy when one is freed. Requests refused by a borrowing policy return a *PolicyViolation. The
+// returned book reports whether a copy was available. Requests made with a CreatedAt keep it, so imported
+// requests keep when they were made. The ID, status, copy and timestamps of the created
+// request are set on request. This is all handled within a transaction
+// to make sure the copies do not change availability while the func is running.
 func (s *SQLStore) CreateRequest(ctx context.Context, request *types.Request) (*types.Book, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
+	tx, err := s.db.begin(ctx, "CreateRequest")
 	if err != nil {
 		return nil, err
 	}
 
 	book := &types.Book{}
 
-	// Get associated book
-	row := tx.QueryRowContext(ctx, "SELECT id, available, title, timeRequested FROM books WHERE title=$1", request.Title)
-	if err := row.Scan(&book.ID, &book.Available, &book.Title, &book.TimeRequested); err != nil {
+	// Get associated book and lock the row so concurrent requests for the same title wait for this
+	// transaction instead of both seeing the same copy as available
+	row := tx.QueryRowContext(ctx, "SELECT id, title FROM books WHERE title=$1 FOR UPDATE", request.Title)
+	if err := row.Scan(&book.ID, &book.Title); err != nil {
 		tx.Rollback()
 		switch {
 		case err == sql.ErrNoRows:
@@ -144,79 +314,831 @@ func (s *SQLStore) CreateRequest(ctx context.Context, request *types.Request) (*
 		default:
 			return nil, err
 		}
+	}
+
+	patron, err := requestingPatron(ctx, tx, request)
+	if err != nil {
+		tx.Rollback()
 		return nil, err
 	}
 
-	// If the books not available, we rollback the transaction and return the book
-	if !book.Available {
+	row = tx.QueryRowContext(ctx, "SELECT "+copyColumns+" FROM copies WHERE bookId=$1 AND available ORDER BY id LIMIT 1", book.ID)
+	freeCopy, err := scanCopy(row)
+	if err != nil && err != ErrNotFound {
 		tx.Rollback()
-		return book, nil
+		return nil, err
 	}
 
-	// Update the book with the ISO-8601 formatted date/time
-	book.TimeRequested = time.Now().Format(time.RFC3339)
-	_, err = tx.ExecContext(ctx, "UPDATE books SET timeRequested=$1, available=false WHERE id=$2", book.TimeRequested, book.ID)
-	if err != nil {
+	borrow := &BorrowRequest{
+		Patron: patron,
+		Book:   book,
+		Lend:   freeCopy != nil,
+	}
+
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*) FILTER (WHERE status IN ($2, $3)), COUNT(*) FILTER (WHERE status=$4) FROM requests WHERE patronId=$1",
+		patron.ID, types.RequestStatusRequested, types.RequestStatusCheckedOut, types.RequestStatusWaiting)
+	if err := row.Scan(&borrow.Loans, &borrow.Holds); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := checkPolicies(s.policies, borrow); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO requests (email, title) VALUES ($1, $2)", request.Email, request.Title)
+	status := types.RequestStatusWaiting
+	var copyID sql.NullInt64
+	var dueAt *time.Time
+
+	// Without a free copy the request waits for one
+	if freeCopy != nil {
+		status = types.RequestStatusRequested
+		copyID = sql.NullInt64{Int64: int64(freeCopy.ID), Valid: true}
+
+		now := time.Now()
+		due := s.loanPolicy.dueDate(now)
+		dueAt = &due
+
+		// Update the copy with the ISO-8601 formatted date/time
+		book.TimeRequested = now.Format(time.RFC3339)
+		_, err = tx.ExecContext(ctx, "UPDATE copies SET timeRequested=$1, available=false WHERE id=$2", book.TimeRequested, freeCopy.ID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	var createdAt *time.Time
+	if !request.CreatedAt.IsZero() {
+		createdAt = &request.CreatedAt
+	}
+
+	row = tx.QueryRowContext(ctx, "INSERT INTO requests (patronId, email, title, bookId, copyId, status, dueAt, createdAt) VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, now())) RETURNING "+requestColumns,
+		patron.ID, patron.Email, book.Title, book.ID, copyID, status, dueAt, createdAt)
+	created, err := scanRequest(row)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
+	if book.Copies, err = listCopies(ctx, tx, book.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
+	// Report whether the request got a copy rather than the state after taking it
+	book.Available = status == types.RequestStatusRequested
+
+	*request = *created
 	return book, nil
 }
 
-// EnsureDB ensures the db has the correct tables set up
-func (s *SQLStore) EnsureDB() error {
-	if err := s.createBookTable(); err != nil {
+// RenewRequest extends the loan of a request holding a copy by another loan period. Loans that have been
+// renewed the maximum number of times return ErrRenewalLimit and loans of books that other requests are
+// waiting for return ErrHoldQueue.
+func (s *SQLStore) RenewRequest(ctx context.Context, requestID int) (*types.Request, error) {
+	tx, err := s.db.begin(ctx, "RenewRequest")
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRowContext(ctx, "SELECT "+requestColumns+" FROM requests WHERE id=$1 FOR UPDATE", requestID)
+	request, err := scanRequest(row)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var numWaiting int
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM requests WHERE bookId=$1 AND status=$2", request.BookID, types.RequestStatusWaiting)
+	if err := row.Scan(&numWaiting); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	dueAt, err := s.loanPolicy.renew(request, numWaiting, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	row = tx.QueryRowContext(ctx, "UPDATE requests SET dueAt=$1, renewals=renewals+1, updatedAt=now() WHERE id=$2 RETURNING "+requestColumns, dueAt, requestID)
+	request, err = scanRequest(row)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// ListOverdueRequests returns the requests holding a copy that was due back before asOf, most overdue first
+func (s *SQLStore) ListOverdueRequests(ctx context.Context, asOf time.Time) ([]*types.Request, error) {
+	return queryRequests(ctx, s.db, "SELECT "+requestColumns+" FROM requests WHERE status IN ($1, $2) AND dueAt < $3 ORDER BY dueAt, id",
+		types.RequestStatusRequested, types.RequestStatusCheckedOut, asOf)
+}
+
+// requestingPatron returns the patron making the request. Requests without a patron ID are made for the
+// patron with the request's email, who is registered if they are new. The patron row is locked so the
+// patron's concurrent requests are checked against the borrowing policies one at a time.
+func requestingPatron(ctx context.Context, tx *tracedTx, request *types.Request) (*types.Patron, error) {
+	if request.PatronID != 0 {
+		row := tx.QueryRowContext(ctx, "SELECT "+patronColumns+" FROM patrons WHERE id=$1 FOR UPDATE", request.PatronID)
+		patron, err := scanPatron(row)
+		if err == ErrNotFound {
+			return nil, ErrUnknownPatron
+		}
+		return patron, err
+	}
+
+	row := tx.QueryRowContext(ctx, "INSERT INTO patrons (email) VALUES ($1) ON CONFLICT (email) DO UPDATE SET email=EXCLUDED.email RETURNING "+patronColumns,
+		normalizeEmail(request.Email))
+	return scanPatron(row)
+}
+
+// GetQueuePosition returns where the request is in the waitlist for its book
+func (s *SQLStore) GetQueuePosition(ctx context.Context, requestID int) (*types.QueuePosition, error) {
+	request, err := s.GetRequest(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	position := &types.QueuePosition{
+		RequestID: request.ID,
+		Status:    request.Status,
+	}
+
+	if request.Status != types.RequestStatusWaiting {
+		return position, nil
+	}
+
+	row := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM requests WHERE bookId=$1 AND status=$2 AND id<=$3",
+		request.BookID, types.RequestStatusWaiting, request.ID)
+	if err := row.Scan(&position.Position); err != nil {
+		return nil, err
+	}
+
+	return position, nil
+}
+
+// releaseCopy hands the freed copy to the oldest waiting request for the book that the borrowing
+// policies allow, or makes it available if nobody is waiting. It must be called within the transaction
+// that freed the copy.
+func (s *SQLStore) releaseCopy(ctx context.Context, tx *tracedTx, bookID, copyID int) error {
+	// Lock the book first so a concurrent CreateRequest either sees the copy freed or has its
+	// waiting request committed before we look at the waitlist
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM books WHERE id=$1 FOR UPDATE", bookID); err != nil {
 		return err
 	}
 
-	if err := s.createRequestTable(); err != nil {
+	waiting, err := queryRequests(ctx, tx, "SELECT "+requestColumns+" FROM requests WHERE bookId=$1 AND status=$2 ORDER BY id FOR UPDATE",
+		bookID, types.RequestStatusWaiting)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	now := time.Now()
+	for _, next := range waiting {
+		status, err := s.promotionStatus(ctx, tx, next)
+		if err != nil {
+			return err
+		}
+
+		switch status {
+		case types.RequestStatusCancelled:
+			if _, err := tx.ExecContext(ctx, "UPDATE requests SET status=$1, updatedAt=now() WHERE id=$2", status, next.ID); err != nil {
+				return err
+			}
+			continue
+		case types.RequestStatusWaiting:
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE requests SET status=$1, copyId=$2, dueAt=$3, updatedAt=now() WHERE id=$4",
+			types.RequestStatusRequested, copyID, s.loanPolicy.dueDate(now), next.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE copies SET timeRequested=$1, available=false WHERE id=$2", now.Format(time.RFC3339), copyID)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE copies SET timeRequested='', available=true WHERE id=$1", copyID)
+	return err
 }
 
+// promotionStatus checks the borrowing policies for lending a freed copy to the waiting request. The
+// patron row is locked as in CreateRequest so their loans do not change while they are counted.
+func (s *SQLStore) promotionStatus(ctx context.Context, tx *tracedTx, request *types.Request) (types.RequestStatus, error) {
+	row := tx.QueryRowContext(ctx, "SELECT "+patronColumns+" FROM patrons WHERE id=$1 FOR UPDATE", request.PatronID)
+	patron, err := scanPatron(row)
+	if err != nil {
+		return "", err
+	}
 
-func (s *SQLStore) createBookTable() error {
-	const qry = `
-		CREATE TABLE IF NOT EXISTS books (
-			id serial PRIMARY KEY,
-			available BOOLEAN NOT NULL,
-			title TEXT NOT NULL,
-			timeRequested TEXT NOT NULL DEFAULT ''
-		)`
+	borrow := &BorrowRequest{
+		Patron: patron,
+		Book:   &types.Book{ID: request.BookID, Title: request.Title},
+		Lend:   true,
+	}
 
-	if _, err := s.db.Exec(qry); err != nil {
-		return errors.Errorf("failed to create book table withe error: %v", err)
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*) FILTER (WHERE status IN ($2, $3)), COUNT(*) FILTER (WHERE status=$4) FROM requests WHERE patronId=$1 AND id<>$5",
+		patron.ID, types.RequestStatusRequested, types.RequestStatusCheckedOut, types.RequestStatusWaiting, request.ID)
+	if err := row.Scan(&borrow.Loans, &borrow.Holds); err != nil {
+		return "", err
 	}
 
-	return nil
+	return promotionStatus(s.policies, borrow), nil
 }
 
-func (s *SQLStore) createRequestTable() error {
-	const qry = `
-		CREATE TABLE IF NOT EXISTS requests (
-			id SERIAL PRIMARY KEY,
-			email TEXT NOT NULL,
-			title TEXT NOT NULL
-		)`
+// ListBook returns a page of the books matching the query and their copies. Queries with an invalid
+// limit, sort or cursor return an error wrapping ErrInvalidQuery.
+func (s *SQLStore) ListBook(ctx context.Context, query *BookQuery) (*BookPage, error) {
+	page, err := parsePage(query.Page, bookSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	where := query.where()
+	where.addCursor(page, bookSortFields)
 
-	if _, err := s.db.Exec(qry); err != nil {
-		return errors.Errorf("failed to create requests table withe error: %v", err)
+	// One more than the limit is read to know if there is a next page
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT id, title, COALESCE(isbn, '') FROM books %s %s LIMIT %d",
+		where, page.orderBy(bookSortFields), page.limit+1), where.args...)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	books := []*types.Book{}
+	byID := map[int]*types.Book{}
+	bookIDs := []int64{}
+
+	defer rows.Close()
+	for rows.Next() {
+		book := &types.Book{}
+		if err := rows.Scan(&book.ID, &book.Title, &book.ISBN); err != nil {
+			return nil, err
+		}
+
+		books = append(books, book)
+		byID[book.ID] = book
+		bookIDs = append(bookIDs, int64(book.ID))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(books) > page.limit
+	if more {
+		books = books[:page.limit]
+	}
+
+	copyRows, err := s.db.QueryContext(ctx, "SELECT "+copyColumns+" FROM copies WHERE bookId = ANY($1) ORDER BY id", pq.Array(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer copyRows.Close()
+	for copyRows.Next() {
+		c, err := scanCopy(copyRows)
+		if err != nil {
+			return nil, err
+		}
+
+		if book, ok := byID[c.BookID]; ok {
+			book.Copies = append(book.Copies, c)
+		}
+	}
+
+	if err := copyRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, book := range books {
+		summarizeCopies(book)
+	}
+
+	result := &BookPage{Books: books}
+	if more {
+		last := books[len(books)-1]
+		result.NextCursor = page.nextCursor(bookSortValue(last, page.order.field), last.ID)
+	}
+
+	return result, nil
+}
+
+// GetBook returns the specific book and its copies
+func (s *SQLStore) GetBook(ctx context.Context, bookID int) (*types.Book, error) {
+	return getBook(ctx, s.db, bookID)
 }
 
+func getBook(ctx context.Context, q querier, bookID int) (*types.Book, error) {
+	book := &types.Book{}
 
+	row := q.QueryRowContext(ctx, "SELECT id, title, COALESCE(isbn, '') FROM books WHERE id=$1", bookID)
+	if err := row.Scan(&book.ID, &book.Title, &book.ISBN); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	copies, err := listCopies(ctx, q, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	book.Copies = copies
+	summarizeCopies(book)
+
+	return book, nil
+}
+
+func listCopies(ctx context.Context, q querier, bookID int) ([]*types.Copy, error) {
+	rows, err := q.QueryContext(ctx, "SELECT "+copyColumns+" FROM copies WHERE bookId=$1 ORDER BY id", bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var copies []*types.Copy
+
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		copies = append(copies, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+// CreateBook adds a new book and its copies to the catalog and returns it with the generated IDs. A book
+// created without any copies gets a single copy with the availability of the book. Titles and ISBNs must be
+// unique, adding a title or ISBN that already exists returns ErrAlreadyExists.
+func (s *SQLStore) CreateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	tx, err := s.db.begin(ctx, "CreateBook")
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := createBook(ctx, tx, book)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// CreateBooks adds the books and their copies to the catalog in a single transaction. Either every book is
+// added or, if any title or ISBN is already in the catalog or repeated in the batch, none are and
+// ErrAlreadyExists is returned.
+func (s *SQLStore) CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error) {
+	tx, err := s.db.begin(ctx, "CreateBooks")
+	if err != nil {
+		return nil, err
+	}
+
+	created := make([]*types.Book, 0, len(books))
+	for _, book := range books {
+		c, err := createBook(ctx, tx, book)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		created = append(created, c)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// createBook adds the book and its copies in the transaction
+func createBook(ctx context.Context, tx *tracedTx, book *types.Book) (*types.Book, error) {
+	// Conflicts on either the title or the ISBN insert nothing
+	var bookID int
+	row := tx.QueryRowContext(ctx, "INSERT INTO books (title, isbn) VALUES ($1, NULLIF($2, '')) ON CONFLICT DO NOTHING RETURNING id",
+		book.Title, NormalizeISBN(book.ISBN))
+	if err := row.Scan(&bookID); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return nil, ErrAlreadyExists
+		default:
+			return nil, err
+		}
+	}
+
+	for _, c := range copiesToCreate(book) {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO copies (bookId, available) VALUES ($1, $2)", bookID, c.Available); err != nil {
+			return nil, err
+		}
+	}
+
+	return getBook(ctx, tx, bookID)
+}
+
+// UpdateBook updates the title of an existing book and of its requests, and its ISBN if one is given.
+// Availability is tracked per copy so it is not updated.
+func (s *SQLStore) UpdateBook(ctx context.Context, book *types.Book) (*types.Book, error) {
+	tx, err := s.db.begin(ctx, "UpdateBook")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE books SET title=$1, isbn=COALESCE(NULLIF($2, ''), isbn) WHERE id=$3",
+		book.Title, NormalizeISBN(book.ISBN), book.ID)
+	if err != nil {
+		tx.Rollback()
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if n == 0 {
+		tx.Rollback()
+		return nil, ErrNotFound
+	}
+
+	// Requests are made, listed and imported by title so they follow the book's new one
+	if _, err := tx.ExecContext(ctx, "UPDATE requests SET title=$1 WHERE bookId=$2", book.Title, book.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	updated, err := getBook(ctx, tx, book.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteBook removes the book and its copies from the catalog. Books with open requests, including
+// waiting requests, cannot be deleted and return ErrInUse.
+func (s *SQLStore) DeleteBook(ctx context.Context, bookID int) error {
+	tx, err := s.db.begin(ctx, "DeleteBook")
+	if err != nil {
+		return err
+	}
+
+	var openRequests int
+	row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM requests WHERE bookId=$1 AND status IN ($2, $3, $4)",
+		bookID, types.RequestStatusWaiting, types.RequestStatusRequested, types.RequestStatusCheckedOut)
+	if err := row.Scan(&openRequests); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if openRequests > 0 {
+		tx.Rollback()
+		return ErrInUse
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM books WHERE id=$1", bookID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if n == 0 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// AddCopy adds a new copy of the book to the catalog. If somebody is waiting for the book the new copy
+// is given to them straight away.
+func (s *SQLStore) AddCopy(ctx context.Context, bookID int, c *types.Copy) (*types.Copy, error) {
+	tx, err := s.db.begin(ctx, "AddCopy")
+	if err != nil {
+		return nil, err
+	}
+
+	var copyID int
+	row := tx.QueryRowContext(ctx, "INSERT INTO copies (bookId, available) VALUES ($1, false) RETURNING id", bookID)
+	if err := row.Scan(&copyID); err != nil {
+		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	// A copy added as available is released like a returned copy so it goes to the waitlist first
+	if c.Available {
+		if err := s.releaseCopy(ctx, tx, bookID, copyID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	created, err := scanCopy(tx.QueryRowContext(ctx, "SELECT "+copyColumns+" FROM copies WHERE id=$1", copyID))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// DeleteCopy removes a copy of the book from the catalog. Copies that are lent out return ErrInUse.
+func (s *SQLStore) DeleteCopy(ctx context.Context, bookID, copyID int) error {
+	tx, err := s.db.begin(ctx, "DeleteCopy")
+	if err != nil {
+		return err
+	}
+
+	// Lock the book so a concurrent CreateRequest can't pick the copy while it is being removed
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM books WHERE id=$1 FOR UPDATE", bookID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	row := tx.QueryRowContext(ctx, "SELECT "+copyColumns+" FROM copies WHERE id=$1 AND bookId=$2", copyID, bookID)
+	if _, err := scanCopy(row); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var openRequests int
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM requests WHERE copyId=$1 AND status IN ($2, $3)",
+		copyID, types.RequestStatusRequested, types.RequestStatusCheckedOut)
+	if err := row.Scan(&openRequests); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if openRequests > 0 {
+		tx.Rollback()
+		return ErrInUse
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM copies WHERE id=$1", copyID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreatePatron registers a new patron. Emails are unique ignoring case, registering an email that
+// already belongs to a patron returns ErrAlreadyExists.
+func (s *SQLStore) CreatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	row := s.db.QueryRowContext(ctx, "INSERT INTO patrons (email, name) VALUES ($1, $2) ON CONFLICT (email) DO NOTHING RETURNING "+patronColumns,
+		normalizeEmail(patron.Email), patron.Name)
+	created, err := scanPatron(row)
+	if err == ErrNotFound {
+		return nil, ErrAlreadyExists
+	}
+
+	return created, err
+}
+
+// GetPatron returns the specific patron
+func (s *SQLStore) GetPatron(ctx context.Context, patronID int) (*types.Patron, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+patronColumns+" FROM patrons WHERE id=$1", patronID)
+	return scanPatron(row)
+}
+
+// ListPatron returns all patrons ordered by ID
+func (s *SQLStore) ListPatron(ctx context.Context) ([]*types.Patron, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+patronColumns+" FROM patrons ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	patrons := []*types.Patron{}
+
+	defer rows.Close()
+	for rows.Next() {
+		patron, err := scanPatron(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		patrons = append(patrons, patron)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return patrons, nil
+}
+
+// UpdatePatron updates the email and name of an existing patron. The patron's requests are updated
+// to the new email. Changing to an email that belongs to another patron returns ErrAlreadyExists.
+func (s *SQLStore) UpdatePatron(ctx context.Context, patron *types.Patron) (*types.Patron, error) {
+	tx, err := s.db.begin(ctx, "UpdatePatron")
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRowContext(ctx, "UPDATE patrons SET email=$1, name=$2 WHERE id=$3 RETURNING "+patronColumns,
+		normalizeEmail(patron.Email), patron.Name, patron.ID)
+	updated, err := scanPatron(row)
+	if err != nil {
+		tx.Rollback()
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE requests SET email=$1 WHERE patronId=$2", updated.Email, updated.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// ListPatronRequests returns the patron's current requests, those waiting for or holding a copy,
+// ordered by ID
+func (s *SQLStore) ListPatronRequests(ctx context.Context, patronID int) ([]*types.Request, error) {
+	if _, err := s.GetPatron(ctx, patronID); err != nil {
+		return nil, err
+	}
+
+	return queryRequests(ctx, s.db, "SELECT "+requestColumns+" FROM requests WHERE patronId=$1 AND status IN ($2, $3, $4) ORDER BY id",
+		patronID, types.RequestStatusWaiting, types.RequestStatusRequested, types.RequestStatusCheckedOut)
+}
+
+// CreateAPIKey stores a new API key by its hash. Keys for patrons that do not exist return ErrUnknownPatron.
+func (s *SQLStore) CreateAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	patronID := sql.NullInt64{Int64: int64(key.PatronID), Valid: key.PatronID != 0}
+
+	row := s.db.QueryRowContext(ctx, "INSERT INTO api_keys (name, patronId, role, hash) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns,
+		key.Name, patronID, key.Role, key.Hash)
+	created, err := scanAPIKey(row)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return nil, ErrAlreadyExists
+		case isForeignKeyViolation(err):
+			return nil, ErrUnknownPatron
+		default:
+			return nil, err
+		}
+	}
+
+	return created, nil
+}
+
+// GetAPIKeyByHash returns the API key with the hash
+func (s *SQLStore) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE hash=$1", hash)
+	return scanAPIKey(row)
+}
+
+// ListAPIKeys returns all API keys ordered by ID
+func (s *SQLStore) ListAPIKeys(ctx context.Context) ([]*types.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*types.APIKey{}
+
+	defer rows.Close()
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes the API key
+func (s *SQLStore) DeleteAPIKey(ctx context.Context, keyID int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id=$1", keyID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// isUniqueViolation returns true if the error is a postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation returns true if the error is a postgres foreign key constraint violation
+func isForeignKeyViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
+}
+
+// Migrator returns a migrator for the store's database
+func (s *SQLStore) Migrator() (*Migrator, error) {
+	return NewMigrator(s.db.DB)
+}
+
+// Stats returns the statistics of the database connection pool
+func (s *SQLStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Ping checks the database can be reached
+func (s *SQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// MigrationStatus returns the status of every known migration in order
+func (s *SQLStore) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	migrator, err := s.Migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrator.Status(ctx)
+}
+
+// Migrate applies all pending schema migrations to the database
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package datastore_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/datastore/storetest"
+)
+
+// TestSQLStore runs the conformance suite against postgres
+func TestSQLStore(t *testing.T) {
+	store := newTestSQLStore(t)
+
+	storetest.Run(t, func(t *testing.T) apiserver.LibraryStore {
+		require.NoError(t, store.Reset())
+		return store
+	})
+}
+
+// TestMigrations rolls every migration back and applies them again against postgres
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQLStore(t)
+
+	migrator, err := store.Migrator()
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "Migration %d should be applied.", status.Version)
+	}
+
+	rolledBack, err := migrator.Down(ctx, len(statuses))
+	require.NoError(t, err)
+	assert.Equal(t, len(statuses), rolledBack, "Should roll back every migration.")
+
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.False(t, status.Applied, "Migration %d should be pending.", status.Version)
+	}
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(statuses), applied, "Should apply every migration.")
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied, "Applying again should be a no-op.")
+}
+
+// newTestSQLStore connects to the test postgres database and applies all migrations. The test is
+// skipped unless GIVEDIRECTLY_TEST_PG_HOST is set, e.g. to the postgres started by docker-compose.
+func newTestSQLStore(t *testing.T) *datastore.SQLStore {
+	host := os.Getenv("GIVEDIRECTLY_TEST_PG_HOST")
+	if host == "" {
+		t.Skip("GIVEDIRECTLY_TEST_PG_HOST not set, skipping postgres tests")
+	}
+
+	port, err := strconv.Atoi(getEnv("GIVEDIRECTLY_TEST_PG_PORT", "5432"))
+	require.NoError(t, err)
+
+	store, err := datastore.NewSQLStore(context.Background(), &datastore.SQLConfig{
+		User:     getEnv("GIVEDIRECTLY_TEST_PG_USER", "librarystore"),
+		DBName:   getEnv("GIVEDIRECTLY_TEST_PG_DBNAME", "librarystore"),
+		Password: getEnv("GIVEDIRECTLY_TEST_PG_PASSWORD", "test1234"),
+		Host:     host,
+		Port:     port,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	require.NoError(t, store.Migrate(context.Background()))
+
+	return store
+}
+
+func getEnv(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+
+	return defaultVal
+}
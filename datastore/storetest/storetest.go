@@ -0,0 +1,1571 @@
+// Package storetest provides a conformance suite that every apiserver.LibraryStore
+// backend must pass. New backends should run it from their own tests:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) apiserver.LibraryStore {
+//			return newEmptyMyStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const (
+	testEmail = "test@gmail.com"
+	testTitle = "testTitle"
+
+	// numConcurrentRequests the number of callers racing for the same book
+	numConcurrentRequests = 10
+)
+
+// Factory returns a new, empty store. It is called once per test case.
+type Factory func(t *testing.T) apiserver.LibraryStore
+
+// PolicyStore is implemented by stores that check borrowing policies when a request is created.
+// Every store under test must implement it.
+type PolicyStore interface {
+	SetPolicies(policies ...datastore.Policy)
+}
+
+// Run runs the full LibraryStore conformance suite against the stores returned by newStore
+func Run(t *testing.T, newStore Factory) {
+	t.Run("Books", func(t *testing.T) { testBooks(t, newStore) })
+	t.Run("Copies", func(t *testing.T) { testCopies(t, newStore) })
+	t.Run("CreateRequest", func(t *testing.T) { testCreateRequest(t, newStore) })
+	t.Run("GetRequest", func(t *testing.T) { testGetRequest(t, newStore) })
+	t.Run("ListRequest", func(t *testing.T) { testListRequest(t, newStore) })
+	t.Run("DeleteRequest", func(t *testing.T) { testDeleteRequest(t, newStore) })
+	t.Run("UpdateRequestStatus", func(t *testing.T) { testUpdateRequestStatus(t, newStore) })
+	t.Run("Waitlist", func(t *testing.T) { testWaitlist(t, newStore) })
+	t.Run("MultipleCopies", func(t *testing.T) { testMultipleCopies(t, newStore) })
+	t.Run("Loans", func(t *testing.T) { testLoans(t, newStore) })
+	t.Run("Patrons", func(t *testing.T) { testPatrons(t, newStore) })
+	t.Run("Policies", func(t *testing.T) { testPolicies(t, newStore) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newStore) })
+	t.Run("ConcurrentCreateRequest", func(t *testing.T) { testConcurrentCreateRequest(t, newStore) })
+	t.Run("Seed", func(t *testing.T) { testSeed(t, newStore) })
+}
+
+func testBooks(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create And Get", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateBook(ctx, &types.Book{Title: testTitle, Available: true})
+		require.NoError(t, err)
+		assert.NotZero(t, created.ID, "Should generate a book ID.")
+		assert.Equal(t, testTitle, created.Title)
+		assert.True(t, created.Available)
+		require.Len(t, created.Copies, 1, "Should create a single copy by default.")
+		assert.True(t, created.Copies[0].Available)
+		assert.Equal(t, created.ID, created.Copies[0].BookID)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, created, book, "Should return the created book.")
+	})
+
+	t.Run("Create With Copies", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateBook(ctx, &types.Book{
+			Title:  testTitle,
+			Copies: []*types.Copy{{Available: false}, {Available: true}, {Available: true}},
+		})
+		require.NoError(t, err)
+		require.Len(t, created.Copies, 3, "Should create every copy.")
+		assert.True(t, created.Available, "Should be available when any copy is free.")
+
+		unavailable, err := store.CreateBook(ctx, &types.Book{
+			Title:  "otherTitle",
+			Copies: []*types.Copy{{Available: false}, {Available: false}},
+		})
+		require.NoError(t, err)
+		assert.False(t, unavailable.Available, "Should be unavailable when no copy is free.")
+
+		skipped, err := store.CreateBook(ctx, &types.Book{
+			Title:  "thirdTitle",
+			Copies: []*types.Copy{nil, {Available: true}},
+		})
+		require.NoError(t, err)
+		assert.Len(t, skipped.Copies, 1, "Should skip nil copies.")
+	})
+
+	t.Run("Duplicate Title", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+
+		_, err := store.CreateBook(ctx, &types.Book{Title: testTitle, Available: true})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "Titles should be unique.")
+	})
+
+	t.Run("ISBN", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateBook(ctx, &types.Book{Title: testTitle, ISBN: "978-0-306-40615-7", Available: true})
+		require.NoError(t, err)
+		assert.Equal(t, "9780306406157", created.ISBN, "Should store the ISBN without hyphens.")
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "9780306406157", book.ISBN)
+
+		page, err := store.ListBook(ctx, &datastore.BookQuery{})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 1)
+		assert.Equal(t, "9780306406157", page.Books[0].ISBN)
+
+		_, err = store.CreateBook(ctx, &types.Book{Title: "otherTitle", ISBN: "9780306406157"})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "ISBNs should be unique.")
+
+		_, err = store.CreateBook(ctx, &types.Book{Title: "noISBN"})
+		require.NoError(t, err)
+		_, err = store.CreateBook(ctx, &types.Book{Title: "alsoNoISBN"})
+		assert.NoError(t, err, "Books without an ISBN should not conflict.")
+	})
+
+	t.Run("Create Batch", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateBooks(ctx, []*types.Book{
+			{Title: testTitle, Available: true},
+			{Title: "otherTitle", ISBN: "0306406152", Copies: []*types.Copy{{Available: true}, {Available: false}}},
+		})
+		require.NoError(t, err)
+		require.Len(t, created, 2)
+		assert.NotZero(t, created[0].ID)
+		assert.Len(t, created[1].Copies, 2)
+
+		for name, batch := range map[string][]*types.Book{
+			"Existing Title": {{Title: "newTitle"}, {Title: testTitle}},
+			"Existing ISBN":  {{Title: "newTitle"}, {Title: "isbnTitle", ISBN: "0-306-40615-2"}},
+			"Repeated Title": {{Title: "newTitle"}, {Title: "newTitle"}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := store.CreateBooks(ctx, batch)
+				assert.Equal(t, datastore.ErrAlreadyExists, err)
+
+				page, err := store.ListBook(ctx, &datastore.BookQuery{Title: "newTitle"})
+				require.NoError(t, err)
+				assert.Empty(t, page.Books, "Should not add any of the batch.")
+			})
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := newStore(t)
+
+		page, err := store.ListBook(ctx, &datastore.BookQuery{})
+		require.NoError(t, err)
+		assert.NotNil(t, page.Books, "Should return an empty list rather than nil.")
+		assert.Empty(t, page.Books, "Should start with no books.")
+		assert.Empty(t, page.NextCursor)
+
+		for _, title := range []string{"a", "b", "c"} {
+			_, err := store.CreateBook(ctx, &types.Book{Title: title, Available: true})
+			require.NoError(t, err)
+		}
+
+		page, err = store.ListBook(ctx, &datastore.BookQuery{})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 3, "Should list all books.")
+		assert.Empty(t, page.NextCursor, "Should not have a next page.")
+		for _, book := range page.Books {
+			assert.Len(t, book.Copies, 1, "Should list the copies of each book.")
+		}
+	})
+
+	t.Run("List Pages", func(t *testing.T) {
+		store := newStore(t)
+		for _, title := range []string{"c", "a", "e", "b", "d"} {
+			createBook(t, store, title, true)
+		}
+
+		query := &datastore.BookQuery{Page: datastore.Page{Limit: 2, Sort: "-title"}}
+
+		var titles []string
+		for i := 0; i < 3; i++ {
+			page, err := store.ListBook(ctx, query)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(page.Books), 2, "Should return at most the limit.")
+
+			for _, book := range page.Books {
+				titles = append(titles, book.Title)
+				assert.Len(t, book.Copies, 1, "Should list the copies of each book.")
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		assert.Equal(t, []string{"e", "d", "c", "b", "a"}, titles, "Should page through every book in order.")
+	})
+
+	t.Run("List Filters", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, "Go Programming", true)
+		createBook(t, store, "Learning go", false)
+		createBook(t, store, "Rust", true)
+
+		page, err := store.ListBook(ctx, &datastore.BookQuery{Title: "GO"})
+		require.NoError(t, err)
+		assert.Len(t, page.Books, 2, "Should match titles containing the filter, ignoring case.")
+
+		available := true
+		page, err = store.ListBook(ctx, &datastore.BookQuery{Title: "go", Available: &available})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 1)
+		assert.Equal(t, "Go Programming", page.Books[0].Title)
+
+		page, err = store.ListBook(ctx, &datastore.BookQuery{Title: "%"})
+		require.NoError(t, err)
+		assert.Empty(t, page.Books, "Should not treat the filter as a pattern.")
+	})
+
+	t.Run("List Invalid Query", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, query := range []*datastore.BookQuery{
+			{Page: datastore.Page{Sort: "available"}},
+			{Page: datastore.Page{Limit: datastore.MaxPageSize + 1}},
+			{Page: datastore.Page{Cursor: "not a cursor"}},
+		} {
+			_, err := store.ListBook(ctx, query)
+			assert.True(t, errors.Is(err, datastore.ErrInvalidQuery), "Should reject %+v.", query.Page)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		createBook(t, store, "otherTitle", true)
+
+		updated, err := store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: "newTitle", Available: false})
+		require.NoError(t, err)
+		assert.Equal(t, "newTitle", updated.Title)
+		assert.True(t, updated.Available, "Availability should come from the copies.")
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, updated, book, "Should persist the update.")
+
+		_, err = store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: "otherTitle"})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "Titles should stay unique.")
+	})
+
+	t.Run("Update ISBN", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		_, err := store.CreateBook(ctx, &types.Book{Title: "otherTitle", ISBN: "0-306-40615-2"})
+		require.NoError(t, err)
+
+		updated, err := store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: testTitle, ISBN: "978-1-56619-909-4"})
+		require.NoError(t, err)
+		assert.Equal(t, "9781566199094", updated.ISBN, "Should store the normalized ISBN.")
+
+		updated, err = store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: "newTitle"})
+		require.NoError(t, err)
+		assert.Equal(t, "9781566199094", updated.ISBN, "Should keep the ISBN when none is given.")
+
+		_, err = store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: "newTitle", ISBN: "0306406152"})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "ISBNs should stay unique.")
+	})
+
+	t.Run("Update Renames Requests", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		_, err := store.UpdateBook(ctx, &types.Book{ID: created.ID, Title: "newTitle"})
+		require.NoError(t, err)
+
+		request, err = store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "newTitle", request.Title, "Should update the title on the book's requests.")
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Title: "newTitle"})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 1, "Should list requests by the new title.")
+		assert.Equal(t, request.ID, page.Requests[0].ID)
+
+		createBook(t, store, testTitle, false)
+		createWaitingRequest(t, store, testTitle)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+
+		require.NoError(t, store.DeleteBook(ctx, created.ID))
+
+		_, err := store.GetBook(ctx, created.ID)
+		assert.Equal(t, datastore.ErrNotFound, err, "Should not find a deleted book.")
+	})
+
+	t.Run("Delete With Open Request", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		err := store.DeleteBook(ctx, created.ID)
+		assert.Equal(t, datastore.ErrInUse, err, "Should not delete a book that is lent out.")
+
+		deleteRequest(t, store, request.ID)
+		require.NoError(t, store.DeleteBook(ctx, created.ID), "Should delete a book once its requests are closed.")
+
+		_, err = store.GetRequest(ctx, request.ID)
+		assert.NoError(t, err, "Should keep closed requests for history.")
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.GetBook(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		_, err = store.UpdateBook(ctx, &types.Book{ID: 123, Title: testTitle})
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		err = store.DeleteBook(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testCopies(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Add Copy", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, false)
+
+		c, err := store.AddCopy(ctx, created.ID, &types.Copy{Available: true})
+		require.NoError(t, err)
+		assert.NotZero(t, c.ID)
+		assert.Equal(t, created.ID, c.BookID)
+		assert.True(t, c.Available)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Len(t, book.Copies, 2)
+		assert.True(t, book.Available, "Should be available once a free copy is added.")
+	})
+
+	t.Run("Add Copy With Waitlist", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, false)
+		request := createWaitingRequest(t, store, testTitle)
+
+		c, err := store.AddCopy(ctx, created.ID, &types.Copy{Available: true})
+		require.NoError(t, err)
+		assert.False(t, c.Available, "Should hand the new copy to the waitlist.")
+
+		request, err = store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, request.Status)
+		assert.Equal(t, c.ID, request.CopyID, "Should grant the new copy.")
+	})
+
+	t.Run("Delete Copy", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+
+		c, err := store.AddCopy(ctx, created.ID, &types.Copy{Available: true})
+		require.NoError(t, err)
+
+		require.NoError(t, store.DeleteCopy(ctx, created.ID, c.ID))
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Len(t, book.Copies, 1, "Should remove the copy.")
+	})
+
+	t.Run("Delete Lent Copy", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		err := store.DeleteCopy(ctx, created.ID, request.CopyID)
+		assert.Equal(t, datastore.ErrInUse, err, "Should not delete a copy that is lent out.")
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		other := createBook(t, store, "otherTitle", true)
+
+		_, err := store.AddCopy(ctx, 123, &types.Copy{Available: true})
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		err = store.DeleteCopy(ctx, created.ID, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		err = store.DeleteCopy(ctx, created.ID, other.Copies[0].ID)
+		assert.Equal(t, datastore.ErrNotFound, err, "Should not delete a copy of another book.")
+	})
+}
+
+func testCreateRequest(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Available Book", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+
+		book, err := store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: testTitle})
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, book.ID, "Should return the requested book.")
+		assert.True(t, book.Available, "Should report the book was available.")
+
+		_, err = time.Parse(time.RFC3339, book.TimeRequested)
+		assert.NoError(t, err, "Should stamp an RFC3339 request time.")
+
+		stored, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.False(t, stored.Available, "Should mark the book unavailable.")
+		assert.Equal(t, book.TimeRequested, stored.TimeRequested)
+		assert.False(t, stored.Copies[0].Available, "Should mark the copy unavailable.")
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{})
+		require.NoError(t, err)
+		requests := page.Requests
+		require.Len(t, requests, 1, "Should create a request.")
+		assert.Equal(t, testEmail, requests[0].Email)
+		assert.Equal(t, testTitle, requests[0].Title)
+		assert.Equal(t, types.RequestStatusRequested, requests[0].Status, "Should start in the requested status.")
+		assert.Equal(t, created.ID, requests[0].BookID, "Should bind the request to the book.")
+		assert.Equal(t, created.Copies[0].ID, requests[0].CopyID, "Should bind the request to the copy.")
+		assert.False(t, requests[0].CreatedAt.IsZero(), "Should record when the request was created.")
+	})
+
+	t.Run("Unavailable Book", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, false)
+
+		request := &types.Request{Email: testEmail, Title: testTitle}
+		book, err := store.CreateRequest(ctx, request)
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, book.ID, "Should return the requested book.")
+		assert.False(t, book.Available, "Should report the book was unavailable.")
+		assert.NotZero(t, request.ID, "Should set the created request ID.")
+		assert.Equal(t, types.RequestStatusWaiting, request.Status, "Should add the request to the waitlist.")
+
+		stored, err := store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, request, stored)
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: testTitle})
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+
+	t.Run("Registers Patron By Email", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+
+		request := &types.Request{Email: " Test@Gmail.com ", Title: testTitle}
+		_, err := store.CreateRequest(ctx, request)
+		require.NoError(t, err)
+		assert.NotZero(t, request.PatronID, "Should register a patron for a new email.")
+		assert.Equal(t, testEmail, request.Email, "Should normalize the email.")
+
+		again := &types.Request{Email: "TEST@gmail.com", Title: testTitle}
+		_, err = store.CreateRequest(ctx, again)
+		require.NoError(t, err)
+		assert.Equal(t, request.PatronID, again.PatronID, "Emails differing by case should be the same patron.")
+
+		patrons, err := store.ListPatron(ctx)
+		require.NoError(t, err)
+		assert.Len(t, patrons, 1, "Should only register the patron once.")
+	})
+
+	t.Run("By Patron ID", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		patron := createPatron(t, store, testEmail)
+
+		request := &types.Request{PatronID: patron.ID, Title: testTitle}
+		_, err := store.CreateRequest(ctx, request)
+		require.NoError(t, err)
+		assert.Equal(t, patron.ID, request.PatronID)
+		assert.Equal(t, patron.Email, request.Email, "Should use the patron's email.")
+	})
+
+	t.Run("Unknown Patron", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+
+		_, err := store.CreateRequest(ctx, &types.Request{PatronID: 123, Title: testTitle})
+		assert.Equal(t, datastore.ErrUnknownPatron, err)
+	})
+}
+
+func testGetRequest(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Success Case", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		got, err := store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, request, got)
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.GetRequest(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testListRequest(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("All", func(t *testing.T) {
+		store := newStore(t)
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{})
+		require.NoError(t, err)
+		assert.NotNil(t, page.Requests, "Should return an empty list rather than nil.")
+		assert.Empty(t, page.Requests)
+
+		for _, title := range []string{"a", "b", "c"} {
+			createBook(t, store, title, true)
+			createRequest(t, store, title)
+		}
+
+		page, err = store.ListRequest(ctx, &datastore.RequestQuery{})
+		require.NoError(t, err)
+		assert.Len(t, page.Requests, 3, "Should list all requests.")
+		assert.Empty(t, page.NextCursor, "Should not have a next page.")
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		store := newStore(t)
+
+		var created []int
+		for _, title := range []string{"a", "b", "c", "d", "e"} {
+			createBook(t, store, title, true)
+			created = append(created, createRequest(t, store, title).ID)
+		}
+
+		for _, sort := range []string{"", "createdAt", "title", "-id"} {
+			query := &datastore.RequestQuery{Page: datastore.Page{Limit: 2, Sort: sort}}
+
+			var ids []int
+			for i := 0; i < 3; i++ {
+				page, err := store.ListRequest(ctx, query)
+				require.NoError(t, err)
+
+				for _, request := range page.Requests {
+					ids = append(ids, request.ID)
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+
+			expected := created
+			if sort == "-id" {
+				expected = []int{created[4], created[3], created[2], created[1], created[0]}
+			}
+			assert.Equal(t, expected, ids, "Should page through every request sorted by '%s'.", sort)
+		}
+	})
+
+	t.Run("Ties Sort By ID", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+
+		first := createRequest(t, store, testTitle)
+		second := createWaitingRequest(t, store, testTitle)
+		third := createWaitingRequest(t, store, testTitle)
+
+		query := &datastore.RequestQuery{Page: datastore.Page{Limit: 1, Sort: "-title"}}
+
+		var ids []int
+		for {
+			page, err := store.ListRequest(ctx, query)
+			require.NoError(t, err)
+			require.Len(t, page.Requests, 1)
+			ids = append(ids, page.Requests[0].ID)
+
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		assert.Equal(t, []int{third.ID, second.ID, first.ID}, ids, "Should sort requests with the same title by ID.")
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, "Go Programming", true)
+		createBook(t, store, "Rust", true)
+
+		goRequest := createRequest(t, store, "Go Programming")
+		otherRequest := &types.Request{Email: "other@example.com", Title: "Rust"}
+		_, err := store.CreateRequest(ctx, otherRequest)
+		require.NoError(t, err)
+		_, err = store.UpdateRequestStatus(ctx, otherRequest.ID, types.RequestStatusCancelled)
+		require.NoError(t, err)
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Email: "OTHER@example.com"})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 1, "Should match the normalized email.")
+		assert.Equal(t, otherRequest.ID, page.Requests[0].ID)
+
+		page, err = store.ListRequest(ctx, &datastore.RequestQuery{Title: "programming"})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 1, "Should match titles containing the filter, ignoring case.")
+		assert.Equal(t, goRequest.ID, page.Requests[0].ID)
+
+		page, err = store.ListRequest(ctx, &datastore.RequestQuery{
+			Statuses: []types.RequestStatus{types.RequestStatusCancelled, types.RequestStatusReturned},
+		})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 1, "Should match any of the statuses.")
+		assert.Equal(t, otherRequest.ID, page.Requests[0].ID)
+
+		after := goRequest.CreatedAt
+		page, err = store.ListRequest(ctx, &datastore.RequestQuery{CreatedAfter: &after})
+		require.NoError(t, err)
+		assert.Len(t, page.Requests, 2, "Should include requests created at the start of the range.")
+
+		page, err = store.ListRequest(ctx, &datastore.RequestQuery{CreatedBefore: &after})
+		require.NoError(t, err)
+		assert.Empty(t, page.Requests, "Should exclude requests created at the end of the range.")
+	})
+
+	t.Run("Invalid Query", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, query := range []*datastore.RequestQuery{
+			{Page: datastore.Page{Sort: "dueAt"}},
+			{Page: datastore.Page{Limit: -1}},
+			{Page: datastore.Page{Cursor: "not a cursor"}},
+			{Statuses: []types.RequestStatus{"lost"}},
+		} {
+			_, err := store.ListRequest(ctx, query)
+			assert.True(t, errors.Is(err, datastore.ErrInvalidQuery), "Should reject %+v.", query)
+		}
+
+		createBook(t, store, "a", true)
+		createRequest(t, store, "a")
+		createBook(t, store, "b", true)
+		createRequest(t, store, "b")
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Page: datastore.Page{Limit: 1, Sort: "title"}})
+		require.NoError(t, err)
+		require.NotEmpty(t, page.NextCursor)
+
+		_, err = store.ListRequest(ctx, &datastore.RequestQuery{Page: datastore.Page{Cursor: page.NextCursor, Sort: "email"}})
+		assert.True(t, errors.Is(err, datastore.ErrInvalidQuery), "Should reject a cursor for another sort order.")
+	})
+}
+
+func testDeleteRequest(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Cancels Request And Frees Book", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		deleteRequest(t, store, request.ID)
+
+		request, err := store.GetRequest(ctx, request.ID)
+		require.NoError(t, err, "Should keep the request for history.")
+		assert.Equal(t, types.RequestStatusCancelled, request.Status, "Should cancel a request that was not checked out.")
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.True(t, book.Available, "Should make the book available again.")
+		assert.Empty(t, book.TimeRequested, "Should clear the request time.")
+
+		// The freed book can be requested again
+		book, err = store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: testTitle})
+		require.NoError(t, err)
+		assert.True(t, book.Available)
+	})
+
+	t.Run("Returns Checked Out Request", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		_, err := store.UpdateRequestStatus(ctx, request.ID, types.RequestStatusCheckedOut)
+		require.NoError(t, err)
+
+		closed := deleteRequest(t, store, request.ID)
+		assert.Equal(t, types.RequestStatusReturned, closed.Status, "Should return the closed request.")
+
+		request, err = store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusReturned, request.Status, "Should return a checked out request.")
+	})
+
+	t.Run("Closed Request", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		deleteRequest(t, store, request.ID)
+
+		_, err := store.DeleteRequest(ctx, request.ID)
+		assert.Equal(t, datastore.ErrInvalidTransition, err, "Should not close a request twice.")
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.DeleteRequest(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testUpdateRequestStatus(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Checkout And Return", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		checkedOut, err := store.UpdateRequestStatus(ctx, request.ID, types.RequestStatusCheckedOut)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusCheckedOut, checkedOut.Status)
+		assert.False(t, checkedOut.UpdatedAt.Before(request.UpdatedAt), "Should update the modified time.")
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.False(t, book.Available, "Checked out book should stay unavailable.")
+
+		returned, err := store.UpdateRequestStatus(ctx, request.ID, types.RequestStatusReturned)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusReturned, returned.Status)
+
+		book, err = store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.True(t, book.Available, "Returned book should be available.")
+
+		got, err := store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, returned, got, "Should persist the status.")
+	})
+
+	t.Run("Invalid Transitions", func(t *testing.T) {
+		tests := []struct {
+			name string
+			path []types.RequestStatus
+			to   types.RequestStatus
+		}{
+			{name: "Return Before Checkout", to: types.RequestStatusReturned},
+			{name: "Back To Requested", to: types.RequestStatusRequested},
+			{name: "Cancel Checked Out", path: []types.RequestStatus{types.RequestStatusCheckedOut}, to: types.RequestStatusCancelled},
+			{name: "Checkout Cancelled", path: []types.RequestStatus{types.RequestStatusCancelled}, to: types.RequestStatusCheckedOut},
+			{name: "Checkout Returned", path: []types.RequestStatus{types.RequestStatusCheckedOut, types.RequestStatusReturned}, to: types.RequestStatusCheckedOut},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				store := newStore(t)
+				createBook(t, store, testTitle, true)
+				request := createRequest(t, store, testTitle)
+
+				for _, status := range test.path {
+					_, err := store.UpdateRequestStatus(ctx, request.ID, status)
+					require.NoError(t, err)
+				}
+
+				_, err := store.UpdateRequestStatus(ctx, request.ID, test.to)
+				assert.Equal(t, datastore.ErrInvalidTransition, err)
+			})
+		}
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.UpdateRequestStatus(ctx, 123, types.RequestStatusCheckedOut)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testWaitlist(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("First In First Out", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+
+		first := createRequest(t, store, testTitle)
+		second := createWaitingRequest(t, store, testTitle)
+		third := createWaitingRequest(t, store, testTitle)
+
+		assertQueuePosition(t, store, second.ID, 1)
+		assertQueuePosition(t, store, third.ID, 2)
+
+		_, err := store.UpdateRequestStatus(ctx, first.ID, types.RequestStatusCheckedOut)
+		require.NoError(t, err)
+		_, err = store.UpdateRequestStatus(ctx, first.ID, types.RequestStatusReturned)
+		require.NoError(t, err)
+
+		second, err = store.GetRequest(ctx, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, second.Status, "Should grant the book to the first waiting request.")
+		assertQueuePosition(t, store, second.ID, 0)
+		assertQueuePosition(t, store, third.ID, 1)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.False(t, book.Available, "Book should stay unavailable while it is handed on.")
+		assert.NotEmpty(t, book.TimeRequested)
+
+		deleteRequest(t, store, second.ID)
+
+		third, err = store.GetRequest(ctx, third.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, third.Status, "Deleting should also hand the book on.")
+
+		deleteRequest(t, store, third.ID)
+
+		book, err = store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.True(t, book.Available, "Book should be available once the waitlist is empty.")
+	})
+
+	t.Run("Cancel Waiting Request", func(t *testing.T) {
+		store := newStore(t)
+		created := createBook(t, store, testTitle, true)
+
+		first := createRequest(t, store, testTitle)
+		second := createWaitingRequest(t, store, testTitle)
+		third := createWaitingRequest(t, store, testTitle)
+
+		deleteRequest(t, store, second.ID)
+
+		second, err := store.GetRequest(ctx, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusCancelled, second.Status)
+		assertQueuePosition(t, store, third.ID, 1)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.False(t, book.Available, "Cancelling a waiting request should not free the book.")
+
+		first, err = store.GetRequest(ctx, first.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, first.Status, "Should not affect the request holding the book.")
+	})
+
+	t.Run("Waiting Request Cannot Be Checked Out", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, false)
+		request := createWaitingRequest(t, store, testTitle)
+
+		_, err := store.UpdateRequestStatus(ctx, request.ID, types.RequestStatusCheckedOut)
+		assert.Equal(t, datastore.ErrInvalidTransition, err)
+	})
+
+	t.Run("Request Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.GetQueuePosition(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testMultipleCopies(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	created, err := store.CreateBook(ctx, &types.Book{
+		Title:  testTitle,
+		Copies: []*types.Copy{{Available: true}, {Available: true}},
+	})
+	require.NoError(t, err)
+
+	first := createRequest(t, store, testTitle)
+	second := createRequest(t, store, testTitle)
+	assert.NotEqual(t, first.CopyID, second.CopyID, "Each request should get its own copy.")
+
+	book, err := store.GetBook(ctx, created.ID)
+	require.NoError(t, err)
+	assert.False(t, book.Available, "Should be unavailable once every copy is lent.")
+
+	third := createWaitingRequest(t, store, testTitle)
+	assert.Zero(t, third.CopyID, "Waiting requests should not have a copy.")
+
+	deleteRequest(t, store, second.ID)
+
+	third, err = store.GetRequest(ctx, third.ID)
+	require.NoError(t, err)
+	assert.Equal(t, types.RequestStatusRequested, third.Status)
+	assert.Equal(t, second.CopyID, third.CopyID, "Should hand the freed copy to the waiting request.")
+
+	deleteRequest(t, store, first.ID)
+
+	book, err = store.GetBook(ctx, created.ID)
+	require.NoError(t, err)
+	assert.True(t, book.Available, "Should be available once a copy is returned.")
+	for _, c := range book.Copies {
+		assert.Equal(t, c.ID != third.CopyID, c.Available, "Only the copy held by the request should be unavailable.")
+	}
+}
+
+func testLoans(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	policy := datastore.DefaultLoanPolicy
+
+	t.Run("Due Date", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+
+		request := createRequest(t, store, testTitle)
+		require.NotNil(t, request.DueAt, "Should set a due date when a copy is lent.")
+		assert.WithinDuration(t, time.Now().Add(policy.LoanDuration), *request.DueAt, time.Minute)
+		assert.Zero(t, request.Renewals)
+
+		waiting := createWaitingRequest(t, store, testTitle)
+		assert.Nil(t, waiting.DueAt, "Waiting requests should not have a due date.")
+
+		deleteRequest(t, store, request.ID)
+
+		waiting, err := store.GetRequest(ctx, waiting.ID)
+		require.NoError(t, err)
+		require.NotNil(t, waiting.DueAt, "Should set a due date when the copy is handed to the waitlist.")
+		assert.WithinDuration(t, time.Now().Add(policy.LoanDuration), *waiting.DueAt, time.Minute)
+	})
+
+	t.Run("Renew", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+
+		_, err := store.UpdateRequestStatus(ctx, request.ID, types.RequestStatusCheckedOut)
+		require.NoError(t, err)
+
+		dueAt := *request.DueAt
+		for i := 1; i <= policy.MaxRenewals; i++ {
+			renewed, err := store.RenewRequest(ctx, request.ID)
+			require.NoError(t, err)
+			assert.Equal(t, i, renewed.Renewals)
+			assert.WithinDuration(t, dueAt.Add(policy.LoanDuration), *renewed.DueAt, time.Second, "Should extend the loan by a loan period.")
+			dueAt = *renewed.DueAt
+		}
+
+		_, err = store.RenewRequest(ctx, request.ID)
+		assert.Equal(t, datastore.ErrRenewalLimit, err, "Should refuse renewals past the limit.")
+
+		got, err := store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, policy.MaxRenewals, got.Renewals, "Should persist the renewals.")
+		assert.WithinDuration(t, dueAt, *got.DueAt, time.Second)
+	})
+
+	t.Run("Renew With Hold Queue", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+		createWaitingRequest(t, store, testTitle)
+
+		_, err := store.RenewRequest(ctx, request.ID)
+		assert.Equal(t, datastore.ErrHoldQueue, err, "Should refuse renewals when others are waiting.")
+	})
+
+	t.Run("Renew Without Copy", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		request := createRequest(t, store, testTitle)
+		waiting := createWaitingRequest(t, store, testTitle)
+
+		_, err := store.RenewRequest(ctx, waiting.ID)
+		assert.Equal(t, datastore.ErrInvalidTransition, err, "Should not renew a waiting request.")
+
+		deleteRequest(t, store, waiting.ID)
+		deleteRequest(t, store, request.ID)
+
+		_, err = store.RenewRequest(ctx, request.ID)
+		assert.Equal(t, datastore.ErrInvalidTransition, err, "Should not renew a closed request.")
+
+		_, err = store.RenewRequest(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+
+	t.Run("Overdue", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.CreateBook(ctx, &types.Book{
+			Title:  testTitle,
+			Copies: []*types.Copy{{Available: true}, {Available: true}, {Available: true}},
+		})
+		require.NoError(t, err)
+
+		first := createRequest(t, store, testTitle)
+		second := createRequest(t, store, testTitle)
+		returned := createRequest(t, store, testTitle)
+		deleteRequest(t, store, returned.ID)
+
+		_, err = store.RenewRequest(ctx, first.ID)
+		require.NoError(t, err)
+
+		overdue, err := store.ListOverdueRequests(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, overdue, "Should not list requests that are not due yet.")
+
+		overdue, err = store.ListOverdueRequests(ctx, time.Now().Add(policy.LoanDuration+time.Hour))
+		require.NoError(t, err)
+		require.Len(t, overdue, 1, "Should only list open requests past their due date.")
+		assert.Equal(t, second.ID, overdue[0].ID)
+
+		overdue, err = store.ListOverdueRequests(ctx, time.Now().Add(3*policy.LoanDuration))
+		require.NoError(t, err)
+		require.Len(t, overdue, 2)
+		assert.Equal(t, second.ID, overdue[0].ID, "Should list the most overdue request first.")
+		assert.Equal(t, first.ID, overdue[1].ID)
+	})
+}
+
+func testPatrons(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create And Get", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreatePatron(ctx, &types.Patron{Email: " Test@Gmail.COM", Name: "Test Patron"})
+		require.NoError(t, err)
+		assert.NotZero(t, created.ID, "Should generate a patron ID.")
+		assert.Equal(t, testEmail, created.Email, "Should normalize the email.")
+		assert.Equal(t, "Test Patron", created.Name)
+		assert.False(t, created.CreatedAt.IsZero(), "Should record when the patron was created.")
+
+		patron, err := store.GetPatron(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, patron.ID)
+		assert.Equal(t, created.Email, patron.Email)
+
+		patrons, err := store.ListPatron(ctx)
+		require.NoError(t, err)
+		require.Len(t, patrons, 1)
+		assert.Equal(t, created.ID, patrons[0].ID)
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		store := newStore(t)
+		createPatron(t, store, testEmail)
+
+		_, err := store.CreatePatron(ctx, &types.Patron{Email: "TEST@gmail.com"})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "Emails should be unique ignoring case.")
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		patron := createPatron(t, store, testEmail)
+		other := createPatron(t, store, "other@gmail.com")
+
+		request := &types.Request{PatronID: patron.ID, Title: testTitle}
+		_, err := store.CreateRequest(ctx, request)
+		require.NoError(t, err)
+
+		updated, err := store.UpdatePatron(ctx, &types.Patron{ID: patron.ID, Email: "New@gmail.com", Name: "New Name"})
+		require.NoError(t, err)
+		assert.Equal(t, "new@gmail.com", updated.Email)
+		assert.Equal(t, "New Name", updated.Name)
+
+		request, err = store.GetRequest(ctx, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "new@gmail.com", request.Email, "Should update the email on the patron's requests.")
+
+		_, err = store.UpdatePatron(ctx, &types.Patron{ID: patron.ID, Email: other.Email})
+		assert.Equal(t, datastore.ErrAlreadyExists, err, "Emails should stay unique.")
+	})
+
+	t.Run("Current Requests", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, true)
+		createBook(t, store, "otherTitle", true)
+		patron := createPatron(t, store, testEmail)
+		other := createPatron(t, store, "other@gmail.com")
+
+		closed := &types.Request{PatronID: patron.ID, Title: testTitle}
+		_, err := store.CreateRequest(ctx, closed)
+		require.NoError(t, err)
+		deleteRequest(t, store, closed.ID)
+
+		lent := &types.Request{PatronID: patron.ID, Title: testTitle}
+		_, err = store.CreateRequest(ctx, lent)
+		require.NoError(t, err)
+
+		_, err = store.CreateRequest(ctx, &types.Request{PatronID: other.ID, Title: "otherTitle"})
+		require.NoError(t, err)
+
+		waiting := &types.Request{PatronID: patron.ID, Title: "otherTitle"}
+		_, err = store.CreateRequest(ctx, waiting)
+		require.NoError(t, err)
+
+		requests, err := store.ListPatronRequests(ctx, patron.ID)
+		require.NoError(t, err)
+		require.Len(t, requests, 2, "Should only list the patron's open requests.")
+		assert.Equal(t, lent.ID, requests[0].ID)
+		assert.Equal(t, waiting.ID, requests[1].ID)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.GetPatron(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		_, err = store.UpdatePatron(ctx, &types.Patron{ID: 123, Email: testEmail})
+		assert.Equal(t, datastore.ErrNotFound, err)
+
+		_, err = store.ListPatronRequests(ctx, 123)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testPolicies(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	newPolicyStore := func(t *testing.T, policies ...datastore.Policy) apiserver.LibraryStore {
+		store := newStore(t)
+
+		policyStore, ok := store.(PolicyStore)
+		require.True(t, ok, "Store should support borrowing policies.")
+		policyStore.SetPolicies(policies...)
+
+		return store
+	}
+
+	t.Run("Max Loans", func(t *testing.T) {
+		store := newPolicyStore(t, datastore.MaxLoansPolicy{Limit: 1})
+		createBook(t, store, testTitle, true)
+		createBook(t, store, "otherTitle", true)
+
+		first := createRequest(t, store, testTitle)
+
+		_, err := store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: "otherTitle"})
+		assert.True(t, errors.Is(err, datastore.ErrLimitExceeded), "Should refuse a second loan.")
+
+		_, err = store.CreateRequest(ctx, &types.Request{Email: "other@gmail.com", Title: "otherTitle"})
+		assert.NoError(t, err, "Other patrons should not be limited.")
+
+		deleteRequest(t, store, first.ID)
+		createBook(t, store, "thirdTitle", true)
+		createRequest(t, store, "thirdTitle")
+	})
+
+	t.Run("Max Holds", func(t *testing.T) {
+		store := newPolicyStore(t, datastore.MaxHoldsPolicy{Limit: 1})
+		createBook(t, store, testTitle, false)
+		createBook(t, store, "otherTitle", false)
+
+		createWaitingRequest(t, store, testTitle)
+
+		_, err := store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: "otherTitle"})
+		assert.True(t, errors.Is(err, datastore.ErrLimitExceeded), "Should refuse a second hold.")
+	})
+
+	t.Run("Restrictions", func(t *testing.T) {
+		store := newPolicyStore(t,
+			datastore.BlockedPatronsPolicy{Emails: []string{"blocked@gmail.com"}},
+			datastore.TitleRestriction{Title: testTitle, AllowedPatrons: []string{testEmail}},
+		)
+		created := createBook(t, store, testTitle, true)
+
+		_, err := store.CreateRequest(ctx, &types.Request{Email: "Blocked@gmail.com", Title: testTitle})
+		assert.True(t, errors.Is(err, datastore.ErrRestricted), "Should refuse blocked patrons.")
+
+		_, err = store.CreateRequest(ctx, &types.Request{Email: "other@gmail.com", Title: testTitle})
+		var violation *datastore.PolicyViolation
+		require.True(t, errors.As(err, &violation), "Should refuse patrons not allowed the title.")
+		assert.Equal(t, "title-restriction", violation.Rule)
+
+		patrons, err := store.ListPatron(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, patrons, "Should not register patrons for refused requests.")
+
+		createRequest(t, store, testTitle)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.False(t, book.Available, "Only the allowed request should take the copy.")
+	})
+
+	t.Run("Freed Copy", func(t *testing.T) {
+		store := newPolicyStore(t)
+		created := createBook(t, store, testTitle, true)
+		createBook(t, store, "otherTitle", true)
+
+		request := func(email, title string) *types.Request {
+			request := &types.Request{Email: email, Title: title}
+			_, err := store.CreateRequest(ctx, request)
+			require.NoError(t, err)
+			return request
+		}
+
+		lent := request("lender@gmail.com", testTitle)
+		blocked := request("blocked@gmail.com", testTitle)
+		request("busy@gmail.com", "otherTitle")
+		busy := request("busy@gmail.com", testTitle)
+		allowed := request(testEmail, testTitle)
+
+		// The policies change while the requests are waiting
+		store.(PolicyStore).SetPolicies(
+			datastore.BlockedPatronsPolicy{Emails: []string{"blocked@gmail.com"}},
+			datastore.MaxLoansPolicy{Limit: 1},
+		)
+
+		deleteRequest(t, store, lent.ID)
+
+		got, err := store.GetRequest(ctx, blocked.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusCancelled, got.Status, "Should cancel the request of a restricted patron.")
+
+		got, err = store.GetRequest(ctx, busy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusWaiting, got.Status, "Should skip a patron over the loan limit.")
+
+		got, err = store.GetRequest(ctx, allowed.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.RequestStatusRequested, got.Status, "Should lend the copy to the next allowed request.")
+
+		// With nobody else allowed to borrow it the copy is made available
+		deleteRequest(t, store, allowed.ID)
+		assertQueuePosition(t, store, busy.ID, 1)
+
+		book, err := store.GetBook(ctx, created.ID)
+		require.NoError(t, err)
+		assert.True(t, book.Available)
+	})
+}
+
+func testAPIKeys(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create And Get", func(t *testing.T) {
+		store := newStore(t)
+		patron := createPatron(t, store, testEmail)
+
+		created, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "test", PatronID: patron.ID, Role: "patron", Hash: "testhash"})
+		require.NoError(t, err)
+		assert.NotZero(t, created.ID, "Should generate a key ID.")
+		assert.Equal(t, "test", created.Name)
+		assert.Equal(t, patron.ID, created.PatronID)
+		assert.Equal(t, "patron", created.Role)
+		assert.False(t, created.CreatedAt.IsZero(), "Should record when the key was created.")
+
+		key, err := store.GetAPIKeyByHash(ctx, "testhash")
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, key.ID)
+		assert.Equal(t, patron.ID, key.PatronID)
+		assert.Equal(t, "patron", key.Role)
+
+		_, err = store.GetAPIKeyByHash(ctx, "otherhash")
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+
+	t.Run("Duplicate Hash", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "test", Role: "admin", Hash: "testhash"})
+		require.NoError(t, err)
+
+		_, err = store.CreateAPIKey(ctx, &types.APIKey{Name: "other", Role: "admin", Hash: "testhash"})
+		assert.Equal(t, datastore.ErrAlreadyExists, err)
+	})
+
+	t.Run("Unknown Patron", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "test", PatronID: 123, Role: "patron", Hash: "testhash"})
+		assert.Equal(t, datastore.ErrUnknownPatron, err)
+	})
+
+	t.Run("List And Delete", func(t *testing.T) {
+		store := newStore(t)
+
+		first, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "first", Role: "admin", Hash: "firsthash"})
+		require.NoError(t, err)
+		second, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "second", Role: "librarian", Hash: "secondhash"})
+		require.NoError(t, err)
+
+		keys, err := store.ListAPIKeys(ctx)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, first.ID, keys[0].ID)
+		assert.Equal(t, second.ID, keys[1].ID)
+
+		require.NoError(t, store.DeleteAPIKey(ctx, first.ID))
+
+		_, err = store.GetAPIKeyByHash(ctx, "firsthash")
+		assert.Equal(t, datastore.ErrNotFound, err, "Should not find a revoked key.")
+
+		err = store.DeleteAPIKey(ctx, first.ID)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func testConcurrentCreateRequest(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	store := newStore(t)
+	createBook(t, store, testTitle, true)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners int
+		errs    []error
+	)
+
+	for i := 0; i < numConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			book, err := store.CreateRequest(ctx, &types.Request{Email: testEmail, Title: testTitle})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if book.Available {
+				winners++
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Empty(t, errs, "Concurrent requests should not fail.")
+	assert.Equal(t, 1, winners, "Exactly one request should get the book.")
+
+	page, err := store.ListRequest(ctx, &datastore.RequestQuery{})
+	require.NoError(t, err)
+	requests := page.Requests
+	require.Len(t, requests, numConcurrentRequests, "Every request should be created.")
+
+	positions := map[int]bool{}
+	for _, request := range requests {
+		position, err := store.GetQueuePosition(ctx, request.ID)
+		require.NoError(t, err)
+
+		if request.Status == types.RequestStatusRequested {
+			continue
+		}
+
+		assert.Equal(t, types.RequestStatusWaiting, request.Status, "Losing requests should be waiting.")
+		positions[position.Position] = true
+	}
+
+	for i := 1; i < numConcurrentRequests; i++ {
+		assert.True(t, positions[i], "Waiting requests should have distinct queue positions, missing %d.", i)
+	}
+}
+
+func testSeed(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	fixtures := &datastore.Fixtures{
+		Books: []*types.Book{
+			{Title: testTitle, Copies: []*types.Copy{{Available: true}, {Available: true}}},
+			{Title: "otherTitle", Available: true},
+		},
+		Patrons: []*types.Patron{{Email: testEmail, Name: "Test Patron"}},
+		Requests: []*types.Request{
+			{Email: testEmail, Title: testTitle, Status: types.RequestStatusReturned},
+			{Email: testEmail, Title: "otherTitle", Status: types.RequestStatusCancelled},
+			{Email: "new@gmail.com", Title: testTitle},
+		},
+	}
+
+	t.Run("Idempotent", func(t *testing.T) {
+		store := newStore(t)
+
+		summary, err := datastore.Seed(ctx, store, fixtures)
+		require.NoError(t, err)
+		assert.Equal(t, &datastore.SeedSummary{Books: 2, Copies: 3, Patrons: 1, Requests: 3}, summary)
+
+		summary, err = datastore.Seed(ctx, store, fixtures)
+		require.NoError(t, err)
+		assert.Equal(t, &datastore.SeedSummary{}, summary, "Should add nothing when seeded again.")
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Page: datastore.Page{Sort: "id"}})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 3)
+		assert.Equal(t, types.RequestStatusReturned, page.Requests[0].Status)
+		assert.Equal(t, types.RequestStatusCancelled, page.Requests[1].Status)
+		assert.Equal(t, types.RequestStatusRequested, page.Requests[2].Status)
+		assert.NotZero(t, page.Requests[2].PatronID, "Should register patrons by their first request.")
+	})
+
+	t.Run("Adds Missing Copies", func(t *testing.T) {
+		store := newStore(t)
+		book := createBook(t, store, testTitle, true)
+
+		summary, err := datastore.Seed(ctx, store, &datastore.Fixtures{Books: fixtures.Books[:1]})
+		require.NoError(t, err)
+		assert.Equal(t, &datastore.SeedSummary{Copies: 1}, summary)
+
+		book, err = store.GetBook(ctx, book.ID)
+		require.NoError(t, err)
+		assert.Len(t, book.Copies, 2)
+	})
+
+	t.Run("Renames Patrons", func(t *testing.T) {
+		store := newStore(t)
+		patron := createPatron(t, store, testEmail)
+
+		_, err := datastore.Seed(ctx, store, &datastore.Fixtures{Patrons: []*types.Patron{{Email: "TEST@gmail.com", Name: "Renamed"}}})
+		require.NoError(t, err)
+
+		patron, err = store.GetPatron(ctx, patron.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed", patron.Name)
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		store := newStore(t)
+		generated := datastore.GenerateFixtures(datastore.GenerateOptions{Seed: 1, Books: 10, Patrons: 5, Requests: 20})
+
+		summary, err := datastore.Seed(ctx, store, generated)
+		require.NoError(t, err)
+		assert.Equal(t, 10, summary.Books)
+		assert.Equal(t, 5, summary.Patrons)
+		assert.Equal(t, len(generated.Requests), summary.Requests)
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Page: datastore.Page{Limit: datastore.MaxPageSize}})
+		require.NoError(t, err)
+		createdAt := map[string]time.Time{}
+		for _, request := range page.Requests {
+			createdAt[request.Email+" "+request.Title] = request.CreatedAt
+		}
+		for _, request := range generated.Requests {
+			assert.True(t, request.CreatedAt.Equal(createdAt[request.Email+" "+request.Title]), "Should keep when generated requests were made.")
+		}
+
+		summary, err = datastore.Seed(ctx, store, generated)
+		require.NoError(t, err)
+		assert.Equal(t, &datastore.SeedSummary{}, summary)
+	})
+
+	t.Run("Generated Again With More Requests", func(t *testing.T) {
+		store := newStore(t)
+
+		// Open requests from the first seed hold copies, so more of the second seed's requests wait
+		for _, requests := range []int{20, 200} {
+			generated := datastore.GenerateFixtures(datastore.GenerateOptions{Seed: 1, Books: 3, Patrons: 20, Requests: requests})
+			_, err := datastore.Seed(ctx, store, generated)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Closed Request Left Waiting", func(t *testing.T) {
+		store := newStore(t)
+		createBook(t, store, testTitle, false)
+
+		summary, err := datastore.Seed(ctx, store, &datastore.Fixtures{Requests: []*types.Request{
+			{Email: testEmail, Title: testTitle, Status: types.RequestStatusReturned},
+			{Email: "other@gmail.com", Title: testTitle, Status: types.RequestStatusCheckedOut},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Requests)
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 2)
+		statuses := map[string]types.RequestStatus{}
+		for _, request := range page.Requests {
+			statuses[request.Email] = request.Status
+		}
+		assert.Equal(t, types.RequestStatusCancelled, statuses[testEmail], "Waiting requests that should be closed are cancelled.")
+		assert.Equal(t, types.RequestStatusWaiting, statuses["other@gmail.com"], "Waiting requests that should be open keep waiting.")
+	})
+}
+
+func createBook(t *testing.T, store apiserver.LibraryStore, title string, available bool) *types.Book {
+	t.Helper()
+
+	book, err := store.CreateBook(context.Background(), &types.Book{Title: title, Available: available})
+	require.NoError(t, err)
+
+	return book
+}
+
+func createPatron(t *testing.T, store apiserver.LibraryStore, email string) *types.Patron {
+	t.Helper()
+
+	patron, err := store.CreatePatron(context.Background(), &types.Patron{Email: email})
+	require.NoError(t, err)
+
+	return patron
+}
+
+// createRequest requests the available book with the given title and returns the stored request
+func createRequest(t *testing.T, store apiserver.LibraryStore, title string) *types.Request {
+	t.Helper()
+
+	request := &types.Request{Email: testEmail, Title: title}
+	book, err := store.CreateRequest(context.Background(), request)
+	require.NoError(t, err)
+	require.True(t, book.Available, "Book should be available to request.")
+	require.Equal(t, types.RequestStatusRequested, request.Status)
+
+	return request
+}
+
+// createWaitingRequest requests the unavailable book with the given title and returns the stored request
+func createWaitingRequest(t *testing.T, store apiserver.LibraryStore, title string) *types.Request {
+	t.Helper()
+
+	request := &types.Request{Email: testEmail, Title: title}
+	book, err := store.CreateRequest(context.Background(), request)
+	require.NoError(t, err)
+	require.False(t, book.Available, "Book should be unavailable.")
+	require.Equal(t, types.RequestStatusWaiting, request.Status)
+
+	return request
+}
+
+// deleteRequest closes the request, returning it with its closing status
+func deleteRequest(t *testing.T, store apiserver.LibraryStore, requestID int) *types.Request {
+	t.Helper()
+
+	request, err := store.DeleteRequest(context.Background(), requestID)
+	require.NoError(t, err)
+	return request
+}
+
+func assertQueuePosition(t *testing.T, store apiserver.LibraryStore, requestID, expected int) {
+	t.Helper()
+
+	position, err := store.GetQueuePosition(context.Background(), requestID)
+	require.NoError(t, err)
+	assert.Equal(t, expected, position.Position, "Request %d should be at queue position %d.", requestID, expected)
+}
@@ -0,0 +1,157 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"go.opencensus.io/trace"
+)
+
+// tracedDB is a database that records a span for every statement. Spans are named by the statement's
+// verb and table and carry the statement, but never its arguments.
+type tracedDB struct {
+	*sql.DB
+}
+
+func (db *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startStatementSpan(ctx, query)
+	defer span.End()
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	setSpanError(span, err)
+	return rows, err
+}
+
+func (db *tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startStatementSpan(ctx, query)
+	defer span.End()
+
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	setSpanError(span, row.Err())
+	return row
+}
+
+func (db *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startStatementSpan(ctx, query)
+	defer span.End()
+
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	setSpanError(span, err)
+	return res, err
+}
+
+// begin starts a transaction with a span named after the operation, which ends when the transaction is
+// committed or rolled back. The spans of the transaction's statements are its children.
+func (db *tracedDB) begin(ctx context.Context, operation string) (*tracedTx, error) {
+	_, span := trace.StartSpan(ctx, "sql transaction "+operation)
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		setSpanError(span, err)
+		span.End()
+		return nil, err
+	}
+
+	return &tracedTx{Tx: tx, span: span}, nil
+}
+
+// tracedTx is a transaction that records a span for every statement, as children of the transaction's span
+type tracedTx struct {
+	*sql.Tx
+	span *trace.Span
+	end  sync.Once
+}
+
+func (tx *tracedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	_, span := startStatementSpan(trace.NewContext(ctx, tx.span), query)
+	defer span.End()
+
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	setSpanError(span, err)
+	return rows, err
+}
+
+func (tx *tracedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	_, span := startStatementSpan(trace.NewContext(ctx, tx.span), query)
+	defer span.End()
+
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	setSpanError(span, row.Err())
+	return row
+}
+
+func (tx *tracedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	_, span := startStatementSpan(trace.NewContext(ctx, tx.span), query)
+	defer span.End()
+
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	setSpanError(span, err)
+	return res, err
+}
+
+// Commit commits the transaction and ends its span
+func (tx *tracedTx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.finish("commit", err)
+	return err
+}
+
+// Rollback rolls back the transaction and ends its span, unless it was already committed
+func (tx *tracedTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if err == sql.ErrTxDone {
+		return err
+	}
+
+	tx.finish("rollback", err)
+	return err
+}
+
+func (tx *tracedTx) finish(outcome string, err error) {
+	tx.end.Do(func() {
+		tx.span.AddAttributes(trace.StringAttribute("db.outcome", outcome))
+		setSpanError(tx.span, err)
+		tx.span.End()
+	})
+}
+
+// startStatementSpan starts a span for the SQL statement
+func startStatementSpan(ctx context.Context, query string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, "sql "+statementName(query), trace.WithSpanKind(trace.SpanKindClient))
+	span.AddAttributes(
+		trace.StringAttribute("db.system", "postgresql"),
+		trace.StringAttribute("db.statement", query),
+	)
+
+	return ctx, span
+}
+
+// statementName names the SQL statement by its verb and the first table it uses, such as "SELECT books"
+func statementName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+
+	verb := strings.ToUpper(fields[0])
+	for i, field := range fields[:len(fields)-1] {
+		switch strings.ToUpper(field) {
+		case "FROM", "INTO", "UPDATE":
+			return verb + " " + fields[i+1]
+		}
+	}
+
+	return verb
+}
+
+// setSpanError marks the span as failed if there was an error. Rows that weren't found are expected and
+// don't fail the span.
+func setSpanError(span *trace.Span, err error) {
+	if err == nil || err == sql.ErrNoRows {
+		return
+	}
+
+	span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+}
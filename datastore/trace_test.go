@@ -0,0 +1,22 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementName(t *testing.T) {
+	for query, want := range map[string]string{
+		"SELECT " + requestColumns + " FROM requests WHERE id=$1 FOR UPDATE":                "SELECT requests",
+		"SELECT COUNT(*) FILTER (WHERE status IN ($2, $3)) FROM requests WHERE patronId=$1": "SELECT requests",
+		"INSERT INTO copies (bookId, available) VALUES ($1, $2)":                            "INSERT copies",
+		"UPDATE copies SET timeRequested='', available=true WHERE id=$1":                    "UPDATE copies",
+		"DELETE FROM api_keys WHERE id=$1":                                                  "DELETE api_keys",
+		"\n\tselect id\n\tfrom books":                                                       "SELECT books",
+		"BEGIN":                                                                             "BEGIN",
+		"":                                                                                  "unknown",
+	} {
+		assert.Equal(t, want, statementName(query), "Query %q.", query)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/samkreter/givedirectly
 
-go 1.15
+go 1.16
 
 require (
 	github.com/badoux/checkmail v1.2.1
@@ -12,6 +12,7 @@ require (
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
 	github.com/stretchr/testify v1.7.0
-	go.opencensus.io v0.23.0 // indirect
+	go.opencensus.io v0.23.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	syreclabs.com/go/faker v1.2.3
 )
@@ -0,0 +1,564 @@
+// Package importer adds books and requests to the library in bulk from CSV or JSON Lines files, validating
+// every row and reporting the rows that could not be imported
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+// Format is the format of an import file
+type Format string
+
+const (
+	// FormatCSV a CSV file with a header row naming the columns
+	FormatCSV Format = "csv"
+	// FormatJSONL a JSON object per line, in the format of the API and the export command
+	FormatJSONL Format = "jsonl"
+)
+
+// Kind is what an import file contains
+type Kind string
+
+const (
+	KindBooks    Kind = "books"
+	KindRequests Kind = "requests"
+)
+
+const (
+	// DefaultBatchSize is how many books are added in each transaction when no batch size is given
+	DefaultBatchSize = 100
+
+	// MaxCopies is the most copies a book can be imported with
+	MaxCopies = 1000
+
+	// maxLineSize is the longest line of a JSON Lines file
+	maxLineSize = 1024 * 1024
+)
+
+// ErrInvalidFile the file can't be read at all, such as a CSV file with an unknown column
+var ErrInvalidFile = errors.New("invalid import file")
+
+// Store is the library imported into, implemented by every LibraryStore
+type Store interface {
+	datastore.Seeder
+	CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error)
+}
+
+// Options is how a file is imported
+type Options struct {
+	Format Format
+	Kind   Kind
+	// BatchSize is how many books are added in each transaction, DefaultBatchSize if 0
+	BatchSize int
+}
+
+// ParseFormat returns the format with the name, either "csv" or "jsonl"
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatCSV, FormatJSONL:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown import format '%s', must be csv or jsonl", name)
+	}
+}
+
+// FormatOf returns the format of the file from its extension, JSON Lines unless it ends in .csv
+func FormatOf(file string) Format {
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return FormatCSV
+	}
+
+	return FormatJSONL
+}
+
+// Import adds the rows of the file to the store. Rows that are invalid or already in the library are
+// reported without stopping the import, so an error is only returned if the file can't be read, wrapping
+// ErrInvalidFile if it is malformed. The report counts the rows imported before the error.
+//
+// Books are matched to the catalog, and to earlier rows, by title and ISBN, and added in batches that are
+// each a single transaction. Requests are matched by the patron's email and the title, and made one at a
+// time like seeded requests.
+func Import(ctx context.Context, store Store, r io.Reader, opts Options) (*types.ImportReport, error) {
+	rows, err := newRowReader(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Kind {
+	case KindBooks, "":
+		batchSize := opts.BatchSize
+		if batchSize <= 0 {
+			batchSize = DefaultBatchSize
+		}
+		return importBooks(ctx, store, rows, batchSize)
+	case KindRequests:
+		return importRequests(ctx, store, rows)
+	default:
+		return nil, fmt.Errorf("unknown import kind '%s', must be books or requests", opts.Kind)
+	}
+}
+
+// fail records a row that is invalid or could not be added
+func fail(r *types.ImportReport, row int, field, message string) {
+	r.Failed++
+	r.Errors = append(r.Errors, types.ImportError{Row: row, Field: field, Message: message})
+}
+
+// skip records a row that is already in the library
+func skip(r *types.ImportReport, row int, field, message string) {
+	r.Skipped++
+	r.Errors = append(r.Errors, types.ImportError{Row: row, Field: field, Message: message, Skipped: true})
+}
+
+// bookRow is a valid book read from the file
+type bookRow struct {
+	row  int
+	book *types.Book
+}
+
+// bookImporter adds books in batches, skipping titles and ISBNs in the catalog or earlier in the file
+type bookImporter struct {
+	store     Store
+	report    *types.ImportReport
+	batchSize int
+	batch     []bookRow
+
+	// titles and isbns map the titles and ISBNs already seen to the row they were read from, 0 for the catalog
+	titles map[string]int
+	isbns  map[string]int
+}
+
+func importBooks(ctx context.Context, store Store, rows rowReader, batchSize int) (*types.ImportReport, error) {
+	i := &bookImporter{
+		store:     store,
+		report:    &types.ImportReport{},
+		batchSize: batchSize,
+		titles:    map[string]int{},
+		isbns:     map[string]int{},
+	}
+
+	if err := i.readCatalog(ctx); err != nil {
+		return nil, err
+	}
+
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return i.report, err
+		}
+
+		i.report.Rows++
+		if row.err != nil {
+			fail(i.report, row.num, row.err.Field, row.err.Message)
+			continue
+		}
+
+		book, fieldErr := rows.book(row)
+		if fieldErr != nil {
+			fail(i.report, row.num, fieldErr.Field, fieldErr.Message)
+			continue
+		}
+
+		if err := i.add(ctx, row.num, book); err != nil {
+			return i.report, err
+		}
+	}
+
+	return i.report, i.flush(ctx)
+}
+
+// readCatalog reads the titles and ISBNs already in the catalog
+func (i *bookImporter) readCatalog(ctx context.Context) error {
+	query := &datastore.BookQuery{Page: datastore.Page{Limit: datastore.MaxPageSize}}
+	for {
+		page, err := i.store.ListBook(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, book := range page.Books {
+			i.titles[book.Title] = 0
+			if book.ISBN != "" {
+				i.isbns[book.ISBN] = 0
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// add validates the book and adds it to the batch, adding the batch once it is full
+func (i *bookImporter) add(ctx context.Context, row int, book *types.Book) error {
+	book.Title = strings.TrimSpace(book.Title)
+	if book.Title == "" {
+		fail(i.report, row, "title", "must supply a title")
+		return nil
+	}
+
+	book.ISBN = datastore.NormalizeISBN(book.ISBN)
+	if book.ISBN != "" && !datastore.ValidISBN(book.ISBN) {
+		fail(i.report, row, "isbn", "must be a valid ISBN-10 or ISBN-13")
+		return nil
+	}
+
+	if len(book.Copies) > MaxCopies {
+		fail(i.report, row, "copies", fmt.Sprintf("must be at most %d copies", MaxCopies))
+		return nil
+	}
+
+	if seen, ok := i.titles[book.Title]; ok {
+		skip(i.report, row, "title", duplicateMessage("title", seen))
+		return nil
+	}
+
+	if seen, ok := i.isbns[book.ISBN]; ok && book.ISBN != "" {
+		skip(i.report, row, "isbn", duplicateMessage("ISBN", seen))
+		return nil
+	}
+
+	i.titles[book.Title] = row
+	if book.ISBN != "" {
+		i.isbns[book.ISBN] = row
+	}
+
+	i.batch = append(i.batch, bookRow{row: row, book: book})
+	if len(i.batch) < i.batchSize {
+		return nil
+	}
+
+	return i.flush(ctx)
+}
+
+// flush adds the batch in a single transaction. If the batch fails, such as when a book was added to the
+// catalog since it was read, its books are added one at a time so each row gets its own outcome.
+func (i *bookImporter) flush(ctx context.Context) error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+
+	batch := i.batch
+	i.batch = nil
+
+	books := make([]*types.Book, len(batch))
+	for j, row := range batch {
+		books[j] = row.book
+	}
+
+	if _, err := i.store.CreateBooks(ctx, books); err == nil {
+		i.report.Imported += len(batch)
+		return nil
+	}
+
+	for _, row := range batch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := i.store.CreateBook(ctx, row.book)
+		switch {
+		case err == nil:
+			i.report.Imported++
+		case err == datastore.ErrAlreadyExists:
+			skip(i.report, row.row, "", "title or ISBN already in the catalog")
+		default:
+			fail(i.report, row.row, "", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func duplicateMessage(field string, seenRow int) string {
+	if seenRow == 0 {
+		return field + " already in the catalog"
+	}
+
+	return fmt.Sprintf("%s repeats row %d", field, seenRow)
+}
+
+func importRequests(ctx context.Context, store Store, rows rowReader) (*types.ImportReport, error) {
+	requests, err := datastore.NewRequestImporter(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.ImportReport{}
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Rows++
+		if row.err != nil {
+			fail(report, row.num, row.err.Field, row.err.Message)
+			continue
+		}
+
+		request, fieldErr := rows.request(row)
+		if fieldErr == nil {
+			fieldErr = validateRequest(request)
+		}
+		if fieldErr != nil {
+			fail(report, row.num, fieldErr.Field, fieldErr.Message)
+			continue
+		}
+
+		created, err := requests.Import(ctx, request)
+		switch {
+		case err == datastore.ErrNotFound:
+			fail(report, row.num, "title", "title is not in the catalog")
+		case err != nil:
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return report, ctxErr
+			}
+			// A request that was made but not moved to its status still counts as imported
+			if created {
+				report.Imported++
+			}
+			fail(report, row.num, "", err.Error())
+		case created:
+			report.Imported++
+		default:
+			skip(report, row.num, "", "patron already requested the title")
+		}
+	}
+}
+
+func validateRequest(request *types.Request) *types.FieldError {
+	request.Title = strings.TrimSpace(request.Title)
+	switch {
+	case !strings.Contains(request.Email, "@"):
+		return &types.FieldError{Field: "email", Message: "must supply a valid email"}
+	case request.Title == "":
+		return &types.FieldError{Field: "title", Message: "must supply a title"}
+	case request.Status != "" && !datastore.KnownStatus(request.Status):
+		return &types.FieldError{Field: "status", Message: fmt.Sprintf("unknown status '%s'", request.Status)}
+	}
+
+	return nil
+}
+
+// row is a row read from the file
+type row struct {
+	num int
+	// err is set if the row can't be parsed at all
+	err *types.FieldError
+
+	// line is the JSON of a JSON Lines row
+	line []byte
+	// record is the fields of a CSV row, keyed by column
+	record map[string]string
+}
+
+// rowReader reads the rows of a file and converts them to books or requests
+type rowReader interface {
+	// next returns the next row, or io.EOF at the end of the file
+	next() (*row, error)
+	book(r *row) (*types.Book, *types.FieldError)
+	request(r *row) (*types.Request, *types.FieldError)
+}
+
+func newRowReader(r io.Reader, opts Options) (rowReader, error) {
+	switch opts.Format {
+	case FormatCSV:
+		return newCSVReader(r, opts.Kind)
+	case FormatJSONL, "":
+		return newJSONLReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown import format '%s', must be csv or jsonl", opts.Format)
+	}
+}
+
+// jsonlReader reads a JSON object per line
+type jsonlReader struct {
+	lines *bufio.Scanner
+	num   int
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, maxLineSize)
+	return &jsonlReader{lines: lines}
+}
+
+func (r *jsonlReader) next() (*row, error) {
+	for r.lines.Scan() {
+		r.num++
+		line := r.lines.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		return &row{num: r.num, line: append([]byte(nil), line...)}, nil
+	}
+
+	if err := r.lines.Err(); err != nil {
+		return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, r.num+1, err)
+	}
+
+	return nil, io.EOF
+}
+
+// jsonlBook is a book read from a JSON Lines file. Books are available unless the line says otherwise, like
+// the rows of a CSV file.
+type jsonlBook struct {
+	types.Book
+	Available *bool `json:"available"`
+}
+
+func (r *jsonlReader) book(row *row) (*types.Book, *types.FieldError) {
+	var line *jsonlBook
+	if err := json.Unmarshal(row.line, &line); err != nil || line == nil {
+		return nil, &types.FieldError{Message: "must be a JSON book"}
+	}
+
+	book := &line.Book
+	book.Available = line.Available == nil || *line.Available
+	return book, nil
+}
+
+func (r *jsonlReader) request(row *row) (*types.Request, *types.FieldError) {
+	var request *types.Request
+	if err := json.Unmarshal(row.line, &request); err != nil || request == nil {
+		return nil, &types.FieldError{Message: "must be a JSON request"}
+	}
+
+	return request, nil
+}
+
+// csvColumns are the columns of CSV files for each kind, and whether they are required
+var csvColumns = map[Kind]map[string]bool{
+	KindBooks:    {"title": true, "isbn": false, "copies": false, "available": false},
+	KindRequests: {"email": true, "title": true, "status": false},
+}
+
+// csvReader reads a CSV file with a header row naming the columns
+type csvReader struct {
+	records *csv.Reader
+	header  []string
+	num     int
+}
+
+func newCSVReader(r io.Reader, kind Kind) (*csvReader, error) {
+	if kind == "" {
+		kind = KindBooks
+	}
+
+	columns, ok := csvColumns[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown import kind '%s', must be books or requests", kind)
+	}
+
+	records := csv.NewReader(r)
+	records.TrimLeadingSpace = true
+
+	header, err := records.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing the header row", ErrInvalidFile)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	found := map[string]bool{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: unknown column '%s'", ErrInvalidFile, header[i])
+		}
+		if found[column] {
+			return nil, fmt.Errorf("%w: repeated column '%s'", ErrInvalidFile, column)
+		}
+
+		found[column] = true
+		header[i] = column
+	}
+
+	for column, required := range columns {
+		if required && !found[column] {
+			return nil, fmt.Errorf("%w: missing the %s column", ErrInvalidFile, column)
+		}
+	}
+
+	return &csvReader{records: records, header: header, num: 1}, nil
+}
+
+func (r *csvReader) next() (*row, error) {
+	record, err := r.records.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	r.num++
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount {
+		return &row{num: r.num, err: &types.FieldError{Message: fmt.Sprintf("must have %d fields", len(r.header))}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	fields := map[string]string{}
+	for i, column := range r.header {
+		fields[column] = strings.TrimSpace(record[i])
+	}
+
+	return &row{num: r.num, record: fields}, nil
+}
+
+func (r *csvReader) book(row *row) (*types.Book, *types.FieldError) {
+	book := &types.Book{Title: row.record["title"], ISBN: row.record["isbn"]}
+
+	available := true
+	if value := row.record["available"]; value != "" {
+		var err error
+		if available, err = strconv.ParseBool(value); err != nil {
+			return nil, &types.FieldError{Field: "available", Message: "must be true or false"}
+		}
+	}
+
+	copies := 1
+	if value := row.record["copies"]; value != "" {
+		var err error
+		if copies, err = strconv.Atoi(value); err != nil || copies < 1 || copies > MaxCopies {
+			return nil, &types.FieldError{Field: "copies", Message: fmt.Sprintf("must be a number from 1 to %d", MaxCopies)}
+		}
+	}
+
+	for ; copies > 0; copies-- {
+		book.Copies = append(book.Copies, &types.Copy{Available: available})
+	}
+
+	return book, nil
+}
+
+func (r *csvReader) request(row *row) (*types.Request, *types.FieldError) {
+	return &types.Request{
+		Email:  row.record["email"],
+		Title:  row.record["title"],
+		Status: types.RequestStatus(row.record["status"]),
+	}, nil
+}
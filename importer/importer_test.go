@@ -0,0 +1,214 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestImportBooks(t *testing.T) {
+	ctx := context.Background()
+
+	newStore := func(t *testing.T) *datastore.MemoryStore {
+		store := datastore.NewMemoryStore()
+		_, err := store.CreateBook(ctx, &types.Book{Title: "existing", ISBN: "9780306406157", Available: true})
+		require.NoError(t, err)
+		return store
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		store := newStore(t)
+
+		report, err := Import(ctx, store, strings.NewReader(`title,isbn,copies,available
+first,0-306-40615-2,2,
+second,,,false
+existing,,,
+third,978-0-306-40615-7,,
+first,,,
+,,,
+fourth,12345,,
+fifth,,zero,
+sixth,,1
+`), Options{Format: FormatCSV, BatchSize: 2})
+		require.NoError(t, err)
+
+		assert.Equal(t, &types.ImportReport{Rows: 9, Imported: 2, Skipped: 3, Failed: 4, Errors: []types.ImportError{
+			{Row: 4, Field: "title", Message: "title already in the catalog", Skipped: true},
+			{Row: 5, Field: "isbn", Message: "ISBN already in the catalog", Skipped: true},
+			{Row: 6, Field: "title", Message: "title repeats row 2", Skipped: true},
+			{Row: 7, Field: "title", Message: "must supply a title"},
+			{Row: 8, Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"},
+			{Row: 9, Field: "copies", Message: "must be a number from 1 to 1000"},
+			{Row: 10, Message: "must have 4 fields"},
+		}}, report)
+
+		page, err := store.ListBook(ctx, &datastore.BookQuery{Title: "first"})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 1)
+		assert.Equal(t, "0306406152", page.Books[0].ISBN, "Should store the normalized ISBN.")
+		assert.Len(t, page.Books[0].Copies, 2)
+
+		unavailable := false
+		page, err = store.ListBook(ctx, &datastore.BookQuery{Title: "second", Available: &unavailable})
+		require.NoError(t, err)
+		assert.Len(t, page.Books, 1)
+	})
+
+	t.Run("JSON Lines", func(t *testing.T) {
+		store := newStore(t)
+
+		report, err := Import(ctx, store, strings.NewReader(`{"title": "first", "available": true}
+
+{"title": "existing"}
+{"title": "second", "copies": [{"available": true}, {"available": false}]}
+{"title":
+{"title": "third"}
+{"title": "fourth", "available": false}
+`), Options{Format: FormatJSONL})
+		require.NoError(t, err)
+
+		assert.Equal(t, &types.ImportReport{Rows: 6, Imported: 4, Skipped: 1, Failed: 1, Errors: []types.ImportError{
+			{Row: 3, Field: "title", Message: "title already in the catalog", Skipped: true},
+			{Row: 5, Message: "must be a JSON book"},
+		}}, report)
+
+		page, err := store.ListBook(ctx, &datastore.BookQuery{Title: "second"})
+		require.NoError(t, err)
+		require.Len(t, page.Books, 1)
+		assert.Len(t, page.Books[0].Copies, 2)
+
+		// Like CSV rows, books are available unless the line says otherwise
+		for title, available := range map[string]bool{"third": true, "fourth": false} {
+			page, err := store.ListBook(ctx, &datastore.BookQuery{Title: title})
+			require.NoError(t, err)
+			require.Len(t, page.Books, 1)
+			require.Len(t, page.Books[0].Copies, 1)
+			assert.Equal(t, available, page.Books[0].Copies[0].Available, title)
+		}
+	})
+
+	t.Run("Invalid File", func(t *testing.T) {
+		for name, input := range map[string]string{
+			"Empty":          "",
+			"Unknown Column": "title,author\n",
+			"Missing Title":  "isbn,copies\n",
+			"Repeated":       "title,Title\n",
+			"Bare Quote":     "title\na \"quoted\" title\n",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := Import(ctx, newStore(t), strings.NewReader(input), Options{Format: FormatCSV})
+				assert.True(t, errors.Is(err, ErrInvalidFile), "Should be an invalid file, not %v.", err)
+			})
+		}
+
+		t.Run("Long Line", func(t *testing.T) {
+			input := `{"title": "` + strings.Repeat("a", maxLineSize) + `"}`
+			_, err := Import(ctx, newStore(t), strings.NewReader(input), Options{Format: FormatJSONL})
+			assert.True(t, errors.Is(err, ErrInvalidFile), "Should be an invalid file, not %v.", err)
+		})
+	})
+}
+
+// racingStore adds a book to the catalog before the first batch is added, as another import could
+type racingStore struct {
+	*datastore.MemoryStore
+	raced bool
+}
+
+func (s *racingStore) CreateBooks(ctx context.Context, books []*types.Book) ([]*types.Book, error) {
+	if !s.raced {
+		s.raced = true
+		if _, err := s.MemoryStore.CreateBook(ctx, &types.Book{Title: "raced"}); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.MemoryStore.CreateBooks(ctx, books)
+}
+
+func TestImportBooksRace(t *testing.T) {
+	ctx := context.Background()
+	store := &racingStore{MemoryStore: datastore.NewMemoryStore()}
+
+	report, err := Import(ctx, store, strings.NewReader("title\nfirst\nraced\nsecond\n"), Options{Format: FormatCSV})
+	require.NoError(t, err)
+	assert.Equal(t, &types.ImportReport{Rows: 3, Imported: 2, Skipped: 1, Errors: []types.ImportError{
+		{Row: 3, Message: "title or ISBN already in the catalog", Skipped: true},
+	}}, report, "Should add the rest of a failed batch one book at a time.")
+}
+
+func TestImportRequests(t *testing.T) {
+	ctx := context.Background()
+
+	store := datastore.NewMemoryStore()
+	for _, title := range []string{"first", "second", "third"} {
+		_, err := store.CreateBook(ctx, &types.Book{Title: title, Available: true})
+		require.NoError(t, err)
+	}
+	_, err := store.CreatePatron(ctx, &types.Patron{Email: "patron@example.com", Name: "Patron"})
+	require.NoError(t, err)
+	_, err = store.CreateRequest(ctx, &types.Request{Email: "patron@example.com", Title: "first"})
+	require.NoError(t, err)
+
+	t.Run("CSV", func(t *testing.T) {
+		report, err := Import(ctx, store, strings.NewReader(`email,title,status
+patron@example.com,first,
+patron@example.com,second,returned
+new@example.com,second,
+patron@example.com,missing,
+invalid,third,
+patron@example.com,third,lost
+`), Options{Format: FormatCSV, Kind: KindRequests})
+		require.NoError(t, err)
+
+		assert.Equal(t, &types.ImportReport{Rows: 6, Imported: 2, Skipped: 1, Failed: 3, Errors: []types.ImportError{
+			{Row: 2, Message: "patron already requested the title", Skipped: true},
+			{Row: 5, Field: "title", Message: "title is not in the catalog"},
+			{Row: 6, Field: "email", Message: "must supply a valid email"},
+			{Row: 7, Field: "status", Message: "unknown status 'lost'"},
+		}}, report)
+
+		page, err := store.ListRequest(ctx, &datastore.RequestQuery{Email: "patron@example.com", Statuses: []types.RequestStatus{types.RequestStatusReturned}})
+		require.NoError(t, err)
+		require.Len(t, page.Requests, 1)
+		assert.Equal(t, "second", page.Requests[0].Title)
+	})
+
+	t.Run("JSON Lines", func(t *testing.T) {
+		report, err := Import(ctx, store, strings.NewReader(`{"email": "patron@example.com", "title": "third", "status": "cancelled"}
+{"email": "patron@example.com", "title": "third"}
+[]
+`), Options{Format: FormatJSONL, Kind: KindRequests})
+		require.NoError(t, err)
+
+		assert.Equal(t, &types.ImportReport{Rows: 3, Imported: 1, Skipped: 1, Failed: 1, Errors: []types.ImportError{
+			{Row: 2, Message: "patron already requested the title", Skipped: true},
+			{Row: 3, Message: "must be a JSON request"},
+		}}, report)
+	})
+
+	t.Run("Unknown Column", func(t *testing.T) {
+		_, err := Import(ctx, store, strings.NewReader("title,isbn\n"), Options{Format: FormatCSV, Kind: KindRequests})
+		assert.True(t, errors.Is(err, ErrInvalidFile))
+	})
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, FormatCSV, FormatOf("books.CSV"))
+	assert.Equal(t, FormatJSONL, FormatOf("books.jsonl"))
+	assert.Equal(t, FormatJSONL, FormatOf("-"))
+
+	format, err := ParseFormat("CSV")
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSV, format)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
@@ -2,80 +2,177 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"github.com/samkreter/givedirectly/datastore"
-	"github.com/samkreter/givedirectly/types"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/samkreter/givedirectly/apiserver"
 	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/config"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/tracing"
 )
 
 var (
-	logLvl        string
-	pgHost, pgUser, pgPassword, pgDBName string
-	pgPort int
+	logLvl string
+
+	numToSeed    int
+	fixturesFile string
+	seedPatrons  int
+	seedRequests int
+	randomSeed   int64
+	storeType    string
+	policyFile   string
 
-	numToSeed int
+	bootstrapAPIKey string
+	dumpConfig      bool
 
 	serverConfig = &apiserver.Config{}
+	sqlConfig    = &datastore.SQLConfig{}
+	traceConfig  = &tracing.Config{}
+	loanPolicy   = datastore.DefaultLoanPolicy
 )
 
 func main() {
 	flag.StringVar(&logLvl, "log-level", "info", "the log level for the application")
 
 	flag.StringVar(&serverConfig.ServerAddr, "addr", "0.0.0.0:8080", "the address to expose the API server")
+	flag.StringVar(&serverConfig.AdminAddr, "admin-addr", "", "the address to expose the Prometheus metrics on. They are served at /metrics on the API address if unset")
 	flag.BoolVar(&serverConfig.EnableReqLogging, "enable-req-logging", true, "Enable logging for all incoming requests")
 	flag.BoolVar(&serverConfig.EnableReqCorrelation, "enable-req-corr", true, "Enable correlation for all incoming requests")
+	flag.BoolVar(&serverConfig.EnableTracing, "enable-tracing", false, "Enable tracing for all incoming requests and datastore queries")
+	flag.StringVar(&traceConfig.Exporter, "trace-exporter", "stdout", "where to export spans when tracing is enabled, either 'stdout' or 'file'")
+	flag.StringVar(&traceConfig.File, "trace-file", "traces.jsonl", "the file spans are appended to by the file exporter")
+	flag.Float64Var(&traceConfig.SampleRate, "trace-sample-rate", 1, "the fraction of new traces to sample, from 0 to 1")
+
+	// HTTP server timeouts
+	flag.DurationVar(&serverConfig.ReadHeaderTimeout, "read-header-timeout", 10*time.Second, "how long to wait for the headers of a request")
+	flag.DurationVar(&serverConfig.ReadTimeout, "read-timeout", 30*time.Second, "how long to wait for a whole request including its body")
+	flag.DurationVar(&serverConfig.WriteTimeout, "write-timeout", 30*time.Second, "how long a request can take from the end of its headers to the end of the response")
+	flag.DurationVar(&serverConfig.IdleTimeout, "idle-timeout", 2*time.Minute, "how long to keep idle keep-alive connections open")
+	flag.DurationVar(&serverConfig.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests to finish on shutdown")
+	flag.DurationVar(&serverConfig.ShutdownDelay, "shutdown-delay", 0, "how long to keep serving, while reporting not ready, before shutting down")
+
+	// Authentication configuration
+	flag.BoolVar(&serverConfig.EnableAuth, "enable-auth", true, "require an API key or bearer token for every request")
+	flag.StringVar(&serverConfig.TokenSecret, "token-secret", "", "the HMAC secret for signing bearer tokens, at least 32 characters. Bearer tokens are disabled without it")
+	flag.DurationVar(&serverConfig.TokenTTL, "token-ttl", time.Hour, "how long issued bearer tokens are valid for")
+	flag.StringVar(&bootstrapAPIKey, "bootstrap-api-key", "", "an API key to add to the store at startup, for local development")
 
 	// Postgres configuration
-	flag.StringVar(&pgUser, "pg-user", "librarystore", "the postgres user")
-	flag.StringVar(&pgPassword, "pg-password", "", "the postgres password")
-	flag.StringVar(&pgDBName, "pg-dbname", "librarystore", "the postgres dbname")
-	flag.StringVar(&pgHost, "pg-host", "0.0.0.0", "the postgres host")
-	flag.IntVar(&pgPort, "pg-port", 5432, "the postgres port")
-	flag.IntVar(&numToSeed, "seednum", 100, "the number of books to seed the db")
+	flag.StringVar(&sqlConfig.User, "pg-user", "librarystore", "the postgres user")
+	flag.StringVar(&sqlConfig.Password, "pg-password", "", "the postgres password")
+	flag.StringVar(&sqlConfig.DBName, "pg-dbname", "librarystore", "the postgres dbname")
+	flag.StringVar(&sqlConfig.Host, "pg-host", "0.0.0.0", "the postgres host")
+	flag.IntVar(&sqlConfig.Port, "pg-port", 5432, "the postgres port")
+	flag.DurationVar(&sqlConfig.ConnectTimeout, "pg-connect-timeout", 30*time.Second, "how long to keep retrying to connect to postgres on startup")
+	flag.IntVar(&sqlConfig.MaxOpenConns, "pg-max-open-conns", 0, "the most connections open to postgres, unlimited if 0")
+	flag.IntVar(&sqlConfig.MaxIdleConns, "pg-max-idle-conns", 2, "the most idle connections to postgres kept open for reuse")
+	flag.DurationVar(&sqlConfig.ConnMaxLifetime, "pg-conn-max-lifetime", 0, "how long a connection to postgres is reused before it is closed, forever if 0")
+
+	// Seed data, added by the seed command and to the in-memory store on startup
+	flag.IntVar(&numToSeed, "seednum", 100, "the number of generated books to seed")
+	flag.StringVar(&fixturesFile, "fixtures", "", "a JSON file of books, patrons and requests to seed instead of the test books")
+	flag.IntVar(&seedPatrons, "seed-patrons", 0, "the number of generated patrons to seed")
+	flag.IntVar(&seedRequests, "seed-requests", 0, "the number of generated requests to seed, made by the generated patrons for the generated books")
+	flag.Int64Var(&randomSeed, "random-seed", 1, "the random seed for generated seed data, the same seed always generates the same data")
+	flag.StringVar(&storeType, "store", "postgres", "the datastore backend to use, either 'postgres' or 'memory'")
+
+	// Loan configuration
+	flag.DurationVar(&loanPolicy.LoanDuration, "loan-duration", loanPolicy.LoanDuration, "how long a copy is lent before it is due back")
+	flag.IntVar(&loanPolicy.MaxRenewals, "max-renewals", loanPolicy.MaxRenewals, "the maximum number of times a loan can be renewed")
+	flag.StringVar(&policyFile, "policy-file", "", "a JSON file of borrowing policies checked for every request")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), commandUsage()+"\nflags:\n")
+		flag.PrintDefaults()
+	}
 
-	flag.Parse()
+	flag.BoolVar(&dumpConfig, "dump-config", false, "print the effective configuration, with secrets redacted, and exit")
+
+	// Every flag can also be set by a GIVEDIRECTLY_* environment variable or in the -config file
+	loader := config.NewLoader(flag.CommandLine, "GIVEDIRECTLY_")
+	for _, secret := range []string{"pg-password", "token-secret", "bootstrap-api-key"} {
+		loader.Secret(secret)
+	}
+	loader.CommandLineOnly("dump-config")
 
 	ctx := context.Background()
 	logger := log.G(ctx)
 
+	if err := loader.Load(os.Args[1:]); err != nil {
+		logger.Fatalf("failed to load configuration: %v", err)
+	}
+
+	if dumpConfig {
+		if err := loader.Dump(os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
 	if err := log.SetLogLevel(logLvl); err != nil {
 		logger.Errorf("failed to set log level to : '%s'", logLvl)
 	}
 
-	// Ensure there's enough time for the postgres db to initialize. In prod, i'd use either retries or if it's deployed
-	// to Kubernetes, let the pod restarts handle it.
-	time.Sleep(time.Second * 3)
+	if err := validateConfig(); err != nil {
+		logger.Fatalf("invalid configuration: %v", err)
+	}
 
-	sqlStore, err := datastore.NewSQLStore(pgUser, pgDBName, pgPassword, pgHost, pgPort)
-	if err != nil {
-		logger.Fatal(err)
+	name, args := defaultCommand, []string(nil)
+	if flag.NArg() > 0 {
+		name, args = flag.Arg(0), flag.Args()[1:]
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprint(os.Stderr, commandUsage())
+		logger.Fatalf("unknown command: '%s'", name)
 	}
 
-	// Create all the required tables in the DB
-	if err := sqlStore.EnsureDB(); err != nil {
+	if err := cmd.run(ctx, args); err != nil {
 		logger.Fatal(err)
 	}
+}
 
-	testBooks := []*types.Book{
-		{Available: true, Title: "testbook"},
-		{Available: true, Title: "testbook2"},
-		{Available: false, Title: "testbook3"},
+// validateConfig checks the merged configuration, returning every problem found
+func validateConfig() error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
 	}
 
-	// Seed the DB with some books. Use 3 known title books plus many randomly generated books
-	if err := sqlStore.SeedDB(numToSeed, testBooks...); err != nil {
-		logger.Fatal(err)
+	check(apiserver.ValidateConfig(serverConfig))
+
+	switch storeType {
+	case "postgres":
+		check(sqlConfig.Validate())
+	case "memory":
+	default:
+		check(fmt.Errorf("unknown store type: '%s'", storeType))
 	}
 
-	server, err := apiserver.NewServer(sqlStore, serverConfig)
-	if err != nil {
-		logger.Fatal(err)
+	if err := loanPolicy.Validate(); err != nil {
+		check(fmt.Errorf("invalid loan policy: %w", err))
 	}
 
-	if err = server.Run(); err != nil {
-		logger.Fatal(err)
+	if numToSeed < 0 || seedPatrons < 0 || seedRequests < 0 {
+		check(errors.New("the number of books, patrons and requests to seed can not be negative"))
+	}
+
+	if serverConfig.EnableTracing {
+		check(tracing.ValidateConfig(traceConfig))
 	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
 }
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("Counter", func(t *testing.T) {
+		r := NewRegistry()
+		c := r.NewCounter("test_requests_total", "Requests handled.", "method", "code")
+
+		c.Inc("GET", "200")
+		c.Inc("GET", "200")
+		c.Add(3, "POST", "500")
+
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+
+		assert.Equal(t, `# HELP test_requests_total Requests handled.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",code="200"} 2
+test_requests_total{method="POST",code="500"} 3
+`, buf.String())
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		r := NewRegistry()
+		h := r.NewHistogram("test_duration_seconds", "How long it took.", []float64{0.1, 1}, "op")
+
+		h.Observe(0.05, "get")
+		h.Observe(0.1, "get")
+		h.Observe(0.5, "get")
+		h.Observe(2, "get")
+
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+
+		assert.Equal(t, `# HELP test_duration_seconds How long it took.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{op="get",le="0.1"} 2
+test_duration_seconds_bucket{op="get",le="1"} 3
+test_duration_seconds_bucket{op="get",le="+Inf"} 4
+test_duration_seconds_sum{op="get"} 2.65
+test_duration_seconds_count{op="get"} 4
+`, buf.String())
+	})
+
+	t.Run("Funcs", func(t *testing.T) {
+		r := NewRegistry()
+		open := 3.0
+		r.NewGaugeFunc("test_open", "Open connections.", func() float64 { return open })
+		r.NewCounterFunc("test_waits_total", "Waits for a connection.", func() float64 { return 7 })
+
+		open = 4
+
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+
+		assert.Equal(t, `# HELP test_open Open connections.
+# TYPE test_open gauge
+test_open 4
+# HELP test_waits_total Waits for a connection.
+# TYPE test_waits_total counter
+test_waits_total 7
+`, buf.String())
+	})
+
+	t.Run("Escapes Label Values", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounter("test_total", "Test.", "path").Inc("/a\"b\\c\n")
+
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+
+		assert.Contains(t, buf.String(), `test_total{path="/a\"b\\c\n"} 1`)
+	})
+
+	t.Run("Wrong Number Of Labels", func(t *testing.T) {
+		c := NewRegistry().NewCounter("test_total", "Test.", "method")
+
+		assert.Panics(t, func() { c.Inc() })
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounter("test_total", "Test.").Inc()
+
+		testServer := httptest.NewServer(r.Handler())
+		defer testServer.Close()
+
+		resp, err := http.Get(testServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	})
+}
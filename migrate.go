@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+)
+
+const migrateUsage = "usage: givedirectly [flags] migrate [up | down [steps] | status]"
+
+// runMigrate runs the migrate subcommand against the configured postgres database
+func runMigrate(ctx context.Context, args []string) error {
+	sqlStore, err := openSQLStore(ctx, "migrate")
+	if err != nil {
+		return err
+	}
+	defer sqlStore.Close()
+
+	migrator, err := sqlStore.Migrator()
+	if err != nil {
+		return err
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		log.G(ctx).Infof("applied %d migrations", applied)
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.Errorf("invalid number of steps: '%s'", args[1])
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+
+		log.G(ctx).Infof("rolled back %d migrations", rolledBack)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const requestsUsage = "usage: givedirectly [flags] requests [list [-status statuses] [-email email] [-title title] [-limit n] | cancel id]"
+
+// runRequests runs the requests subcommand against the configured postgres database
+func runRequests(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(requestsUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("requests list", flag.ContinueOnError)
+		statuses := flags.String("status", "", "only list requests in these comma separated statuses")
+		email := flags.String("email", "", "only list requests made by the patron with the email")
+		title := flags.String("title", "", "only list requests for titles containing it")
+		limit := flags.Int("limit", 0, "the most requests to list, all of them if 0")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() > 0 || *limit < 0 {
+			return errors.New(requestsUsage)
+		}
+
+		query := &datastore.RequestQuery{Email: *email, Title: *title}
+		for _, status := range strings.Split(*statuses, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				query.Statuses = append(query.Statuses, types.RequestStatus(status))
+			}
+		}
+
+		sqlStore, err := openSQLStore(ctx, "requests")
+		if err != nil {
+			return err
+		}
+		defer sqlStore.Close()
+
+		return listRequests(ctx, sqlStore, query, *limit, os.Stdout)
+	case "cancel":
+		if len(args) != 2 {
+			return errors.New(requestsUsage)
+		}
+
+		requestID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.Errorf("invalid request id: '%s'", args[1])
+		}
+
+		sqlStore, err := openSQLStore(ctx, "requests")
+		if err != nil {
+			return err
+		}
+		defer sqlStore.Close()
+
+		// Like POST /request/{id}/cancel, and unlike DELETE /request/{id}, a checked out request is not
+		// closed as returned since the copy has not been given back
+		_, err = sqlStore.UpdateRequestStatus(ctx, requestID, types.RequestStatusCancelled)
+		switch {
+		case errors.Is(err, datastore.ErrInvalidTransition):
+			return errors.Errorf("failed to cancel request %d, only requests that are waiting or requested can be cancelled", requestID)
+		case err != nil:
+			return errors.Wrapf(err, "failed to cancel request %d", requestID)
+		}
+
+		fmt.Printf("cancelled request %d\n", requestID)
+	default:
+		return errors.New(requestsUsage)
+	}
+
+	return nil
+}
+
+// listRequests writes a table of the requests matching the query, at most limit of them unless it is 0
+func listRequests(ctx context.Context, store apiserver.LibraryStore, query *datastore.RequestQuery, limit int, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tTITLE\tSTATUS\tDUE AT\tCREATED AT")
+
+	listed := 0
+	query.Limit = datastore.MaxPageSize
+	for {
+		page, err := store.ListRequest(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, request := range page.Requests {
+			if limit > 0 && listed == limit {
+				return w.Flush()
+			}
+
+			dueAt := "-"
+			if request.DueAt != nil {
+				dueAt = request.DueAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", request.ID, request.Email, request.Title, request.Status, dueAt, request.CreatedAt.Format(time.RFC3339))
+			listed++
+		}
+
+		if page.NextCursor == "" {
+			return w.Flush()
+		}
+		query.Cursor = page.NextCursor
+	}
+}
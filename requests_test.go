@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+func TestListRequests(t *testing.T) {
+	ctx := context.Background()
+
+	store := datastore.NewMemoryStore()
+	_, err := datastore.Seed(ctx, store, &datastore.Fixtures{Books: testBooks})
+	require.NoError(t, err)
+
+	for _, email := range []string{"first@example.com", "second@example.com", "third@example.com"} {
+		_, err := store.CreateRequest(ctx, &types.Request{Title: "testbook", Email: email})
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, listRequests(ctx, store, &datastore.RequestQuery{
+		Statuses: []types.RequestStatus{types.RequestStatusWaiting},
+	}, 1, &buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2, "Should list the header and at most the limit.")
+	assert.Contains(t, lines[1], "second@example.com")
+	assert.Contains(t, lines[1], "waiting")
+
+	err = listRequests(ctx, store, &datastore.RequestQuery{Statuses: []types.RequestStatus{"lost"}}, 0, &buf)
+	assert.ErrorIs(t, err, datastore.ErrInvalidQuery)
+}
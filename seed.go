@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const seedUsage = "usage: givedirectly [-fixtures file] [-seednum n] [-seed-patrons n] [-seed-requests n] [-random-seed n] [flags] seed"
+
+// testBooks are seeded before the generated books, when there is no fixture file, so there are known
+// titles to request
+var testBooks = []*types.Book{
+	{Available: true, Title: "testbook"},
+	{Available: true, Title: "testbook2"},
+	{Available: false, Title: "testbook3"},
+}
+
+// runSeed applies any pending migrations and adds the seed data to the configured postgres database. Data
+// that is already there is skipped, so seeding again with the same flags adds nothing.
+func runSeed(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New(seedUsage)
+	}
+
+	sqlStore, err := openSQLStore(ctx, "seed")
+	if err != nil {
+		return err
+	}
+	defer sqlStore.Close()
+
+	// Seeding a new database creates its tables first, like the server does when it starts
+	if err := sqlStore.Migrate(ctx); err != nil {
+		return err
+	}
+
+	_, err = seed(ctx, sqlStore)
+	return err
+}
+
+// seed adds the -fixtures file, or the test books, and the generated data to the store
+func seed(ctx context.Context, store datastore.Seeder) (*datastore.SeedSummary, error) {
+	fixtures := &datastore.Fixtures{Books: testBooks}
+	if fixturesFile != "" {
+		var err error
+		if fixtures, err = datastore.LoadFixtures(fixturesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	fixtures.Append(datastore.GenerateFixtures(datastore.GenerateOptions{
+		Seed:     randomSeed,
+		Books:    numToSeed,
+		Patrons:  seedPatrons,
+		Requests: seedRequests,
+	}))
+
+	summary, err := datastore.Seed(ctx, store, fixtures)
+	if err != nil {
+		return summary, err
+	}
+
+	log.G(ctx).Infof("seeded %d books, %d copies, %d patrons and %d requests", summary.Books, summary.Copies, summary.Patrons, summary.Requests)
+	return summary, nil
+}
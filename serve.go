@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/samkreter/go-core/log"
+
+	"github.com/samkreter/givedirectly/apiserver"
+	"github.com/samkreter/givedirectly/auth"
+	"github.com/samkreter/givedirectly/datastore"
+	"github.com/samkreter/givedirectly/tracing"
+	"github.com/samkreter/givedirectly/types"
+)
+
+const serveUsage = "usage: givedirectly [flags] serve"
+
+// runServe runs the API server until it receives SIGINT or SIGTERM
+func runServe(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New(serveUsage)
+	}
+
+	logger := log.G(ctx)
+
+	if serverConfig.EnableTracing {
+		stopTracing, err := tracing.Start(traceConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to start tracing")
+		}
+		defer stopTracing()
+	}
+
+	var policies []datastore.Policy
+	if policyFile != "" {
+		policyConfig, err := datastore.LoadPolicyConfig(policyFile)
+		if err != nil {
+			return err
+		}
+		policies = policyConfig.Policies()
+	}
+
+	var store apiserver.LibraryStore
+	switch storeType {
+	case "postgres":
+		// Retries until postgres accepts connections, which may take a while when they start together
+		sqlStore, err := datastore.NewSQLStore(ctx, sqlConfig)
+		if err != nil {
+			return err
+		}
+
+		// Apply any pending schema migrations. Replicas starting together wait on a lock in the DB.
+		// Postgres is only seeded by the seed command, so restarts don't add books.
+		if err := sqlStore.Migrate(ctx); err != nil {
+			sqlStore.Close()
+			return err
+		}
+
+		sqlStore.SetLoanPolicy(loanPolicy)
+		sqlStore.SetPolicies(policies...)
+		store = sqlStore
+	case "memory":
+		memStore := datastore.NewMemoryStore()
+		memStore.SetLoanPolicy(loanPolicy)
+
+		// The in-memory store starts empty on every run so always seed it, before the borrowing policies
+		// can refuse the seeded requests
+		if _, err := seed(ctx, memStore); err != nil {
+			return err
+		}
+
+		memStore.SetPolicies(policies...)
+		store = memStore
+	default:
+		return errors.Errorf("unknown store type: '%s'", storeType)
+	}
+
+	// The store is closed after the server stops so in-flight requests can still use it
+	if closer, ok := store.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logger.Errorf("failed to close the store: %v", err)
+			}
+		}()
+	}
+
+	if bootstrapAPIKey != "" {
+		_, err := store.CreateAPIKey(ctx, &types.APIKey{Name: "bootstrap", Role: string(auth.RoleAdmin), Hash: auth.HashAPIKey(bootstrapAPIKey)})
+		if err != nil && err != datastore.ErrAlreadyExists {
+			return err
+		}
+	}
+
+	server, err := apiserver.NewServer(store, serverConfig)
+	if err != nil {
+		return err
+	}
+
+	// Stop on SIGINT or SIGTERM, giving in-flight requests time to finish
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx); err != nil {
+		return err
+	}
+
+	logger.Info("Request API Server stopped")
+	return nil
+}
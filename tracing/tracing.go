@@ -0,0 +1,160 @@
+// Package tracing sets up OpenCensus tracing and the exporters finished spans are sent to
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// Config configuration for tracing
+type Config struct {
+	// Exporter the name of the exporter spans are sent to, such as "stdout" or "file"
+	Exporter string
+	// File the file the file exporter appends spans to
+	File string
+	// SampleRate the fraction of new traces that are sampled, from 0 to 1. Traces sampled by the caller,
+	// as given by the propagated trace headers, are always sampled.
+	SampleRate float64
+}
+
+// NewExporter creates an exporter from the configuration. The returned func flushes and closes the
+// exporter once tracing stops.
+type NewExporter func(config *Config) (trace.Exporter, func() error, error)
+
+var (
+	exportersMu sync.Mutex
+	exporters   = map[string]NewExporter{
+		"stdout": newStdoutExporter,
+		"file":   newFileExporter,
+	}
+)
+
+// RegisterExporter makes an exporter available by name, so tracing can be sent to other backends
+func RegisterExporter(name string, newExporter NewExporter) {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+
+	exporters[name] = newExporter
+}
+
+// Start starts sampling traces and sending them to the configured exporter. The returned func stops
+// exporting and closes the exporter.
+func Start(config *Config) (func() error, error) {
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
+	}
+
+	exportersMu.Lock()
+	newExporter, ok := exporters[config.Exporter]
+	exportersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+
+	exporter, closeExporter, err := newExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(config.SampleRate)})
+	trace.RegisterExporter(exporter)
+
+	return func() error {
+		trace.UnregisterExporter(exporter)
+		return closeExporter()
+	}, nil
+}
+
+// ValidateConfig checks the tracing configuration, without creating the exporter
+func ValidateConfig(config *Config) error {
+	if config == nil {
+		return errors.New("missing tracing configuration")
+	}
+
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return errors.New("trace sample rate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+func newStdoutExporter(config *Config) (trace.Exporter, func() error, error) {
+	return NewWriterExporter(os.Stdout), func() error { return nil }, nil
+}
+
+func newFileExporter(config *Config) (trace.Exporter, func() error, error) {
+	if config.File == "" {
+		return nil, nil, errors.New("must supply a file for the file trace exporter")
+	}
+
+	f, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewWriterExporter(f), f.Close, nil
+}
+
+// Span is a finished span as written by the writer exporter
+type Span struct {
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Start        time.Time              `json:"start"`
+	Duration     string                 `json:"duration"`
+	Status       string                 `json:"status,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Annotations  []string               `json:"annotations,omitempty"`
+}
+
+// writerExporter writes every span as a line of JSON
+type writerExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns an exporter that writes every span to w as a line of JSON, for reading traces
+// locally without a tracing backend
+func NewWriterExporter(w io.Writer) trace.Exporter {
+	return &writerExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *writerExporter) ExportSpan(data *trace.SpanData) {
+	span := &Span{
+		TraceID:    data.TraceID.String(),
+		SpanID:     data.SpanID.String(),
+		Name:       data.Name,
+		Start:      data.StartTime,
+		Duration:   data.EndTime.Sub(data.StartTime).String(),
+		Attributes: data.Attributes,
+	}
+
+	if data.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = data.ParentSpanID.String()
+	}
+
+	if data.Code != trace.StatusCodeOK {
+		span.Status = data.Message
+		if span.Status == "" {
+			span.Status = fmt.Sprintf("code %d", data.Code)
+		}
+	}
+
+	for _, annotation := range data.Annotations {
+		span.Annotations = append(span.Annotations, annotation.Message)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Exporting can't fail the traced operation, so spans that can't be written are dropped
+	_ = e.enc.Encode(span)
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewWriterExporter(&buf)
+
+	ctx, parent := trace.StartSpan(context.Background(), "GET /book/{id}", trace.WithSampler(trace.AlwaysSample()))
+	_, child := trace.StartSpan(ctx, "sql SELECT books")
+	child.AddAttributes(trace.StringAttribute("db.statement", "SELECT id, title FROM books WHERE id=$1"))
+	child.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: "connection refused"})
+
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	child.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var childSpan, parentSpan Span
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &childSpan))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &parentSpan))
+
+	assert.Equal(t, "sql SELECT books", childSpan.Name)
+	assert.Equal(t, parentSpan.TraceID, childSpan.TraceID)
+	assert.Equal(t, parentSpan.SpanID, childSpan.ParentSpanID)
+	assert.Equal(t, "connection refused", childSpan.Status)
+	assert.Equal(t, "SELECT id, title FROM books WHERE id=$1", childSpan.Attributes["db.statement"])
+
+	assert.Empty(t, parentSpan.ParentSpanID)
+	assert.Empty(t, parentSpan.Status)
+}
+
+func TestStart(t *testing.T) {
+	t.Run("File Exporter", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "traces.jsonl")
+
+		stop, err := Start(&Config{Exporter: "file", File: file, SampleRate: 1})
+		require.NoError(t, err)
+
+		_, span := trace.StartSpan(context.Background(), "test span")
+		span.End()
+
+		require.NoError(t, stop())
+
+		b, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), `"name":"test span"`)
+	})
+
+	t.Run("Registered Exporter", func(t *testing.T) {
+		var buf bytes.Buffer
+		RegisterExporter("buffer", func(*Config) (trace.Exporter, func() error, error) {
+			return NewWriterExporter(&buf), func() error { return nil }, nil
+		})
+
+		stop, err := Start(&Config{Exporter: "buffer", SampleRate: 1})
+		require.NoError(t, err)
+
+		_, span := trace.StartSpan(context.Background(), "test span")
+		span.End()
+
+		require.NoError(t, stop())
+		assert.Contains(t, buf.String(), `"name":"test span"`)
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		for _, config := range []*Config{
+			nil,
+			{Exporter: "unknown"},
+			{Exporter: "file"},
+			{Exporter: "stdout", SampleRate: 2},
+			{Exporter: "stdout", SampleRate: -1},
+		} {
+			_, err := Start(config)
+			assert.Error(t, err, "Should reject %+v.", config)
+		}
+	})
+}
@@ -1,15 +1,212 @@
 package types
 
+import "time"
+
+// RequestStatus is the lifecycle state of a request
+type RequestStatus string
+
+const (
+	// RequestStatusWaiting the book was unavailable and the request is in the book's waitlist
+	RequestStatusWaiting RequestStatus = "waiting"
+	// RequestStatusRequested the book is reserved for the patron but not yet picked up
+	RequestStatusRequested RequestStatus = "requested"
+	// RequestStatusCheckedOut the patron has the book
+	RequestStatusCheckedOut RequestStatus = "checked_out"
+	// RequestStatusReturned the patron returned the book
+	RequestStatusReturned RequestStatus = "returned"
+	// RequestStatusCancelled the request was cancelled before the book was checked out
+	RequestStatusCancelled RequestStatus = "cancelled"
+)
 
 type Request struct {
-	ID int `json:"id"`
-	Email string `json:"email"`
-	Title string `json:"title"`
+	ID     int    `json:"id"`
+	Email  string `json:"email"`
+	Title  string `json:"title"`
+	BookID int    `json:"bookId,omitempty"`
+	// PatronID is the patron making the request. Requests made with only an email are made for the
+	// patron with that email, who is registered if they are new.
+	PatronID int `json:"patronId,omitempty"`
+	// CopyID is the copy of the book lent to the request. It is unset while the request is waiting.
+	CopyID int           `json:"copyId,omitempty"`
+	Status RequestStatus `json:"status,omitempty"`
+	// DueAt is when the copy is due back. It is set when the request is lent a copy.
+	DueAt *time.Time `json:"dueAt,omitempty"`
+	// Renewals is the number of times the loan has been renewed
+	Renewals  int       `json:"renewals"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Patron is a library member. Emails are stored lower case and are unique.
+type Patron struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKey is a credential for calling the API. Only the hash of the key is stored.
+type APIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// PatronID is the patron the key acts for, if any
+	PatronID int `json:"patronId,omitempty"`
+	// Role is what the key is allowed to do, one of patron, librarian or admin
+	Role string `json:"role"`
+	// Key is the key itself. It is only returned when the key is created.
+	Key       string    `json:"key,omitempty"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
+// Token is a bearer token issued to an API key
+type Token struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Book is a title in the catalog. The library may own several physical copies of it.
 type Book struct {
 	ID int `json:"id"`
-	Available bool `json:"available"`
-	Title string `json:"title"`
+	// Available is true when at least one copy is free. When returned from creating a request it
+	// reports whether a copy was free for the request.
+	Available bool   `json:"available"`
+	Title     string `json:"title"`
+	// ISBN is the ISBN-10 or ISBN-13 of the book, without hyphens or spaces. It is optional and unique.
+	ISBN string `json:"isbn,omitempty"`
+	// TimeRequested is the most recent time a copy was requested
+	TimeRequested string  `json:"timestamp"`
+	Copies        []*Copy `json:"copies,omitempty"`
+}
+
+// Copy is a single physical copy of a book
+type Copy struct {
+	ID            int    `json:"id"`
+	BookID        int    `json:"bookId"`
+	Available     bool   `json:"available"`
 	TimeRequested string `json:"timestamp"`
 }
+
+// QueuePosition is where a request is in the waitlist for its book
+type QueuePosition struct {
+	RequestID int           `json:"requestId"`
+	Status    RequestStatus `json:"status"`
+	// Position is 1 for the next request to get the book. It is 0 once the request is no longer waiting.
+	Position int `json:"position"`
+}
+
+// ErrorCode is a stable, machine readable code for an API error
+type ErrorCode string
+
+const (
+	// ErrorCodeInvalidRequest the request body or a path parameter is malformed
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrorCodeValidationFailed fields of the request body are invalid, listed in the problem errors
+	ErrorCodeValidationFailed ErrorCode = "validation_failed"
+	// ErrorCodeInvalidQuery a listing query parameter is invalid
+	ErrorCodeInvalidQuery ErrorCode = "invalid_query"
+	// ErrorCodeUnauthorized the request is not authenticated
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeForbidden the caller is not allowed to make the request
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeNotFound the resource or route does not exist
+	ErrorCodeNotFound ErrorCode = "not_found"
+	// ErrorCodeMethodNotAllowed the route does not support the method
+	ErrorCodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	// ErrorCodeAlreadyExists a resource with the same unique field already exists
+	ErrorCodeAlreadyExists ErrorCode = "already_exists"
+	// ErrorCodeInvalidTransition the request can not move to the status from its current status
+	ErrorCodeInvalidTransition ErrorCode = "invalid_transition"
+	// ErrorCodeInUse the book or copy is held by an open request
+	ErrorCodeInUse ErrorCode = "in_use"
+	// ErrorCodeRenewalLimit the loan has been renewed the maximum number of times
+	ErrorCodeRenewalLimit ErrorCode = "renewal_limit"
+	// ErrorCodeHoldQueue the loan can not be renewed while others are waiting for the book
+	ErrorCodeHoldQueue ErrorCode = "hold_queue"
+	// ErrorCodeUnknownPatron the patron the request is for does not exist
+	ErrorCodeUnknownPatron ErrorCode = "unknown_patron"
+	// ErrorCodeLimitExceeded a borrowing limit refused the request
+	ErrorCodeLimitExceeded ErrorCode = "limit_exceeded"
+	// ErrorCodeRestricted a borrowing restriction refused the request
+	ErrorCodeRestricted ErrorCode = "restricted"
+	// ErrorCodeTooLarge the request body is larger than the route accepts
+	ErrorCodeTooLarge ErrorCode = "too_large"
+	// ErrorCodeInternal the server failed, the correlation ID identifies the failure in the logs
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// Problem is an RFC 7807 problem details error response, sent as application/problem+json
+type Problem struct {
+	// Type is a URI for the kind of problem, about:blank when omitted
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+
+	Code ErrorCode `json:"code"`
+	// CorrelationID identifies the request in the server logs
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Errors are the invalid fields of a validation_failed problem
+	Errors []FieldError `json:"errors,omitempty"`
+	// Report is what a bulk import did before it failed. The batches it imported are kept.
+	Report *ImportReport `json:"report,omitempty"`
+}
+
+// FieldError is an invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// HealthStatus is whether the apiserver, or a dependency of it, can serve requests
+type HealthStatus string
+
+const (
+	// HealthStatusOK the apiserver or dependency can serve requests
+	HealthStatusOK HealthStatus = "ok"
+	// HealthStatusUnavailable the apiserver or dependency can't serve requests
+	HealthStatusUnavailable HealthStatus = "unavailable"
+)
+
+// Health is the health of the apiserver and the result of every check of its dependencies
+type Health struct {
+	Status HealthStatus            `json:"status"`
+	Checks map[string]*HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of checking a dependency of the apiserver
+type HealthCheck struct {
+	Status HealthStatus `json:"status"`
+	// Detail describes the result, such as why the check failed
+	Detail string `json:"detail,omitempty"`
+	// Duration is how long the check took, such as 1.5ms
+	Duration string `json:"duration"`
+}
+
+// ImportReport is the outcome of importing a file of books or requests
+type ImportReport struct {
+	// Rows is the number of rows read, not counting the CSV header or blank lines
+	Rows int `json:"rows"`
+	// Imported is the number of rows added to the library
+	Imported int `json:"imported"`
+	// Skipped is the number of rows already in the library, or repeating an earlier row
+	Skipped int `json:"skipped"`
+	// Failed is the number of rows that are invalid or could not be added
+	Failed int `json:"failed"`
+	// Errors are the skipped and failed rows, in the order they were read
+	Errors []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError is a row of an import file that was not imported
+type ImportError struct {
+	// Row is the line of a JSON Lines file, or the record of a CSV file with the header as record 1
+	Row int `json:"row"`
+	// Field is the invalid field, if there is one
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	// Skipped is true if the row was left out because it is already in the library, rather than failing
+	Skipped bool `json:"skipped,omitempty"`
+}